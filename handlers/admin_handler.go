@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"go-microservice/utils"
+)
+
+// defaultErrorGroupLimit caps how many error groups GET /admin/errors
+// returns unless limit says otherwise
+const defaultErrorGroupLimit = 100
+
+// AdminHandler handles operational HTTP requests. All of its endpoints
+// require the admin token.
+type AdminHandler struct {
+	errors *utils.ErrorAggregator
+}
+
+// NewAdminHandler creates a new AdminHandler
+func NewAdminHandler() *AdminHandler {
+	return &AdminHandler{
+		errors: utils.GetErrorHandler().Aggregator(),
+	}
+}
+
+// ErrorGroupsResponse lists aggregated errors seen within a window
+type ErrorGroupsResponse struct {
+	Window string             `json:"window"`
+	Since  time.Time          `json:"since"`
+	Groups []utils.ErrorGroup `json:"groups"`
+	Count  int                `json:"count"`
+}
+
+// ListErrors handles GET /admin/errors. It returns the error groups seen
+// within window (a duration, default and at most the aggregation
+// retention), busiest first, each with first and last seen times, recent
+// samples and per-window counts. Filters: operation; limit caps the
+// number of groups (default 100).
+func (h *AdminHandler) ListErrors(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminToken(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	window := h.errors.Retention()
+	if value := query.Get("window"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid window")
+			return
+		}
+		window = min(d, window)
+	}
+	limit := defaultErrorGroupLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	since := time.Now().Add(-window)
+	groups := h.errors.Groups(since, query.Get("operation"))
+	if len(groups) > limit {
+		groups = groups[:limit]
+	}
+
+	writeJSON(w, http.StatusOK, ErrorGroupsResponse{
+		Window: window.String(),
+		Since:  since,
+		Groups: groups,
+		Count:  len(groups),
+	})
+}
+
+// RegisterRoutes registers all admin routes with the router
+func (h *AdminHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/admin/errors", h.ListErrors).Methods("GET")
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"go-microservice/utils"
+)
+
+// Paging limits for GET /api/audit
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+	// auditExportPage is how many entries an export reads and flushes at once
+	auditExportPage = 1000
+)
+
+// AuditHandler handles HTTP requests for the audit trail. All of its
+// endpoints require the admin token.
+type AuditHandler struct {
+	store *utils.AuditStore
+}
+
+// NewAuditHandler creates a new AuditHandler
+func NewAuditHandler() *AuditHandler {
+	return &AuditHandler{
+		store: utils.GetAuditLogger().Store(),
+	}
+}
+
+// AuditListResponse is a page of audit entries, newest first
+type AuditListResponse struct {
+	Entries    []utils.LogEntry `json:"entries"`
+	Count      int              `json:"count"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// ListEntries handles GET /api/audit. Filters: user_id, action, actor,
+// outcome, request_id, and since/until as RFC 3339 times. Pages hold limit
+// entries (default 100, at most 1000); pass next_cursor back as cursor for
+// the next one. With format=ndjson, or an Accept header of
+// application/x-ndjson, every matching entry from cursor on is streamed as
+// NDJSON for export instead.
+func (h *AuditHandler) ListEntries(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminToken(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := parseAuditFilter(query.Get)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cursor := query.Get("cursor")
+
+	if query.Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		h.exportEntries(w, r, filter, cursor)
+		return
+	}
+
+	limit := defaultAuditPageSize
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(limit, maxAuditPageSize)
+	}
+
+	entries, next, err := h.store.Query(filter, cursor, limit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	if entries == nil {
+		entries = []utils.LogEntry{}
+	}
+
+	writeJSON(w, http.StatusOK, AuditListResponse{
+		Entries:    entries,
+		Count:      len(entries),
+		NextCursor: next,
+	})
+}
+
+// exportEntries streams matching entries as NDJSON a page at a time,
+// pushing the write deadline back on every flush
+func (h *AuditHandler) exportEntries(w http.ResponseWriter, r *http.Request, filter utils.AuditFilter, cursor string) {
+	entries, next, err := h.store.Query(filter, cursor, auditExportPage)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	utils.LogRequestAction(r.Context(), "EXPORT_AUDIT", filter.UserID, utils.OutcomeSuccess, r.URL.RawQuery)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	for {
+		rc.SetWriteDeadline(time.Now().Add(backupWriteTimeout))
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return
+			}
+		}
+		rc.Flush()
+
+		if next == "" || r.Context().Err() != nil {
+			return
+		}
+		if entries, next, err = h.store.Query(filter, next, auditExportPage); err != nil {
+			return
+		}
+	}
+}
+
+// parseAuditFilter reads the audit query filters through get
+func parseAuditFilter(get func(string) string) (utils.AuditFilter, error) {
+	filter := utils.AuditFilter{
+		Action:    get("action"),
+		Actor:     get("actor"),
+		Outcome:   get("outcome"),
+		RequestID: get("request_id"),
+	}
+	if value := get("user_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			return filter, errors.New("invalid user_id")
+		}
+		filter.UserID = id
+	}
+	for name, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, errors.New("invalid " + name + ": expected an RFC 3339 time")
+			}
+			*target = t
+		}
+	}
+	return filter, nil
+}
+
+// VerifyChain handles GET /api/audit/verify. It walks the hash-chained
+// audit file and reports the first broken link, if any.
+func (h *AuditHandler) VerifyChain(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminToken(w, r) {
+		return
+	}
+
+	key, err := utils.LoadAuditVerifyKey()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	report, err := utils.VerifyAuditChain(utils.AuditChainFile(), key)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeError(w, http.StatusNotFound, "Audit chain has not been written")
+			return
+		}
+		utils.LogErrorContext(r.Context(), "VerifyAuditChain", err, "failed to read audit chain")
+		writeError(w, http.StatusInternalServerError, "Failed to verify audit chain")
+		return
+	}
+
+	utils.LogRequestAction(r.Context(), "VERIFY_AUDIT_CHAIN", 0, utils.OutcomeSuccess, "")
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// RegisterRoutes registers all audit routes with the router
+func (h *AuditHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/audit", h.ListEntries).Methods("GET")
+	router.HandleFunc("/api/audit/verify", h.VerifyChain).Methods("GET")
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"go-microservice/utils"
+)
+
+// newAuditTestRouter routes the audit endpoints to a handler over store
+func newAuditTestRouter(t *testing.T, store *utils.AuditStore) *mux.Router {
+	t.Helper()
+	t.Setenv("ADMIN_TOKEN", "secret")
+	router := mux.NewRouter()
+	(&AuditHandler{store: store}).RegisterRoutes(router)
+	return router
+}
+
+func serveAdmin(router http.Handler, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// newFilledAuditStore returns a store holding n UPDATE entries for users
+// 1 to n, oldest first
+func newFilledAuditStore(n int) *utils.AuditStore {
+	store := utils.NewAuditStore(n, 0)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= n; i++ {
+		store.Add([]utils.LogEntry{{
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Action:    "UPDATE",
+			Actor:     utils.ActorAdmin,
+			UserID:    i,
+			Outcome:   utils.OutcomeSuccess,
+		}})
+	}
+	return store
+}
+
+func TestListAuditEntriesPages(t *testing.T) {
+	router := newAuditTestRouter(t, newFilledAuditStore(3))
+
+	rec := serveAdmin(router, "/api/audit?limit=2", nil)
+	var page AuditListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if page.Count != 2 || page.Entries[0].UserID != 3 || page.NextCursor == "" {
+		t.Fatalf("first page = %+v", page)
+	}
+
+	rec = serveAdmin(router, "/api/audit?limit=2&cursor="+page.NextCursor, nil)
+	page = AuditListResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Count != 1 || page.Entries[0].UserID != 1 || page.NextCursor != "" {
+		t.Fatalf("second page = %+v", page)
+	}
+
+	for _, target := range []string{
+		"/api/audit?cursor=!!!",
+		"/api/audit?limit=0",
+		"/api/audit?user_id=x",
+		"/api/audit?since=yesterday",
+	} {
+		if rec := serveAdmin(router, target, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", target, rec.Code)
+		}
+	}
+}
+
+func TestExportAuditEntriesNDJSON(t *testing.T) {
+	// More entries than one export page, so the export follows cursors
+	n := auditExportPage + 5
+	router := newAuditTestRouter(t, newFilledAuditStore(n))
+
+	for name, request := range map[string]struct {
+		target string
+		header http.Header
+	}{
+		"format":      {"/api/audit?format=ndjson", nil},
+		"accept":      {"/api/audit", http.Header{"Accept": {"application/x-ndjson"}}},
+		"with filter": {"/api/audit?format=ndjson&user_id=7", nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := serveAdmin(router, request.target, request.header)
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+				t.Fatalf("status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+			}
+
+			var ids []int
+			scanner := bufio.NewScanner(rec.Body)
+			for scanner.Scan() {
+				var entry utils.LogEntry
+				if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+					t.Fatalf("line %d: %v", len(ids)+1, err)
+				}
+				ids = append(ids, entry.UserID)
+			}
+
+			if name == "with filter" {
+				if len(ids) != 1 || ids[0] != 7 {
+					t.Fatalf("exported users %v, want [7]", ids)
+				}
+				return
+			}
+			if len(ids) != n {
+				t.Fatalf("exported %d entries, want %d", len(ids), n)
+			}
+			for i, id := range ids {
+				if id != n-i {
+					t.Fatalf("entry %d is user %d, want %d", i, id, n-i)
+				}
+			}
+		})
+	}
+}
+
+func TestAuditEndpointsRequireAdminToken(t *testing.T) {
+	router := newAuditTestRouter(t, newFilledAuditStore(1))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/audit", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"go-microservice/services"
+	"go-microservice/utils"
+)
+
+// sseHeartbeatInterval keeps idle event streams open through proxies;
+// a variable so tests can shorten it
+var sseHeartbeatInterval = 15 * time.Second
+
+// EventsHandler streams user change events
+type EventsHandler struct {
+	events *services.EventBus
+}
+
+// NewEventsHandler creates a new EventsHandler
+func NewEventsHandler() *EventsHandler {
+	return &EventsHandler{
+		events: services.GetUserService().Events(),
+	}
+}
+
+// StreamUserEvents handles GET /api/events/users as Server-Sent Events.
+// Each event's id is its sequence number; clients resume with the
+// Last-Event-ID header (or ?last_event_id=). 410 Gone means the requested
+// events have expired and the client must resync without resuming.
+func (h *EventsHandler) StreamUserEvents(w http.ResponseWriter, r *http.Request) {
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var after uint64
+	if lastEventID != "" {
+		var err error
+		if after, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid Last-Event-ID")
+			return
+		}
+	}
+
+	backlog, events, cancel, err := h.events.Subscribe(after)
+	if errors.Is(err, services.ErrEventsExpired) {
+		writeError(w, http.StatusGone, err.Error())
+		return
+	}
+	if err != nil {
+		utils.LogErrorContext(r.Context(), "StreamUserEvents", err, "failed to subscribe")
+		writeError(w, http.StatusInternalServerError, "Failed to subscribe to events")
+		return
+	}
+	defer cancel()
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range backlog {
+		if err := writeSSEEvent(w, event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				// Dropped for falling behind; the client reconnects and
+				// resumes from its Last-Event-ID
+				return
+			}
+			if err := writeSSEEvent(w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeSSEEvent writes one event in text/event-stream format
+func writeSSEEvent(w http.ResponseWriter, event services.UserEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Sequence, event.Type, data)
+	return err
+}
+
+// RegisterRoutes registers all event routes with the router
+func (h *EventsHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/events/users", h.StreamUserEvents).Methods("GET")
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-microservice/services"
+)
+
+// streamEvents runs StreamUserEvents against a recorder until ctx ends and
+// returns the response
+func streamEvents(ctx context.Context, h *EventsHandler, lastEventID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/events/users", nil).WithContext(ctx)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	rec := httptest.NewRecorder()
+	h.StreamUserEvents(rec, req)
+	return rec
+}
+
+func TestStreamUserEventsResume(t *testing.T) {
+	events := services.NewEventBus()
+	for id := 1; id <= 3; id++ {
+		events.Publish(services.UserEvent{Type: services.EventUserCreated, UserID: id})
+	}
+	h := &EventsHandler{events: events}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rec := streamEvents(ctx, h, "1")
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "id: 1\n") {
+		t.Errorf("stream repeats the event the client already has:\n%s", body)
+	}
+	for _, want := range []string{"id: 2\nevent: UserCreated\ndata: {", "id: 3\nevent: UserCreated\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stream lacks %q:\n%s", want, body)
+		}
+	}
+	if strings.Index(body, "id: 2\n") > strings.Index(body, "id: 3\n") {
+		t.Errorf("events out of order:\n%s", body)
+	}
+}
+
+func TestStreamUserEventsRejectsUnavailableIDs(t *testing.T) {
+	events := services.NewEventBus()
+	events.Publish(services.UserEvent{Type: services.EventUserCreated, UserID: 1})
+	h := &EventsHandler{events: events}
+
+	for lastEventID, want := range map[string]int{
+		"5":     http.StatusGone, // issued before a restart
+		"abc":   http.StatusBadRequest,
+		"-1":    http.StatusBadRequest,
+		"1.5e3": http.StatusBadRequest,
+	} {
+		if rec := streamEvents(context.Background(), h, lastEventID); rec.Code != want {
+			t.Errorf("Last-Event-ID %q: status %d, want %d", lastEventID, rec.Code, want)
+		}
+	}
+
+	// Events that have left the history are gone as well
+	for i := 0; i < 10001; i++ {
+		events.Publish(services.UserEvent{Type: services.EventUserUpdated, UserID: 1})
+	}
+	if rec := streamEvents(context.Background(), h, "1"); rec.Code != http.StatusGone {
+		t.Errorf("expired Last-Event-ID: status %d, want 410", rec.Code)
+	}
+}
+
+func TestStreamUserEventsHeartbeat(t *testing.T) {
+	interval := sseHeartbeatInterval
+	sseHeartbeatInterval = 10 * time.Millisecond
+	t.Cleanup(func() { sseHeartbeatInterval = interval })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	rec := streamEvents(ctx, &EventsHandler{events: services.NewEventBus()}, "")
+
+	if n := strings.Count(rec.Body.String(), ": heartbeat\n\n"); n < 2 {
+		t.Fatalf("got %d heartbeats in 100ms at a 10ms interval:\n%s", n, rec.Body)
+	}
+}
+
+func TestStreamUserEventsLive(t *testing.T) {
+	events := services.NewEventBus()
+	server := httptest.NewServer(http.HandlerFunc((&EventsHandler{events: events}).StreamUserEvents))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The response headers arrive once the subscription exists
+	events.Publish(services.UserEvent{Type: services.EventUserDeleted, UserID: 9})
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	want := []string{"id: 1", "event: UserDeleted"}
+	for _, expected := range want {
+		select {
+		case line := <-lines:
+			if line != expected {
+				t.Fatalf("line %q, want %q", line, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+
+	// A subscriber dropped by the bus sees the stream end and reconnects
+	events.Close()
+	for range lines {
+	}
+}
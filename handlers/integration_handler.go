@@ -3,17 +3,21 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
 
+	"go-microservice/models"
 	"go-microservice/services"
 	"go-microservice/utils"
 )
 
-// IntegrationHandler handles HTTP requests for MinIO integration operations
+// IntegrationHandler handles HTTP requests for backup storage operations
 type IntegrationHandler struct {
 	integrationService *services.IntegrationService
 	userService        *services.UserService
@@ -29,22 +33,98 @@ func NewIntegrationHandler() *IntegrationHandler {
 
 // HealthResponse represents a health check response
 type HealthResponse struct {
-	Status    string `json:"status"`
-	MinIO     string `json:"minio"`
-	Timestamp string `json:"timestamp"`
+	Status         string `json:"status"`
+	MinIO          string `json:"minio"`
+	MinIOState     string `json:"minio_state"`
+	CircuitBreaker string `json:"circuit_breaker"`
+	Store          string `json:"backup_store,omitempty"`
+	Timestamp      string `json:"timestamp"`
 }
 
 // BackupResponse represents a backup operation response
 type BackupResponse struct {
-	Message string `json:"message"`
-	UserID  int    `json:"user_id,omitempty"`
-	Count   int    `json:"count,omitempty"`
+	Message   string `json:"message"`
+	UserID    int    `json:"user_id,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Count     int    `json:"count,omitempty"`
 }
 
-// BackupListResponse represents a list of backups response
-type BackupListResponse struct {
+// Paging limits for GET /api/backup/users
+const (
+	maxBackupPageSize  = 10000
+	backupFlushEvery   = 500
+	backupWriteTimeout = 30 * time.Second
+)
+
+// RestoreResult reports the outcome of restoring a single user into the store
+type RestoreResult struct {
+	UserID    int          `json:"user_id"`
+	VersionID string       `json:"version_id,omitempty"`
+	Outcome   string       `json:"outcome"`
+	Error     string       `json:"error,omitempty"`
+	User      *models.User `json:"user,omitempty"`
+}
+
+// BackupVersionsResponse represents the version history of a user backup
+type BackupVersionsResponse struct {
+	UserID    int                      `json:"user_id"`
+	Namespace string                   `json:"namespace"`
+	Versioned bool                     `json:"versioned"`
+	Versions  []services.BackupVersion `json:"versions"`
+	Count     int                      `json:"count"`
+}
+
+// RestoreAllRequest is the optional body of POST /api/restore/users
+type RestoreAllRequest struct {
 	Backups []string `json:"backups"`
-	Count   int      `json:"count"`
+}
+
+// RestoreAllResponse represents a bulk restore response
+type RestoreAllResponse struct {
+	Message   string          `json:"message"`
+	Namespace string          `json:"namespace"`
+	Policy    string          `json:"policy"`
+	Results   []RestoreResult `json:"results"`
+	Count     int             `json:"count"`
+	Failed    int             `json:"failed"`
+}
+
+// errBackupUnavailable marks restore failures caused by the backup itself
+var errBackupUnavailable = errors.New("backup not found or unreadable")
+
+// namespaceParam resolves the ?namespace= query parameter, writing a 400
+// response for unknown namespaces. An absent parameter selects the default.
+func (h *IntegrationHandler) namespaceParam(w http.ResponseWriter, r *http.Request) (services.Namespace, bool) {
+	ns, err := h.integrationService.Namespace(r.URL.Query().Get("namespace"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return services.Namespace{}, false
+	}
+	return ns, true
+}
+
+// restoreIntoStore fetches a user backup and inserts it into UserService.
+// An empty versionID restores the latest backup.
+func (h *IntegrationHandler) restoreIntoStore(ctx context.Context, ns services.Namespace, id int, versionID string, policy services.ConflictPolicy) (RestoreResult, error) {
+	result := RestoreResult{UserID: id, VersionID: versionID}
+
+	backup, err := h.integrationService.RestoreUserVersion(ctx, ns, id, versionID)
+	if err != nil {
+		result.Outcome = "failed"
+		result.Error = errBackupUnavailable.Error()
+		return result, fmt.Errorf("%w: %w", errBackupUnavailable, err)
+	}
+
+	user, outcome, err := h.userService.Restore(ctx, *backup, policy)
+	if err != nil {
+		result.Outcome = "failed"
+		result.Error = err.Error()
+		return result, err
+	}
+
+	result.Outcome = string(outcome)
+	result.User = user
+	return result, nil
 }
 
 // HealthCheck handles GET /api/health
@@ -52,69 +132,99 @@ func (h *IntegrationHandler) HealthCheck(w http.ResponseWriter, r *http.Request)
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
+	storeName := h.integrationService.StoreName()
 	minioStatus := "connected"
 	if !h.integrationService.IsConnected() {
 		minioStatus = "disconnected"
+	} else if storeName != services.StoreMinIO {
+		minioStatus = "not in use"
 	} else if err := h.integrationService.HealthCheck(ctx); err != nil {
 		minioStatus = "unhealthy: " + err.Error()
 	}
 
 	response := HealthResponse{
-		Status:    "ok",
-		MinIO:     minioStatus,
-		Timestamp: time.Now().Format(time.RFC3339),
+		Status:         "ok",
+		MinIO:          minioStatus,
+		MinIOState:     string(h.integrationService.ConnectionState()),
+		CircuitBreaker: string(h.integrationService.BreakerState()),
+		Store:          storeName,
+		Timestamp:      time.Now().Format(time.RFC3339),
 	}
 
 	writeJSON(w, http.StatusOK, response)
 }
 
-// BackupUser handles POST /api/backup/users/{id}
+// BackupUser handles POST /api/backup/users/{id}?namespace=
+// Without a namespace the user is routed by the namespace policy.
 func (h *IntegrationHandler) BackupUser(w http.ResponseWriter, r *http.Request) {
 	if !h.integrationService.IsConnected() {
-		writeError(w, http.StatusServiceUnavailable, "MinIO service not available")
+		writeError(w, http.StatusServiceUnavailable, "Backup storage not available")
 		return
 	}
 
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		go utils.LogError("BackupUser", err, "invalid user ID format")
+		utils.LogErrorContext(r.Context(), "BackupUser", err, "invalid user ID format")
 		writeError(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	user, err := h.userService.GetByID(id)
+	user, err := h.userService.GetByID(r.Context(), id)
 	if err != nil {
 		writeError(w, http.StatusNotFound, "User not found")
 		return
 	}
 
+	ns := h.integrationService.RouteNamespace(user)
+	if r.URL.Query().Get("namespace") != "" {
+		var ok bool
+		if ns, ok = h.namespaceParam(w, r); !ok {
+			return
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	if err := h.integrationService.BackupUser(ctx, user); err != nil {
-		go utils.LogError("BackupUser", err, "failed to backup user")
+	if err := h.integrationService.BackupUser(ctx, ns, user); err != nil {
+		utils.LogErrorContext(r.Context(), "BackupUser", err, "failed to backup user")
+		if errors.Is(err, services.ErrCircuitOpen) {
+			writeError(w, http.StatusServiceUnavailable, "Backup storage temporarily unavailable")
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "Failed to backup user")
 		return
 	}
 
 	// Async logging
-	go utils.LogUserAction("BACKUP", user.ID)
+	utils.LogRequestAction(r.Context(), "BACKUP", user.ID, utils.OutcomeSuccess, "")
 
 	writeJSON(w, http.StatusOK, BackupResponse{
-		Message: "User backed up successfully",
-		UserID:  user.ID,
+		Message:   "User backed up successfully",
+		UserID:    user.ID,
+		Namespace: ns.Name,
 	})
 }
 
-// BackupAllUsers handles POST /api/backup/users
+// BackupAllUsers handles POST /api/backup/users?namespace=
+// Without a namespace each user is routed by the namespace policy.
 func (h *IntegrationHandler) BackupAllUsers(w http.ResponseWriter, r *http.Request) {
 	if !h.integrationService.IsConnected() {
-		writeError(w, http.StatusServiceUnavailable, "MinIO service not available")
+		writeError(w, http.StatusServiceUnavailable, "Backup storage not available")
 		return
 	}
 
-	users := h.userService.GetAll()
+	var target *services.Namespace
+	if r.URL.Query().Get("namespace") != "" {
+		ns, ok := h.namespaceParam(w, r)
+		if !ok {
+			return
+		}
+		target = &ns
+	}
+
+	users := h.userService.GetAll(r.Context())
 	if len(users) == 0 {
 		writeJSON(w, http.StatusOK, BackupResponse{
 			Message: "No users to backup",
@@ -126,14 +236,14 @@ func (h *IntegrationHandler) BackupAllUsers(w http.ResponseWriter, r *http.Reque
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
 	defer cancel()
 
-	if err := h.integrationService.BackupAllUsers(ctx, users); err != nil {
-		go utils.LogError("BackupAllUsers", err, "failed to backup users")
+	if err := h.integrationService.BackupAllUsers(ctx, target, users); err != nil {
+		utils.LogErrorContext(r.Context(), "BackupAllUsers", err, "failed to backup users")
 		writeError(w, http.StatusInternalServerError, "Failed to backup users: "+err.Error())
 		return
 	}
 
 	// Async logging
-	go utils.LogUserActionWithDetails("BACKUP_ALL", 0, "backed up all users")
+	utils.LogRequestAction(r.Context(), "BACKUP_ALL", 0, utils.OutcomeSuccess, "backed up all users")
 
 	writeJSON(w, http.StatusOK, BackupResponse{
 		Message: "All users backed up successfully",
@@ -141,101 +251,455 @@ func (h *IntegrationHandler) BackupAllUsers(w http.ResponseWriter, r *http.Reque
 	})
 }
 
-// RestoreUser handles POST /api/restore/users/{id}
+// RestoreUser handles POST /api/restore/users/{id}?conflict=&version=&namespace=
 func (h *IntegrationHandler) RestoreUser(w http.ResponseWriter, r *http.Request) {
 	if !h.integrationService.IsConnected() {
-		writeError(w, http.StatusServiceUnavailable, "MinIO service not available")
+		writeError(w, http.StatusServiceUnavailable, "Backup storage not available")
 		return
 	}
 
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		go utils.LogError("RestoreUser", err, "invalid user ID format")
+		utils.LogErrorContext(r.Context(), "RestoreUser", err, "invalid user ID format")
 		writeError(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
+	policy, err := services.ParseConflictPolicy(r.URL.Query().Get("conflict"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ns, ok := h.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	user, err := h.integrationService.RestoreUser(ctx, id)
+	result, err := h.restoreIntoStore(ctx, ns, id, r.URL.Query().Get("version"), policy)
 	if err != nil {
-		go utils.LogError("RestoreUser", err, "failed to restore user")
-		writeError(w, http.StatusNotFound, "Backup not found or failed to restore")
+		utils.LogErrorContext(r.Context(), "RestoreUser", err, "failed to restore user")
+		switch {
+		case errors.Is(err, services.ErrCircuitOpen):
+			writeError(w, http.StatusServiceUnavailable, "Backup storage temporarily unavailable")
+		case errors.Is(err, services.ErrUserExists):
+			writeError(w, http.StatusConflict, "User already exists")
+		case errors.Is(err, errBackupUnavailable):
+			writeError(w, http.StatusNotFound, "Backup not found or failed to restore")
+		default:
+			writeError(w, http.StatusUnprocessableEntity, "Invalid backup: "+err.Error())
+		}
 		return
 	}
 
-	// Async logging
-	go utils.LogUserAction("RESTORE", user.ID)
+	// UserService audits the restore itself
+	writeJSON(w, http.StatusOK, result)
+}
+
+// RestoreAllUsers handles POST /api/restore/users?conflict=&namespace=
+// The optional body lists backup object names; all backups are restored otherwise.
+func (h *IntegrationHandler) RestoreAllUsers(w http.ResponseWriter, r *http.Request) {
+	if !h.integrationService.IsConnected() {
+		writeError(w, http.StatusServiceUnavailable, "Backup storage not available")
+		return
+	}
+
+	policy, err := services.ParseConflictPolicy(r.URL.Query().Get("conflict"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ns, ok := h.namespaceParam(w, r)
+	if !ok {
+		return
+	}
 
-	writeJSON(w, http.StatusOK, user)
+	// The body is optional; chunked requests carry no Content-Length, so
+	// an empty body is only detected by reading it
+	var req RestoreAllRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		utils.LogErrorContext(r.Context(), "RestoreAllUsers", err, "failed to decode request body")
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	backups := req.Backups
+	if len(backups) == 0 {
+		_, err = h.integrationService.ListBackups(ctx, ns, services.BackupListOptions{}, func(entry services.BackupEntry) error {
+			backups = append(backups, entry.Key)
+			return nil
+		})
+		if err != nil {
+			utils.LogErrorContext(r.Context(), "RestoreAllUsers", err, "failed to list backups")
+			writeError(w, http.StatusInternalServerError, "Failed to list backups")
+			return
+		}
+	}
+
+	response := RestoreAllResponse{
+		Namespace: ns.Name,
+		Policy:    string(policy),
+		Results:   make([]RestoreResult, 0, len(backups)),
+	}
+	for _, objectName := range backups {
+		id, err := ns.UserID(objectName)
+		if err != nil {
+			response.Results = append(response.Results, RestoreResult{Outcome: "failed", Error: err.Error()})
+			response.Failed++
+			continue
+		}
+
+		result, err := h.restoreIntoStore(ctx, ns, id, "", policy)
+		if err != nil {
+			utils.LogErrorContext(r.Context(), "RestoreAllUsers", err, fmt.Sprintf("failed to restore user %d", id))
+			response.Failed++
+		}
+		response.Results = append(response.Results, result)
+	}
+	response.Count = len(response.Results) - response.Failed
+	response.Message = "Restore completed"
+
+	// Each restored user has its own audit entry; this one marks the batch
+	utils.LogRequestAction(r.Context(), "RESTORE_ALL", 0, utils.OutcomeSuccess,
+		fmt.Sprintf("restored %d users from backup set, %d failed", response.Count, response.Failed))
+
+	writeJSON(w, http.StatusOK, response)
 }
 
-// DeleteBackup handles DELETE /api/backup/users/{id}
+// DeleteBackup handles DELETE /api/backup/users/{id}?namespace=
 func (h *IntegrationHandler) DeleteBackup(w http.ResponseWriter, r *http.Request) {
 	if !h.integrationService.IsConnected() {
-		writeError(w, http.StatusServiceUnavailable, "MinIO service not available")
+		writeError(w, http.StatusServiceUnavailable, "Backup storage not available")
 		return
 	}
 
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		go utils.LogError("DeleteBackup", err, "invalid user ID format")
+		utils.LogErrorContext(r.Context(), "DeleteBackup", err, "invalid user ID format")
 		writeError(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
+	ns, ok := h.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	if err := h.integrationService.DeleteUserBackup(ctx, id); err != nil {
-		go utils.LogError("DeleteBackup", err, "failed to delete backup")
+	if err := h.integrationService.DeleteUserBackup(ctx, ns, id); err != nil {
+		utils.LogErrorContext(r.Context(), "DeleteBackup", err, "failed to delete backup")
 		writeError(w, http.StatusInternalServerError, "Failed to delete backup")
 		return
 	}
 
 	// Async logging
-	go utils.LogUserAction("DELETE_BACKUP", id)
+	utils.LogRequestAction(r.Context(), "DELETE_BACKUP", id, utils.OutcomeSuccess, "")
 
 	writeJSON(w, http.StatusOK, SuccessResponse{Message: "Backup deleted successfully"})
 }
 
-// ListBackups handles GET /api/backup/users
+// ListBackups handles GET /api/backup/users?namespace=&prefix=&limit=&continuation_token=
+// Entries are streamed as they are listed:
+//
+//	{"namespace": "...", "backups": [...], "count": N, "next_token": "...", "error": "..."}
+//
+// Without a limit every backup is returned; with one, next_token is set
+// while more backups remain. error is only set when listing fails after
+// streaming has started.
 func (h *IntegrationHandler) ListBackups(w http.ResponseWriter, r *http.Request) {
 	if !h.integrationService.IsConnected() {
-		writeError(w, http.StatusServiceUnavailable, "MinIO service not available")
+		writeError(w, http.StatusServiceUnavailable, "Backup storage not available")
+		return
+	}
+
+	ns, ok := h.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	opts := services.BackupListOptions{
+		Prefix: query.Get("prefix"),
+		Token:  query.Get("continuation_token"),
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		opts.Limit = min(limit, maxBackupPageSize)
+	}
+
+	// Listing everything can outlast the server's write timeout; the
+	// deadline is pushed back on every flush instead
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
+	defer cancel()
+
+	stream := &backupListStream{w: w, rc: http.NewResponseController(w), namespace: ns.Name}
+	nextToken, err := h.integrationService.ListBackups(ctx, ns, opts, stream.write)
+	if errors.Is(err, services.ErrInvalidContinuationToken) {
+		writeError(w, http.StatusBadRequest, "Invalid continuation token")
+		return
+	}
+	if err != nil {
+		utils.LogErrorContext(r.Context(), "ListBackups", err, "failed to list backups")
+		if !stream.started {
+			switch {
+			case errors.Is(err, services.ErrCircuitOpen):
+				writeError(w, http.StatusServiceUnavailable, "Backup storage temporarily unavailable")
+			default:
+				writeError(w, http.StatusInternalServerError, "Failed to list backups")
+			}
+			return
+		}
+		stream.finish("", "Failed to list backups")
+		return
+	}
+
+	stream.finish(nextToken, "")
+}
+
+// backupListStream writes a BackupListResponse entry by entry. The status
+// line is sent with the first entry, so errors before it still get a
+// regular error response.
+type backupListStream struct {
+	w         http.ResponseWriter
+	rc        *http.ResponseController
+	namespace string
+	started   bool
+	count     int
+}
+
+// start writes the headers and the opening of the document
+func (s *backupListStream) start() {
+	s.started = true
+	s.w.Header().Set("Content-Type", "application/json")
+	s.w.WriteHeader(http.StatusOK)
+
+	namespace, _ := json.Marshal(s.namespace)
+	fmt.Fprintf(s.w, `{"namespace":%s,"backups":[`, namespace)
+}
+
+// write appends one entry, flushing every backupFlushEvery entries
+func (s *backupListStream) write(entry services.BackupEntry) error {
+	if !s.started {
+		s.start()
+		s.rc.SetWriteDeadline(time.Now().Add(backupWriteTimeout))
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if s.count > 0 {
+		data = append([]byte{','}, data...)
+	}
+	if _, err := s.w.Write(data); err != nil {
+		return err
+	}
+
+	s.count++
+	if s.count%backupFlushEvery == 0 {
+		s.rc.SetWriteDeadline(time.Now().Add(backupWriteTimeout))
+		s.rc.Flush()
+	}
+	return nil
+}
+
+// finish closes the document with the count, next token and any error
+func (s *backupListStream) finish(nextToken, errMessage string) {
+	if !s.started {
+		s.start()
+	}
+
+	trailer, _ := json.Marshal(struct {
+		Count     int    `json:"count"`
+		NextToken string `json:"next_token,omitempty"`
+		Error     string `json:"error,omitempty"`
+	}{s.count, nextToken, errMessage})
+
+	// The trailer's opening brace is replaced by the end of the array
+	s.w.Write([]byte("],"))
+	s.w.Write(trailer[1:])
+	s.w.Write([]byte("\n"))
+}
+
+// ListBackupVersions handles GET /api/backup/users/{id}/versions?namespace=
+func (h *IntegrationHandler) ListBackupVersions(w http.ResponseWriter, r *http.Request) {
+	if !h.integrationService.IsConnected() {
+		writeError(w, http.StatusServiceUnavailable, "Backup storage not available")
+		return
+	}
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		utils.LogErrorContext(r.Context(), "ListBackupVersions", err, "invalid user ID format")
+		writeError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	ns, ok := h.namespaceParam(w, r)
+	if !ok {
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	backups, err := h.integrationService.ListBackups(ctx)
+	versions, err := h.integrationService.ListUserVersions(ctx, ns, id)
 	if err != nil {
-		go utils.LogError("ListBackups", err, "failed to list backups")
-		writeError(w, http.StatusInternalServerError, "Failed to list backups")
+		utils.LogErrorContext(r.Context(), "ListBackupVersions", err, "failed to list backup versions")
+		writeError(w, http.StatusInternalServerError, "Failed to list backup versions")
+		return
+	}
+	if len(versions) == 0 {
+		writeError(w, http.StatusNotFound, "Backup not found")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, BackupListResponse{
-		Backups: backups,
-		Count:   len(backups),
+	writeJSON(w, http.StatusOK, BackupVersionsResponse{
+		UserID:    id,
+		Namespace: ns.Name,
+		Versioned: h.integrationService.IsVersioned(ns),
+		Versions:  versions,
+		Count:     len(versions),
 	})
 }
 
+// VerifyBackups handles GET /api/backup/verify?namespace=
+func (h *IntegrationHandler) VerifyBackups(w http.ResponseWriter, r *http.Request) {
+	if !h.integrationService.IsConnected() {
+		writeError(w, http.StatusServiceUnavailable, "Backup storage not available")
+		return
+	}
+
+	ns, ok := h.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	report, err := h.integrationService.VerifyBackups(ctx, ns)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), "VerifyBackups", err, "failed to verify backups")
+		writeError(w, http.StatusInternalServerError, "Failed to verify backups")
+		return
+	}
+
+	// Async logging
+	utils.LogRequestAction(r.Context(), "VERIFY_BACKUPS", 0, utils.OutcomeSuccess,
+		fmt.Sprintf("namespace %s: scanned %d, problems %d", ns.Name, report.Scanned, len(report.Problems)))
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// ReconcileBackups handles GET /api/backup/reconcile?namespace= (report
+// only) and POST /api/backup/reconcile?namespace= (report and repair).
+// Repairing deletes orphaned backups, so it requires the admin token.
+func (h *IntegrationHandler) ReconcileBackups(w http.ResponseWriter, r *http.Request) {
+	repair := r.Method == http.MethodPost
+	if repair && !requireAdminToken(w, r) {
+		return
+	}
+
+	if !h.integrationService.IsConnected() {
+		writeError(w, http.StatusServiceUnavailable, "Backup storage not available")
+		return
+	}
+
+	ns, ok := h.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+
+	report, err := h.integrationService.ReconcileBackups(ctx, ns, h.userService.GetAll(ctx), repair)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), "ReconcileBackups", err, "failed to reconcile backups")
+		if errors.Is(err, services.ErrCircuitOpen) {
+			writeError(w, http.StatusServiceUnavailable, "Backup storage temporarily unavailable")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to reconcile backups")
+		return
+	}
+
+	if repair {
+		// Async logging
+		utils.LogRequestAction(r.Context(), "RECONCILE_BACKUPS", 0, utils.OutcomeSuccess,
+			fmt.Sprintf("namespace %s: uploaded %d, deleted %d, failed %d",
+				ns.Name, report.Uploaded, report.Deleted, len(report.Failed)))
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// ConnectRequest holds the connection settings that may be overridden
+// through POST /api/integration/connect. Secrets are never accepted over
+// HTTP; they come from the configured credential providers.
+type ConnectRequest struct {
+	Endpoint        string
+	BucketName      string
+	UseSSL          *bool
+	CredentialChain []string
+
+	// Present only so requests carrying secrets can be rejected
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
 // ConnectMinIO handles POST /api/integration/connect
 func (h *IntegrationHandler) ConnectMinIO(w http.ResponseWriter, r *http.Request) {
-	var config services.MinIOConfig
-	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		// Use default config if none provided
-		config = services.GetDefaultConfig()
+	if !requireAdminToken(w, r) {
+		return
+	}
+
+	// Start from the environment config; the body only overrides it
+	config := services.GetDefaultConfig()
+
+	var req ConnectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.AccessKeyID != "" || req.SecretAccessKey != "" {
+		writeError(w, http.StatusBadRequest, "Credentials must be supplied through a credential provider, not the request body")
+		return
+	}
+
+	if req.Endpoint != "" {
+		config.Endpoint = req.Endpoint
+	}
+	if req.BucketName != "" {
+		config.BucketName = req.BucketName
+	}
+	if req.UseSSL != nil {
+		config.UseSSL = *req.UseSSL
+	}
+	if len(req.CredentialChain) > 0 {
+		config.CredentialChain = req.CredentialChain
 	}
 
 	if err := h.integrationService.Connect(config); err != nil {
-		go utils.LogError("ConnectMinIO", err, "failed to connect to MinIO")
-		writeError(w, http.StatusInternalServerError, "Failed to connect to MinIO: "+err.Error())
+		utils.LogErrorContext(r.Context(), "ConnectMinIO", err, fmt.Sprintf("failed to connect to MinIO with %s", config))
+		writeError(w, http.StatusInternalServerError, "Failed to connect to MinIO")
 		return
 	}
 
@@ -248,7 +712,11 @@ func (h *IntegrationHandler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/api/integration/connect", h.ConnectMinIO).Methods("POST")
 	router.HandleFunc("/api/backup/users", h.BackupAllUsers).Methods("POST")
 	router.HandleFunc("/api/backup/users", h.ListBackups).Methods("GET")
+	router.HandleFunc("/api/backup/verify", h.VerifyBackups).Methods("GET")
+	router.HandleFunc("/api/backup/reconcile", h.ReconcileBackups).Methods("GET", "POST")
 	router.HandleFunc("/api/backup/users/{id:[0-9]+}", h.BackupUser).Methods("POST")
 	router.HandleFunc("/api/backup/users/{id:[0-9]+}", h.DeleteBackup).Methods("DELETE")
+	router.HandleFunc("/api/backup/users/{id:[0-9]+}/versions", h.ListBackupVersions).Methods("GET")
+	router.HandleFunc("/api/restore/users", h.RestoreAllUsers).Methods("POST")
 	router.HandleFunc("/api/restore/users/{id:[0-9]+}", h.RestoreUser).Methods("POST")
 }
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"go-microservice/models"
+	"go-microservice/services"
+)
+
+// newBackupTestRouter routes the integration endpoints to a handler whose
+// backups go to a fresh MemoryStore
+func newBackupTestRouter(t *testing.T) *mux.Router {
+	t.Helper()
+	services.GetIntegrationService().UseStore(services.NewMemoryStore())
+	services.GetUserService().Clear()
+	t.Cleanup(services.GetUserService().Clear)
+
+	router := mux.NewRouter()
+	NewIntegrationHandler().RegisterRoutes(router)
+	return router
+}
+
+func serve(router http.Handler, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestBackupAndRestoreUserMemoryStore(t *testing.T) {
+	router := newBackupTestRouter(t)
+	userService := services.GetUserService()
+
+	user, err := userService.Create(context.Background(), models.User{Name: "Ada Lovelace", Email: "ada@example.com"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	rec := serve(router, http.MethodPost, fmt.Sprintf("/api/backup/users/%d", user.ID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("backup status = %d: %s", rec.Code, rec.Body)
+	}
+
+	list := listBackups(t, router, "/api/backup/users")
+	if list.Count != 1 || list.Backups[0].UserID != user.ID {
+		t.Fatalf("list = %+v", list)
+	}
+
+	if err := userService.Delete(context.Background(), user.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	rec = serve(router, http.MethodPost, fmt.Sprintf("/api/restore/users/%d", user.ID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("restore status = %d: %s", rec.Code, rec.Body)
+	}
+	var result RestoreResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decode restore: %v", err)
+	}
+	if result.User == nil || result.User.Email != user.Email {
+		t.Fatalf("restore result = %+v", result)
+	}
+	if !userService.Exists(user.ID) {
+		t.Fatal("restored user not in UserService")
+	}
+
+	// Restoring over the live user conflicts under the default policy
+	rec = serve(router, http.MethodPost, fmt.Sprintf("/api/restore/users/%d", user.ID))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("second restore status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+// backupList is the streamed body of GET /api/backup/users
+type backupList struct {
+	Namespace string                 `json:"namespace"`
+	Backups   []services.BackupEntry `json:"backups"`
+	Count     int                    `json:"count"`
+	NextToken string                 `json:"next_token"`
+	Error     string                 `json:"error"`
+}
+
+func listBackups(t *testing.T, router http.Handler, target string) backupList {
+	t.Helper()
+	rec := serve(router, http.MethodGet, target)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d: %s", target, rec.Code, rec.Body)
+	}
+	var list backupList
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	return list
+}
+
+func TestListBackupsPages(t *testing.T) {
+	router := newBackupTestRouter(t)
+	userService := services.GetUserService()
+	for i := 0; i < 5; i++ {
+		user, err := userService.Create(context.Background(), models.User{Name: "User", Email: fmt.Sprintf("user%d@example.com", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec := serve(router, http.MethodPost, fmt.Sprintf("/api/backup/users/%d", user.ID)); rec.Code != http.StatusOK {
+			t.Fatalf("backup status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	var ids []int
+	target := "/api/backup/users?limit=2"
+	for pages := 0; pages < 10; pages++ {
+		list := listBackups(t, router, target)
+		if list.Namespace != services.DefaultNamespace || list.Count != len(list.Backups) || list.Error != "" {
+			t.Fatalf("page = %+v", list)
+		}
+		for _, entry := range list.Backups {
+			ids = append(ids, entry.UserID)
+		}
+		if list.NextToken == "" {
+			break
+		}
+		target = "/api/backup/users?limit=2&continuation_token=" + list.NextToken
+	}
+	if fmt.Sprint(ids) != "[1 2 3 4 5]" {
+		t.Fatalf("paged user IDs = %v", ids)
+	}
+
+	for _, target := range []string{
+		"/api/backup/users?limit=0",
+		"/api/backup/users?limit=x",
+		"/api/backup/users?continuation_token=bm9wZQ",
+	} {
+		if rec := serve(router, http.MethodGet, target); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", target, rec.Code)
+		}
+	}
+}
+
+func TestRestoreAllUsersBody(t *testing.T) {
+	router := newBackupTestRouter(t)
+	userService := services.GetUserService()
+
+	for _, name := range []string{"ada", "grace"} {
+		user, err := userService.Create(context.Background(), models.User{Name: name, Email: name + "@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec := serve(router, http.MethodPost, fmt.Sprintf("/api/backup/users/%d", user.ID)); rec.Code != http.StatusOK {
+			t.Fatalf("backup status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		body      io.Reader
+		chunked   bool
+		wantCode  int
+		wantCount int
+	}{
+		// An empty chunked body has no Content-Length but still means "all"
+		{"empty chunked body", strings.NewReader(""), true, http.StatusOK, 2},
+		{"no body", nil, false, http.StatusOK, 2},
+		{"selected backups", strings.NewReader(`{"backups":["users/1.json"]}`), false, http.StatusOK, 1},
+		{"malformed body", strings.NewReader(`{"backups":`), false, http.StatusBadRequest, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/restore/users?conflict=overwrite", tc.body)
+			if tc.chunked {
+				req.ContentLength = -1
+				req.TransferEncoding = []string{"chunked"}
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.wantCode, rec.Body)
+			}
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+			var response RestoreAllResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Count != tc.wantCount || response.Failed != 0 {
+				t.Fatalf("response = %+v, want %d restored", response, tc.wantCount)
+			}
+		})
+	}
+}
+
+func TestBackupHandlerErrors(t *testing.T) {
+	router := newBackupTestRouter(t)
+
+	if rec := serve(router, http.MethodPost, "/api/backup/users/999"); rec.Code != http.StatusNotFound {
+		t.Errorf("backup of unknown user = %d, want 404", rec.Code)
+	}
+	if rec := serve(router, http.MethodPost, "/api/restore/users/999"); rec.Code != http.StatusNotFound {
+		t.Errorf("restore without backup = %d, want 404", rec.Code)
+	}
+	if rec := serve(router, http.MethodGet, "/api/backup/users?namespace=nope"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown namespace = %d, want 400", rec.Code)
+	}
+	if rec := serve(router, http.MethodPost, "/api/restore/users/1?conflict=bogus"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid conflict policy = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-microservice/models"
+	"go-microservice/services"
+	"go-microservice/utils"
+)
+
+// useInMemoryTracing installs a tracer provider recording to an in-memory
+// exporter for the duration of the test. Call flush before reading spans.
+func useInMemoryTracing(t *testing.T) (exporter *tracetest.InMemoryExporter, flush func()) {
+	t.Helper()
+	if _, err := utils.ConfigureTracing(context.Background(), utils.TracingConfig{Exporter: utils.TraceExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+	exporter = tracetest.NewInMemoryExporter()
+	provider := utils.NewTracerProvider(utils.TracingConfig{ServiceName: "test", SampleRatio: 1}, exporter)
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return exporter, func() { provider.ForceFlush(context.Background()) }
+}
+
+// newFakeS3 answers every S3 call the backup path makes with success
+func newFakeS3(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["location"]; ok {
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><LocationConstraint>us-east-1</LocationConstraint>`)
+			return
+		}
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTracingBackupRequest(t *testing.T) {
+	exporter, flush := useInMemoryTracing(t)
+
+	s3 := newFakeS3(t)
+	store, err := services.NewMinIOStore(services.MinIOConfig{
+		Endpoint:        strings.TrimPrefix(s3.URL, "http://"),
+		AccessKeyID:     "test",
+		SecretAccessKey: "testsecret",
+		BucketName:      "backups",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.GetIntegrationService().UseStore(store)
+	t.Cleanup(func() { services.GetIntegrationService().UseStore(services.NewMemoryStore()) })
+	services.GetUserService().Clear()
+	t.Cleanup(services.GetUserService().Clear)
+
+	user, err := services.GetUserService().Create(context.Background(), models.User{Name: "Ada Lovelace", Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Use(utils.RequestIDMiddleware)
+	router.Use(utils.TracingMiddleware)
+	NewIntegrationHandler().RegisterRoutes(router)
+
+	flush()
+	exporter.Reset()
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/backup/users/%d", user.ID), nil)
+	req.Header.Set("traceparent", traceparent)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	flush()
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	route, ok := spans["POST /api/backup/users/{id:[0-9]+}"]
+	if !ok {
+		t.Fatalf("no route span in %v", spanNames(exporter))
+	}
+	if route.SpanKind != trace.SpanKindServer {
+		t.Errorf("route span kind = %v", route.SpanKind)
+	}
+	// The incoming traceparent is continued, not replaced
+	if got := route.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID = %s", got)
+	}
+	if got := route.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("route span parent = %s", got)
+	}
+
+	getByID, ok := spans["UserService.GetByID"]
+	if !ok || getByID.Parent.SpanID() != route.SpanContext.SpanID() {
+		t.Errorf("UserService.GetByID is not a child of the route span: %v", spanNames(exporter))
+	}
+	backup, ok := spans["IntegrationService.BackupUser"]
+	if !ok || backup.Parent.SpanID() != route.SpanContext.SpanID() {
+		t.Fatalf("IntegrationService.BackupUser is not a child of the route span: %v", spanNames(exporter))
+	}
+	put, ok := spans["minio put"]
+	if !ok {
+		t.Fatalf("no storage span in %v", spanNames(exporter))
+	}
+	if put.Parent.SpanID() != backup.SpanContext.SpanID() {
+		t.Errorf("storage span parent = %s, want %s", put.Parent.SpanID(), backup.SpanContext.SpanID())
+	}
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID() != route.SpanContext.TraceID() {
+			t.Errorf("span %s in trace %s", span.Name, span.SpanContext.TraceID())
+		}
+	}
+}
+
+func spanNames(exporter *tracetest.InMemoryExporter) []string {
+	var names []string
+	for _, span := range exporter.GetSpans() {
+		names = append(names, span.Name)
+	}
+	return names
+}
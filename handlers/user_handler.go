@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 
@@ -28,6 +33,8 @@ func NewUserHandler() *UserHandler {
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message,omitempty"`
+	// RequestID identifies the request in logs and audit entries
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // SuccessResponse represents a success response
@@ -42,17 +49,41 @@ func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
-// writeError writes an error response
+// writeError writes an error response, quoting the request ID that
+// RequestIDMiddleware set on the response
 func writeError(w http.ResponseWriter, status int, message string) {
-	writeJSON(w, status, ErrorResponse{Error: http.StatusText(status), Message: message})
+	writeJSON(w, status, ErrorResponse{
+		Error:     http.StatusText(status),
+		Message:   message,
+		RequestID: w.Header().Get(utils.RequestIDHeader),
+	})
+}
+
+// requireAdminToken checks the request's bearer token against ADMIN_TOKEN and
+// writes an error response if it does not match. Admin endpoints are
+// disabled entirely while ADMIN_TOKEN is unset.
+func requireAdminToken(w http.ResponseWriter, r *http.Request) bool {
+	expected := os.Getenv("ADMIN_TOKEN")
+	if expected == "" {
+		writeError(w, http.StatusForbidden, "Admin endpoints are disabled")
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		writeError(w, http.StatusUnauthorized, "Invalid or missing admin token")
+		return false
+	}
+	utils.SetAuditActor(r.Context(), utils.ActorAdmin)
+	return true
 }
 
 // GetAllUsers handles GET /api/users
 func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	users := h.userService.GetAll()
+	users := h.userService.GetAll(r.Context())
 
 	// Async logging
-	go utils.LogUserAction("LIST_USERS", 0)
+	utils.LogRequestAction(r.Context(), "LIST_USERS", 0, utils.OutcomeSuccess, "")
 
 	writeJSON(w, http.StatusOK, users)
 }
@@ -63,21 +94,21 @@ func (h *UserHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
 		// Async error logging
-		go utils.LogError("GetUserByID", err, "invalid user ID format")
+		utils.LogErrorContext(r.Context(), "GetUserByID", err, "invalid user ID format")
 		writeError(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	user, err := h.userService.GetByID(id)
+	user, err := h.userService.GetByID(r.Context(), id)
 	if err != nil {
 		// Async logging
-		go utils.LogUserActionWithDetails("GET_USER_NOT_FOUND", id, err.Error())
+		utils.LogRequestAction(r.Context(), "GET_USER", id, utils.OutcomeFailure, err.Error())
 		writeError(w, http.StatusNotFound, "User not found")
 		return
 	}
 
 	// Async logging
-	go utils.LogUserAction("GET_USER", user.ID)
+	utils.LogRequestAction(r.Context(), "GET_USER", user.ID, utils.OutcomeSuccess, "")
 
 	writeJSON(w, http.StatusOK, user)
 }
@@ -87,26 +118,27 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		// Async error logging
-		go utils.LogError("CreateUser", err, "failed to decode request body")
+		utils.LogErrorContext(r.Context(), "CreateUser", err, "failed to decode request body")
 		writeError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	// Create user (validation happens in service)
-	savedUser, err := h.userService.Create(user)
+	savedUser, err := h.userService.Create(changeContext(r), user)
+	if errors.Is(err, services.ErrOutboxUnavailable) {
+		utils.LogErrorContext(r.Context(), "CreateUser", err, "failed to record change")
+		writeError(w, http.StatusServiceUnavailable, "Failed to create user")
+		return
+	}
 	if err != nil {
 		// Async error logging
-		go utils.LogError("CreateUser", err, "validation failed")
+		utils.LogErrorContext(r.Context(), "CreateUser", err, "validation failed")
+		utils.LogRequestAction(r.Context(), "CREATE", 0, utils.OutcomeFailure, err.Error())
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Async logging with proper ID (after creation)
-	go utils.LogUserAction("CREATE", savedUser.ID)
-
-	// Async notification
-	go utils.SendUserNotification(savedUser.ID, "WELCOME", "User account created successfully")
-
+	// The audit entry and welcome notification are delivered from the outbox
 	writeJSON(w, http.StatusCreated, savedUser)
 }
 
@@ -115,36 +147,37 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		go utils.LogError("UpdateUser", err, "invalid user ID format")
+		utils.LogErrorContext(r.Context(), "UpdateUser", err, "invalid user ID format")
 		writeError(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		go utils.LogError("UpdateUser", err, "failed to decode request body")
+		utils.LogErrorContext(r.Context(), "UpdateUser", err, "failed to decode request body")
 		writeError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	updatedUser, err := h.userService.Update(id, user)
+	updatedUser, err := h.userService.Update(changeContext(r), id, user)
 	if err != nil {
+		if errors.Is(err, services.ErrOutboxUnavailable) {
+			utils.LogErrorContext(r.Context(), "UpdateUser", err, "failed to record change")
+			writeError(w, http.StatusServiceUnavailable, "Failed to update user")
+			return
+		}
 		if err.Error() == "user not found" {
-			go utils.LogUserActionWithDetails("UPDATE_USER_NOT_FOUND", id, err.Error())
+			utils.LogRequestAction(r.Context(), "UPDATE", id, utils.OutcomeFailure, err.Error())
 			writeError(w, http.StatusNotFound, "User not found")
 			return
 		}
-		go utils.LogError("UpdateUser", err, "validation failed")
+		utils.LogErrorContext(r.Context(), "UpdateUser", err, "validation failed")
+		utils.LogRequestAction(r.Context(), "UPDATE", id, utils.OutcomeFailure, err.Error())
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Async logging
-	go utils.LogUserAction("UPDATE", updatedUser.ID)
-
-	// Async notification
-	go utils.SendUserNotification(updatedUser.ID, "PROFILE_UPDATED", "Your profile has been updated")
-
+	// The audit entry and notification are delivered from the outbox
 	writeJSON(w, http.StatusOK, updatedUser)
 }
 
@@ -153,26 +186,33 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		go utils.LogError("DeleteUser", err, "invalid user ID format")
+		utils.LogErrorContext(r.Context(), "DeleteUser", err, "invalid user ID format")
 		writeError(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
 
-	if err := h.userService.Delete(id); err != nil {
-		go utils.LogUserActionWithDetails("DELETE_USER_NOT_FOUND", id, err.Error())
+	err = h.userService.Delete(changeContext(r), id)
+	if errors.Is(err, services.ErrOutboxUnavailable) {
+		utils.LogErrorContext(r.Context(), "DeleteUser", err, "failed to record change")
+		writeError(w, http.StatusServiceUnavailable, "Failed to delete user")
+		return
+	}
+	if err != nil {
+		utils.LogRequestAction(r.Context(), "DELETE", id, utils.OutcomeFailure, err.Error())
 		writeError(w, http.StatusNotFound, "User not found")
 		return
 	}
 
-	// Async logging
-	go utils.LogUserAction("DELETE", id)
-
-	// Async notification (in production, would notify related services/users)
-	go utils.SendUserNotification(id, "ACCOUNT_DELETED", "User account has been deleted")
-
+	// The audit entry and notification are delivered from the outbox
 	writeJSON(w, http.StatusOK, SuccessResponse{Message: "User deleted successfully"})
 }
 
+// changeContext returns the request context carrying the details the
+// service records with a change's notifications
+func changeContext(r *http.Request) context.Context {
+	return services.WithLocale(r.Context(), utils.PreferredLocale(r.Header.Get("Accept-Language")))
+}
+
 // RegisterRoutes registers all user routes with the router
 func (h *UserHandler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/api/users", h.GetAllUsers).Methods("GET")
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"go-microservice/services"
+	"go-microservice/utils"
+)
+
+func TestErrorResponseCarriesRequestID(t *testing.T) {
+	services.GetUserService().Clear()
+	router := mux.NewRouter()
+	router.Use(utils.RequestIDMiddleware)
+	NewUserHandler().RegisterRoutes(router)
+
+	for name, clientID := range map[string]string{"client ID": "req-42", "generated": ""} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/users/999", nil)
+			if clientID != "" {
+				req.Header.Set(utils.RequestIDHeader, clientID)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			echoed := rec.Header().Get(utils.RequestIDHeader)
+			if echoed == "" || (clientID != "" && echoed != clientID) {
+				t.Fatalf("%s = %q, want %q", utils.RequestIDHeader, echoed, clientID)
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.RequestID != echoed {
+				t.Fatalf("ErrorResponse.RequestID = %q, want %q", body.RequestID, echoed)
+			}
+		})
+	}
+}
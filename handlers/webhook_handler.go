@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"go-microservice/services"
+	"go-microservice/utils"
+)
+
+// WebhookHandler handles HTTP requests for webhook subscriptions. All of
+// its endpoints require the admin token.
+type WebhookHandler struct {
+	webhookService *services.WebhookService
+}
+
+// NewWebhookHandler creates a new WebhookHandler
+func NewWebhookHandler() *WebhookHandler {
+	return &WebhookHandler{
+		webhookService: services.GetWebhookService(),
+	}
+}
+
+// SubscribeRequest is the body of POST /api/webhooks
+type SubscribeRequest struct {
+	URL        string                   `json:"url"`
+	EventTypes []services.UserEventType `json:"event_types"`
+	// Secret is generated when omitted
+	Secret string `json:"secret"`
+}
+
+// WebhookListResponse represents a list of webhook subscriptions
+type WebhookListResponse struct {
+	Webhooks []services.WebhookSubscription `json:"webhooks"`
+	Count    int                            `json:"count"`
+}
+
+// DeliveryListResponse represents webhook delivery history
+type DeliveryListResponse struct {
+	Deliveries []services.WebhookDelivery `json:"deliveries"`
+	Count      int                        `json:"count"`
+}
+
+// CreateWebhook handles POST /api/webhooks
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminToken(w, r) {
+		return
+	}
+
+	var req SubscribeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.LogErrorContext(r.Context(), "CreateWebhook", err, "failed to decode request body")
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	sub, err := h.webhookService.Subscribe(req.URL, req.EventTypes, req.Secret)
+	if err != nil {
+		utils.LogErrorContext(r.Context(), "CreateWebhook", err, "failed to create webhook")
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Async logging
+	utils.LogRequestAction(r.Context(), "CREATE_WEBHOOK", 0, utils.OutcomeSuccess, sub.ID+" "+sub.URL)
+
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+// ListWebhooks handles GET /api/webhooks
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminToken(w, r) {
+		return
+	}
+
+	webhooks := h.webhookService.Subscriptions()
+	writeJSON(w, http.StatusOK, WebhookListResponse{
+		Webhooks: webhooks,
+		Count:    len(webhooks),
+	})
+}
+
+// DeleteWebhook handles DELETE /api/webhooks/{id}
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminToken(w, r) {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if err := h.webhookService.Unsubscribe(id); err != nil {
+		if errors.Is(err, services.ErrWebhookNotFound) {
+			writeError(w, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		utils.LogErrorContext(r.Context(), "DeleteWebhook", err, "failed to delete webhook")
+		writeError(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+
+	// Async logging
+	utils.LogRequestAction(r.Context(), "DELETE_WEBHOOK", 0, utils.OutcomeSuccess, id)
+
+	writeJSON(w, http.StatusOK, SuccessResponse{Message: "Webhook deleted successfully"})
+}
+
+// ListDeliveries handles GET /api/webhooks/deliveries?status= and
+// GET /api/webhooks/{id}/deliveries?status=, newest first.
+// status=dead lists the dead-letter queue.
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminToken(w, r) {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", services.DeliveryPending, services.DeliverySucceeded, services.DeliveryDead:
+	default:
+		writeError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	deliveries := h.webhookService.Deliveries(mux.Vars(r)["id"], status)
+	writeJSON(w, http.StatusOK, DeliveryListResponse{
+		Deliveries: deliveries,
+		Count:      len(deliveries),
+	})
+}
+
+// RetryDelivery handles POST /api/webhooks/deliveries/{id}/retry
+func (h *WebhookHandler) RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminToken(w, r) {
+		return
+	}
+
+	delivery, err := h.webhookService.Redeliver(mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Is(err, services.ErrWebhookNotFound) {
+			writeError(w, http.StatusNotFound, "Delivery not found")
+			return
+		}
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, delivery)
+}
+
+// RegisterRoutes registers all webhook routes with the router
+func (h *WebhookHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/webhooks", h.CreateWebhook).Methods("POST")
+	router.HandleFunc("/api/webhooks", h.ListWebhooks).Methods("GET")
+	router.HandleFunc("/api/webhooks/deliveries", h.ListDeliveries).Methods("GET")
+	router.HandleFunc("/api/webhooks/deliveries/{id:[0-9a-f]+}/retry", h.RetryDelivery).Methods("POST")
+	router.HandleFunc("/api/webhooks/{id:[0-9a-f]+}", h.DeleteWebhook).Methods("DELETE")
+	router.HandleFunc("/api/webhooks/{id:[0-9a-f]+}/deliveries", h.ListDeliveries).Methods("GET")
+}
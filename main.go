@@ -18,12 +18,95 @@ import (
 )
 
 func main() {
+	logging, err := utils.LoadLogConfig()
+	if err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	utils.ConfigureLogging(logging)
+
+	// Load backup encryption keys before anything touches MinIO
+	keyring, err := services.LoadBackupKeyring()
+	if err != nil {
+		log.Fatalf("Failed to load backup keyring: %v", err)
+	}
+	services.GetIntegrationService().SetKeyring(keyring)
+
+	compression, err := services.ParseCompression(os.Getenv("BACKUP_COMPRESSION"))
+	if err != nil {
+		log.Fatalf("Invalid BACKUP_COMPRESSION: %v", err)
+	}
+	services.GetIntegrationService().SetCompression(compression)
+
+	notifications, err := utils.LoadNotificationConfig()
+	if err != nil {
+		log.Fatalf("Invalid notification configuration: %v", err)
+	}
+	utils.GetNotificationService().Configure(notifications)
+
+	auditSink, err := utils.LoadAuditSink()
+	if err != nil {
+		log.Fatalf("Invalid audit log configuration: %v", err)
+	}
+	utils.GetAuditLogger().Configure(auditSink)
+
+	accessLog, err := utils.LoadAccessLogConfig()
+	if err != nil {
+		log.Fatalf("Invalid access log configuration: %v", err)
+	}
+	if err := utils.GetAccessLogger().Configure(accessLog); err != nil {
+		log.Fatalf("Failed to open access log: %v", err)
+	}
+
+	namespaces, err := services.LoadNamespaceConfig()
+	if err != nil {
+		log.Fatalf("Invalid backup namespaces: %v", err)
+	}
+	services.GetIntegrationService().SetNamespaces(namespaces)
+
+	// Non-MinIO backup stores are ready immediately
+	store, err := services.NewBackupStoreFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure backup store: %v", err)
+	}
+	if store != nil {
+		services.GetIntegrationService().UseStore(store)
+	}
+
+	// One-shot maintenance commands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rotate-backup-keys":
+			rotateBackupKeys()
+			return
+		case "verify-audit-chain":
+			verifyAuditChain()
+			return
+		default:
+			log.Fatalf("Unknown command: %s", os.Args[1])
+		}
+	}
+
+	// Trace requests, service calls and storage operations
+	tracing, err := utils.LoadTracingConfig()
+	if err != nil {
+		log.Fatalf("Invalid tracing configuration: %v", err)
+	}
+	shutdownTracing, err := utils.ConfigureTracing(context.Background(), tracing)
+	if err != nil {
+		log.Fatalf("Failed to configure tracing: %v", err)
+	}
+
 	// Initialize router
 	router := mux.NewRouter()
 
 	// Apply middleware chain
-	// Order matters: metrics -> rate limiting -> handlers
+	// Order matters: request ID -> tracing -> access log -> metrics -> audit context -> log context -> rate limiting -> handlers
+	router.Use(utils.RequestIDMiddleware)
+	router.Use(utils.TracingMiddleware)
+	router.Use(utils.AccessLogMiddleware)
 	router.Use(metrics.MetricsMiddleware)
+	router.Use(utils.AuditContextMiddleware)
+	router.Use(utils.LogContextMiddleware)
 	router.Use(utils.RateLimitMiddleware)
 
 	// Register Prometheus metrics endpoint
@@ -36,13 +119,41 @@ func main() {
 	integrationHandler := handlers.NewIntegrationHandler()
 	integrationHandler.RegisterRoutes(router)
 
-	// Try to connect to MinIO on startup (non-blocking)
-	go func() {
-		config := services.GetDefaultConfig()
-		if err := services.GetIntegrationService().Connect(config); err != nil {
-			log.Printf("MinIO connection failed on startup (will retry on demand): %v", err)
-		}
-	}()
+	eventsHandler := handlers.NewEventsHandler()
+	eventsHandler.RegisterRoutes(router)
+
+	webhookHandler := handlers.NewWebhookHandler()
+	webhookHandler.RegisterRoutes(router)
+
+	auditHandler := handlers.NewAuditHandler()
+	auditHandler.RegisterRoutes(router)
+
+	adminHandler := handlers.NewAdminHandler()
+	adminHandler.RegisterRoutes(router)
+
+	// Deliver user events to webhook subscribers, resuming the persisted queue
+	webhookConfig, err := services.LoadWebhookConfig()
+	if err != nil {
+		log.Fatalf("Invalid webhook configuration: %v", err)
+	}
+	if err := services.GetWebhookService().Start(webhookConfig, services.GetUserService().Events()); err != nil {
+		log.Fatalf("Failed to start webhook delivery: %v", err)
+	}
+
+	// Relay notifications and audit entries recorded with user changes,
+	// including any left undelivered by the last run
+	outboxConfig, err := services.LoadOutboxConfig()
+	if err != nil {
+		log.Fatalf("Invalid outbox configuration: %v", err)
+	}
+	if err := services.GetUserService().Outbox().Start(outboxConfig); err != nil {
+		log.Fatalf("Failed to start outbox relay: %v", err)
+	}
+
+	// Connect to MinIO in the background, retrying until it is reachable
+	if store == nil {
+		services.GetIntegrationService().StartSupervisor(services.GetDefaultConfig())
+	}
 
 	// Get server port from environment or use default
 	port := os.Getenv("PORT")
@@ -59,6 +170,9 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// End event streams when shutdown starts so they do not block it
+	server.RegisterOnShutdown(services.GetUserService().Events().Close)
+
 	// Start server in goroutine
 	go func() {
 		log.Printf("Server starting on port %s", port)
@@ -69,6 +183,7 @@ func main() {
 		log.Printf("  - PUT    /api/users/{id}    - Update user")
 		log.Printf("  - DELETE /api/users/{id}    - Delete user")
 		log.Printf("  - GET    /api/health        - Health check")
+		log.Printf("  - GET    /api/events/users  - User change stream (SSE)")
 		log.Printf("  - GET    /metrics           - Prometheus metrics")
 		log.Printf("Rate limit: 1000 req/s with burst of 5000")
 
@@ -92,5 +207,85 @@ func main() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	// Stop MinIO reconnection attempts
+	services.GetIntegrationService().StopSupervisor()
+
+	// Finish in-flight webhook attempts; pending ones stay queued on disk
+	services.GetWebhookService().Stop()
+
+	// Stop relaying; undelivered outbox entries are kept in the journal
+	services.GetUserService().Outbox().Stop()
+
+	// Flush the async pipelines in dependency order: notifications and
+	// audit entries can still report errors, so the error log goes last
+	if err := utils.GetAccessLogger().Close(ctx); err != nil {
+		log.Printf("Access log not flushed: %v", err)
+	}
+	if err := utils.GetNotificationService().Close(ctx); err != nil {
+		log.Printf("Notifications not flushed: %v", err)
+	}
+	if err := utils.GetAuditLogger().Close(ctx); err != nil {
+		log.Printf("Audit log not flushed: %v", err)
+	}
+	if err := utils.GetErrorHandler().Close(ctx); err != nil {
+		log.Printf("Error log not flushed: %v", err)
+	}
+
+	// Export the spans of everything stopped above
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Traces not flushed: %v", err)
+	}
+
 	log.Println("Server stopped gracefully")
 }
+
+// rotateBackupKeys re-wraps all backup data keys under the active master key
+func rotateBackupKeys() {
+	integrationService := services.GetIntegrationService()
+	if !integrationService.IsConnected() {
+		if err := integrationService.Connect(services.GetDefaultConfig()); err != nil {
+			log.Fatalf("Failed to connect to MinIO: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	report, err := integrationService.RotateBackupKeys(ctx)
+	if err != nil {
+		log.Fatalf("Key rotation failed: %v", err)
+	}
+
+	log.Printf("Key rotation complete: active key %s, scanned %d, rewrapped %d, unencrypted %d, failed %d",
+		report.ActiveKeyID, report.Scanned, report.Rewrapped, report.Unencrypted, len(report.Failed))
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+// verifyAuditChain checks the audit chain (AUDIT_CHAIN_FILE, or the path
+// given after the command) and exits non-zero if it is broken
+func verifyAuditChain() {
+	path := utils.AuditChainFile()
+	if len(os.Args) > 2 {
+		path = os.Args[2]
+	}
+
+	key, err := utils.LoadAuditVerifyKey()
+	if err != nil {
+		log.Fatalf("Cannot verify audit chain: %v", err)
+	}
+	report, err := utils.VerifyAuditChain(path, key)
+	if err != nil {
+		log.Fatalf("Failed to verify audit chain: %v", err)
+	}
+
+	if !report.Valid {
+		log.Printf("Audit chain %s is BROKEN at sequence %d (line %d): %s",
+			path, report.Break.Seq, report.Break.Line, report.Break.Reason)
+		log.Printf("%d entries verified before the break", report.Entries)
+		os.Exit(1)
+	}
+	log.Printf("Audit chain %s is intact: %d entries, %d checkpoints, head %s",
+		path, report.Entries, report.Checkpoints, report.LastHash)
+}
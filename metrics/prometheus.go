@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"context"
 	"net/http"
 	"strconv"
 	"time"
@@ -8,6 +9,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -62,6 +64,138 @@ var (
 			Help: "Total number of active users in the system",
 		},
 	)
+
+	// MinIOConnectionState is 1 for the current MinIO connection state and 0 otherwise
+	MinIOConnectionState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "minio_connection_state",
+			Help: "Current MinIO connection state (1 for the active state)",
+		},
+		[]string{"state"},
+	)
+
+	// MinIOReconnectAttempts counts connection attempts made by the supervisor
+	MinIOReconnectAttempts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "minio_reconnect_attempts_total",
+			Help: "Total number of MinIO connection attempts by outcome",
+		},
+		[]string{"outcome"},
+	)
+
+	// StorageOperationDuration measures latency of object storage calls
+	StorageOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "minio_operation_duration_seconds",
+			Help:    "MinIO operation duration in seconds",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		},
+		[]string{"operation", "outcome"},
+	)
+
+	// StorageOperationsTotal counts object storage calls
+	StorageOperationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "minio_operations_total",
+			Help: "Total number of MinIO operations",
+		},
+		[]string{"operation", "outcome"},
+	)
+
+	// StorageBytesTotal counts payload bytes uploaded and downloaded
+	StorageBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "minio_bytes_transferred_total",
+			Help: "Total number of payload bytes transferred to and from MinIO",
+		},
+		[]string{"operation"},
+	)
+
+	// CircuitBreakerState is 1 for each breaker's current state and 0 otherwise
+	CircuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "Current circuit breaker state (1 for the active state)",
+		},
+		[]string{"breaker", "state"},
+	)
+
+	// WebhookDeliveries counts webhook delivery attempts by outcome
+	WebhookDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_deliveries_total",
+			Help: "Total number of webhook delivery attempts",
+		},
+		[]string{"outcome"},
+	)
+
+	// OutboxPending is the number of undelivered outbox entries
+	OutboxPending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_pending",
+			Help: "Number of outbox entries waiting for delivery",
+		},
+	)
+
+	// OutboxDeliveries counts outbox delivery attempts by entry kind and outcome
+	OutboxDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outbox_deliveries_total",
+			Help: "Total number of outbox delivery attempts",
+		},
+		[]string{"kind", "outcome"},
+	)
+
+	// AsyncQueueDepth is the number of items waiting in each async pipeline
+	AsyncQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "async_queue_depth",
+			Help: "Number of items queued in an async pipeline",
+		},
+		[]string{"pipeline"},
+	)
+
+	// AsyncDropped counts items an async pipeline discarded by reason
+	AsyncDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "async_dropped_total",
+			Help: "Total number of items dropped by an async pipeline",
+		},
+		[]string{"pipeline", "reason"},
+	)
+
+	// AsyncSpilled counts items an async pipeline wrote to disk on overflow
+	AsyncSpilled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "async_spilled_total",
+			Help: "Total number of items spilled to disk by an async pipeline",
+		},
+		[]string{"pipeline"},
+	)
+
+	// LogsSampled counts log records dropped as repetitive by level
+	LogsSampled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "log_messages_sampled_total",
+			Help: "Total number of repetitive log records dropped by sampling",
+		},
+		[]string{"level"},
+	)
+
+	// AppErrors counts errors reported to the error handler by fingerprint class
+	AppErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_errors_total",
+			Help: "Total number of errors reported by operation and error type",
+		},
+		[]string{"operation", "error_type"},
+	)
+)
+
+// connectionStates and breakerStates list label values reset on each transition
+var (
+	connectionStates = []string{"disconnected", "connecting", "connected"}
+	breakerStates    = []string{"closed", "open", "half-open"}
 )
 
 func init() {
@@ -72,6 +206,20 @@ func init() {
 	prometheus.MustRegister(ErrorsTotal)
 	prometheus.MustRegister(RateLimitHits)
 	prometheus.MustRegister(ActiveUsers)
+	prometheus.MustRegister(MinIOConnectionState)
+	prometheus.MustRegister(MinIOReconnectAttempts)
+	prometheus.MustRegister(CircuitBreakerState)
+	prometheus.MustRegister(StorageOperationDuration)
+	prometheus.MustRegister(StorageOperationsTotal)
+	prometheus.MustRegister(StorageBytesTotal)
+	prometheus.MustRegister(WebhookDeliveries)
+	prometheus.MustRegister(OutboxPending)
+	prometheus.MustRegister(OutboxDeliveries)
+	prometheus.MustRegister(AsyncQueueDepth)
+	prometheus.MustRegister(AsyncDropped)
+	prometheus.MustRegister(AsyncSpilled)
+	prometheus.MustRegister(LogsSampled)
+	prometheus.MustRegister(AppErrors)
 }
 
 // responseWriter wraps http.ResponseWriter to capture status code
@@ -91,6 +239,12 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap exposes the underlying writer to http.ResponseController, so
+// handlers can flush streamed responses
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // MetricsMiddleware is a middleware that collects Prometheus metrics
 func MetricsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -123,7 +277,7 @@ func MetricsMiddleware(next http.Handler) http.Handler {
 		status := strconv.Itoa(wrapped.statusCode)
 
 		TotalRequests.WithLabelValues(r.Method, path, status).Inc()
-		RequestDuration.WithLabelValues(r.Method, path).Observe(duration)
+		observeWithExemplar(r.Context(), RequestDuration.WithLabelValues(r.Method, path), duration)
 		RequestsInFlight.Dec()
 
 		// Track errors
@@ -137,9 +291,23 @@ func MetricsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// Handler returns the Prometheus HTTP handler
+// Handler returns the Prometheus HTTP handler. Histogram exemplars are
+// only exposed in the OpenMetrics format, which scrapers request with
+// their Accept header.
 func Handler() http.Handler {
-	return promhttp.Handler()
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+}
+
+// observeWithExemplar observes value, attaching the trace ID of the
+// sampled span in ctx as an exemplar so the observation links to its trace
+func observeWithExemplar(ctx context.Context, observer prometheus.Observer, value float64) {
+	span := trace.SpanContextFromContext(ctx)
+	if exemplars, ok := observer.(prometheus.ExemplarObserver); ok && span.IsSampled() {
+		exemplars.ObserveWithExemplar(value, prometheus.Labels{"trace_id": span.TraceID().String()})
+		return
+	}
+	observer.Observe(value)
 }
 
 // IncrementRateLimitHits increments the rate limit hit counter
@@ -151,3 +319,76 @@ func IncrementRateLimitHits() {
 func SetActiveUsers(count float64) {
 	ActiveUsers.Set(count)
 }
+
+// SetMinIOConnectionState marks state as the current MinIO connection state
+func SetMinIOConnectionState(state string) {
+	for _, s := range connectionStates {
+		MinIOConnectionState.WithLabelValues(s).Set(0)
+	}
+	MinIOConnectionState.WithLabelValues(state).Set(1)
+}
+
+// IncrementMinIOReconnectAttempts counts a supervisor connection attempt
+func IncrementMinIOReconnectAttempts(outcome string) {
+	MinIOReconnectAttempts.WithLabelValues(outcome).Inc()
+}
+
+// ObserveStorageOperation records the outcome and latency of a MinIO call
+// made with ctx
+func ObserveStorageOperation(ctx context.Context, operation, outcome string, duration time.Duration) {
+	StorageOperationsTotal.WithLabelValues(operation, outcome).Inc()
+	observeWithExemplar(ctx, StorageOperationDuration.WithLabelValues(operation, outcome), duration.Seconds())
+}
+
+// AddStorageBytes counts payload bytes moved by a MinIO call
+func AddStorageBytes(operation string, bytes int) {
+	StorageBytesTotal.WithLabelValues(operation).Add(float64(bytes))
+}
+
+// SetCircuitBreakerState marks state as the current state of breaker
+func SetCircuitBreakerState(breaker, state string) {
+	for _, s := range breakerStates {
+		CircuitBreakerState.WithLabelValues(breaker, s).Set(0)
+	}
+	CircuitBreakerState.WithLabelValues(breaker, state).Set(1)
+}
+
+// IncrementWebhookDeliveries counts a webhook delivery attempt
+func IncrementWebhookDeliveries(outcome string) {
+	WebhookDeliveries.WithLabelValues(outcome).Inc()
+}
+
+// SetOutboxPending sets the number of undelivered outbox entries
+func SetOutboxPending(count float64) {
+	OutboxPending.Set(count)
+}
+
+// IncrementOutboxDeliveries counts an outbox delivery attempt
+func IncrementOutboxDeliveries(kind, outcome string) {
+	OutboxDeliveries.WithLabelValues(kind, outcome).Inc()
+}
+
+// SetAsyncQueueDepth sets the number of items queued in a pipeline
+func SetAsyncQueueDepth(pipeline string, depth int) {
+	AsyncQueueDepth.WithLabelValues(pipeline).Set(float64(depth))
+}
+
+// IncrementAsyncDropped counts an item dropped by a pipeline
+func IncrementAsyncDropped(pipeline, reason string) {
+	AsyncDropped.WithLabelValues(pipeline, reason).Inc()
+}
+
+// IncrementAsyncSpilled counts an item spilled to disk by a pipeline
+func IncrementAsyncSpilled(pipeline string) {
+	AsyncSpilled.WithLabelValues(pipeline).Inc()
+}
+
+// IncrementLogsSampled counts a log record dropped by sampling
+func IncrementLogsSampled(level string) {
+	LogsSampled.WithLabelValues(level).Inc()
+}
+
+// IncrementErrors counts an error of the given operation and type
+func IncrementErrors(operation, errorType string) {
+	AppErrors.WithLabelValues(operation, errorType).Inc()
+}
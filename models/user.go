@@ -4,13 +4,16 @@ import (
 	"errors"
 	"regexp"
 	"strings"
+	"time"
 )
 
 // User represents a user entity in the system
 type User struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // emailRegex is a compiled regular expression for email validation
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"go-microservice/models"
+)
+
+// Compression selects how backup payloads are compressed before upload
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+// metaChecksum holds the hex SHA-256 of the stored object bytes
+const metaChecksum = "Backup-Sha256"
+
+// metaRequestID holds the ID of the request that made the backup, if any
+const metaRequestID = "Backup-Request-Id"
+
+// ErrChecksumMismatch is returned when a backup does not match its stored checksum
+var ErrChecksumMismatch = errors.New("backup checksum mismatch")
+
+// ParseCompression converts a configuration value into a Compression.
+// An empty value selects CompressionNone.
+func ParseCompression(value string) (Compression, error) {
+	switch c := Compression(value); c {
+	case "":
+		return CompressionNone, nil
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown backup compression %q", value)
+	}
+}
+
+// contentEncoding returns the Content-Encoding header value for c
+func (c Compression) contentEncoding() string {
+	if c == CompressionNone {
+		return ""
+	}
+	return string(c)
+}
+
+// compress encodes data with c
+func (c Compression) compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch c {
+	case CompressionNone, "":
+		return data, nil
+	case CompressionGzip:
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(data); err != nil {
+			zw.Close()
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown backup compression %q", c)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompress decodes data stored with the given Content-Encoding
+func decompress(data []byte, contentEncoding string) ([]byte, error) {
+	switch Compression(contentEncoding) {
+	case "", CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	case CompressionZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", contentEncoding)
+	}
+}
+
+// checksum returns the hex SHA-256 of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyChecksum compares data against the checksum recorded in meta.
+// Objects written before checksums were recorded pass unchecked.
+func verifyChecksum(data []byte, meta map[string]string) error {
+	expected, ok := meta[metaChecksum]
+	if !ok {
+		return nil
+	}
+	if actual := checksum(data); actual != expected {
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, expected, actual)
+	}
+	return nil
+}
+
+// encodeBackup serializes a user into the stored object: JSON, then
+// compression, then encryption, with a checksum of the final bytes
+func encodeBackup(user *models.User, objectName string, compression Compression, keyring *BackupKeyring) (*BackupObject, error) {
+	obj := &BackupObject{
+		Key:         objectName,
+		ContentType: "application/json",
+		Metadata:    make(map[string]string),
+	}
+
+	// Serialize user to JSON
+	data, err := json.Marshal(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal user: %w", err)
+	}
+
+	data, err = compression.compress(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress user backup: %w", err)
+	}
+	obj.ContentEncoding = compression.contentEncoding()
+
+	if keyring != nil {
+		var meta map[string]string
+		data, meta, err = keyring.Encrypt(data, objectName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt user backup: %w", err)
+		}
+		for key, value := range meta {
+			obj.Metadata[key] = value
+		}
+		obj.ContentType = "application/octet-stream"
+	}
+
+	obj.Data = data
+	obj.Metadata[metaChecksum] = checksum(data)
+	return obj, nil
+}
+
+// decodeBackup reverses encodeBackup, verifying the checksum first
+func decodeBackup(obj *BackupObject, keyring *BackupKeyring) (*models.User, error) {
+	if err := verifyChecksum(obj.Data, obj.Metadata); err != nil {
+		return nil, err
+	}
+
+	data := obj.Data
+	var err error
+	if isEncrypted(obj.Metadata) {
+		if keyring == nil {
+			return nil, fmt.Errorf("user backup is encrypted but no keyring is configured")
+		}
+		data, err = keyring.Decrypt(data, obj.Metadata, obj.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err = decompress(data, obj.ContentEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress user backup: %w", err)
+	}
+
+	var user models.User
+	if err := json.Unmarshal(data, &user); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+	}
+
+	return &user, nil
+}
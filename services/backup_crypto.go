@@ -0,0 +1,269 @@
+package services
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Object metadata keys describing an encrypted backup payload.
+// Keys are in canonical header form as returned in ObjectInfo.UserMetadata.
+const (
+	metaCipher     = "Backup-Cipher"
+	metaKeyID      = "Backup-Key-Id"
+	metaWrappedKey = "Backup-Wrapped-Key"
+
+	backupCipherAESGCM = "AES-256-GCM"
+)
+
+// BackupKeyring holds the master keys used to wrap per-object data keys.
+// Payloads are encrypted with a fresh AES-256-GCM data key; the data key is
+// wrapped by the active master key and stored next to its key ID in object
+// metadata. Retired master keys stay in the keyring so older objects and
+// versions can still be decrypted.
+type BackupKeyring struct {
+	keys     map[string][]byte
+	activeID string
+}
+
+// NewBackupKeyring creates a keyring from 32-byte master keys indexed by ID
+func NewBackupKeyring(keys map[string][]byte, activeID string) (*BackupKeyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keyring has no master keys")
+	}
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("master key %q must be 32 bytes, got %d", id, len(key))
+		}
+	}
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("active master key %q not found in keyring", activeID)
+	}
+
+	return &BackupKeyring{keys: keys, activeID: activeID}, nil
+}
+
+// LoadBackupKeyring builds a keyring from the environment:
+//
+//	BACKUP_MASTER_KEY     base64 master key
+//	BACKUP_MASTER_KEY_ID  ID of BACKUP_MASTER_KEY (default "default")
+//	BACKUP_KEYFILE        file with one "<key-id> <base64 key>" per line
+//	BACKUP_ACTIVE_KEY_ID  key used for new backups; defaults to the env key,
+//	                      otherwise the last key in the keyfile
+//
+// It returns nil without error when no master key is configured, which
+// leaves backups unencrypted.
+func LoadBackupKeyring() (*BackupKeyring, error) {
+	keys := make(map[string][]byte)
+	activeID := ""
+
+	if path := os.Getenv("BACKUP_KEYFILE"); path != "" {
+		lastID, err := readKeyfile(path, keys)
+		if err != nil {
+			return nil, err
+		}
+		activeID = lastID
+	}
+
+	if encoded := os.Getenv("BACKUP_MASTER_KEY"); encoded != "" {
+		id := os.Getenv("BACKUP_MASTER_KEY_ID")
+		if id == "" {
+			id = "default"
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			// Never include the key material in the error
+			return nil, errors.New("BACKUP_MASTER_KEY is not valid base64")
+		}
+		keys[id] = key
+		activeID = id
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	if id := os.Getenv("BACKUP_ACTIVE_KEY_ID"); id != "" {
+		activeID = id
+	}
+
+	return NewBackupKeyring(keys, activeID)
+}
+
+// readKeyfile loads keys from path into keys and returns the last key ID read
+func readKeyfile(path string, keys map[string][]byte) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open keyfile: %w", err)
+	}
+	defer f.Close()
+
+	lastID := ""
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return "", fmt.Errorf("keyfile line %d: expected \"<key-id> <base64 key>\"", lineNo)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return "", fmt.Errorf("keyfile line %d: key is not valid base64", lineNo)
+		}
+		keys[fields[0]] = key
+		lastID = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read keyfile: %w", err)
+	}
+
+	return lastID, nil
+}
+
+// ActiveKeyID returns the ID of the master key used for new backups
+func (k *BackupKeyring) ActiveKeyID() string {
+	return k.activeID
+}
+
+// Encrypt seals plaintext with a new data key bound to objectName and
+// returns the payload together with the metadata needed to decrypt it
+func (k *BackupKeyring) Encrypt(plaintext []byte, objectName string) ([]byte, map[string]string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	payload, err := seal(dataKey, plaintext, []byte(objectName))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := seal(k.keys[k.activeID], dataKey, []byte(k.activeID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta := map[string]string{
+		metaCipher:     backupCipherAESGCM,
+		metaKeyID:      k.activeID,
+		metaWrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+	}
+	return payload, meta, nil
+}
+
+// Decrypt opens a payload produced by Encrypt using its object metadata
+func (k *BackupKeyring) Decrypt(payload []byte, meta map[string]string, objectName string) ([]byte, error) {
+	dataKey, err := k.unwrap(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := open(dataKey, payload, []byte(objectName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup payload: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Rewrap re-encrypts the data key in meta under the active master key.
+// It reports false when the data key is already wrapped by the active key.
+func (k *BackupKeyring) Rewrap(meta map[string]string) (map[string]string, bool, error) {
+	if meta[metaKeyID] == k.activeID {
+		return meta, false, nil
+	}
+
+	dataKey, err := k.unwrap(meta)
+	if err != nil {
+		return nil, false, err
+	}
+
+	wrapped, err := seal(k.keys[k.activeID], dataKey, []byte(k.activeID))
+	if err != nil {
+		return nil, false, err
+	}
+
+	rewrapped := make(map[string]string, len(meta))
+	for key, value := range meta {
+		rewrapped[key] = value
+	}
+	rewrapped[metaKeyID] = k.activeID
+	rewrapped[metaWrappedKey] = base64.StdEncoding.EncodeToString(wrapped)
+	return rewrapped, true, nil
+}
+
+// unwrap recovers the data key described by meta
+func (k *BackupKeyring) unwrap(meta map[string]string) ([]byte, error) {
+	if alg := meta[metaCipher]; alg != backupCipherAESGCM {
+		return nil, fmt.Errorf("unsupported backup cipher %q", alg)
+	}
+
+	keyID := meta[metaKeyID]
+	masterKey, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %q not available", keyID)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(meta[metaWrappedKey])
+	if err != nil {
+		return nil, errors.New("wrapped data key is not valid base64")
+	}
+
+	dataKey, err := open(masterKey, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with master key %q: %w", keyID, err)
+	}
+	return dataKey, nil
+}
+
+// isEncrypted reports whether object metadata describes an encrypted payload
+func isEncrypted(meta map[string]string) bool {
+	return meta[metaCipher] != ""
+}
+
+// seal encrypts plaintext with AES-GCM and prepends the nonce
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open reverses seal
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// newGCM creates an AES-GCM AEAD for key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testMasterKey returns a 32-byte master key filled with b
+func testMasterKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func newTestKeyring(t *testing.T, activeID string) *BackupKeyring {
+	t.Helper()
+	keyring, err := NewBackupKeyring(map[string][]byte{
+		"old": testMasterKey(1),
+		"new": testMasterKey(2),
+	}, activeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestBackupKeyringRoundTrip(t *testing.T) {
+	keyring := newTestKeyring(t, "old")
+	plaintext := []byte(`{"id":1,"name":"Alice"}`)
+
+	payload, meta, err := keyring.Encrypt(plaintext, "users/user_1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(payload, plaintext) {
+		t.Fatal("payload contains the plaintext")
+	}
+	if meta[metaCipher] != backupCipherAESGCM || meta[metaKeyID] != "old" || meta[metaWrappedKey] == "" {
+		t.Fatalf("unexpected metadata %v", meta)
+	}
+	if !isEncrypted(meta) {
+		t.Error("isEncrypted(meta) = false")
+	}
+
+	got, err := keyring.Decrypt(payload, meta, "users/user_1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("Decrypt = %q, want %q", got, plaintext)
+	}
+}
+
+func TestBackupKeyringRejectsTampering(t *testing.T) {
+	keyring := newTestKeyring(t, "old")
+	payload, meta, err := keyring.Encrypt([]byte("secret"), "users/user_1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withMeta := func(key, value string) map[string]string {
+		changed := make(map[string]string, len(meta))
+		for k, v := range meta {
+			changed[k] = v
+		}
+		changed[key] = value
+		return changed
+	}
+	flipped := append([]byte(nil), payload...)
+	flipped[len(flipped)-1] ^= 0xff
+
+	for _, tc := range []struct {
+		name       string
+		payload    []byte
+		meta       map[string]string
+		objectName string
+	}{
+		{"object name mismatch", payload, meta, "users/user_2.json"},
+		{"tampered ciphertext", flipped, meta, "users/user_1.json"},
+		{"truncated ciphertext", payload[:4], meta, "users/user_1.json"},
+		// The wrapped key is bound to its key ID, so relabelling it fails
+		{"key ID mismatch", payload, withMeta(metaKeyID, "new"), "users/user_1.json"},
+		{"unknown key ID", payload, withMeta(metaKeyID, "missing"), "users/user_1.json"},
+		{"unsupported cipher", payload, withMeta(metaCipher, "ROT13"), "users/user_1.json"},
+		{"invalid wrapped key", payload, withMeta(metaWrappedKey, "!!"), "users/user_1.json"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, err := keyring.Decrypt(tc.payload, tc.meta, tc.objectName); err == nil {
+				t.Fatalf("Decrypt succeeded with %q", got)
+			}
+		})
+	}
+}
+
+func TestBackupKeyringRewrap(t *testing.T) {
+	payload, meta, err := newTestKeyring(t, "old").Encrypt([]byte("secret"), "users/user_1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate: "new" becomes active while "old" stays in the keyring
+	keyring := newTestKeyring(t, "new")
+	rewrapped, changed, err := keyring.Rewrap(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || rewrapped[metaKeyID] != "new" {
+		t.Fatalf("Rewrap = %v, %v; want key \"new\"", rewrapped, changed)
+	}
+	if meta[metaKeyID] != "old" {
+		t.Error("Rewrap modified the original metadata")
+	}
+
+	// The payload is untouched; only the data key was rewrapped
+	retired, err := NewBackupKeyring(map[string][]byte{"new": testMasterKey(2)}, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := retired.Decrypt(payload, rewrapped, "users/user_1.json")
+	if err != nil || string(got) != "secret" {
+		t.Fatalf("Decrypt after rewrap = %q, %v", got, err)
+	}
+	if _, err := retired.Decrypt(payload, meta, "users/user_1.json"); err == nil {
+		t.Error("Decrypt succeeded with the retired key removed")
+	}
+
+	_, changed, err = keyring.Rewrap(rewrapped)
+	if err != nil || changed {
+		t.Errorf("Rewrap of current metadata = %v, %v; want no change", changed, err)
+	}
+}
+
+func TestNewBackupKeyringValidation(t *testing.T) {
+	if _, err := NewBackupKeyring(nil, "default"); err == nil {
+		t.Error("accepted an empty keyring")
+	}
+	if _, err := NewBackupKeyring(map[string][]byte{"short": make([]byte, 16)}, "short"); err == nil {
+		t.Error("accepted a 16-byte master key")
+	}
+	if _, err := NewBackupKeyring(map[string][]byte{"a": testMasterKey(1)}, "b"); err == nil {
+		t.Error("accepted a missing active key")
+	}
+}
+
+func TestLoadBackupKeyring(t *testing.T) {
+	encode := base64.StdEncoding.EncodeToString
+	keyfile := filepath.Join(t.TempDir(), "keys")
+	content := "# rotated keys\n\nold " + encode(testMasterKey(1)) + "\nnew " + encode(testMasterKey(2)) + "\n"
+	if err := os.WriteFile(keyfile, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	badLine := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(badLine, []byte("only-an-id\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		env     map[string]string
+		active  string
+		wantErr string
+	}{
+		{"unconfigured", nil, "", ""},
+		{"env key", map[string]string{"BACKUP_MASTER_KEY": encode(testMasterKey(1))}, "default", ""},
+		{"env key with ID", map[string]string{"BACKUP_MASTER_KEY": encode(testMasterKey(1)), "BACKUP_MASTER_KEY_ID": "k1"}, "k1", ""},
+		{"keyfile uses last key", map[string]string{"BACKUP_KEYFILE": keyfile}, "new", ""},
+		{"explicit active key", map[string]string{"BACKUP_KEYFILE": keyfile, "BACKUP_ACTIVE_KEY_ID": "old"}, "old", ""},
+		{"bad base64", map[string]string{"BACKUP_MASTER_KEY": "not base64!"}, "", "not valid base64"},
+		{"wrong key length", map[string]string{"BACKUP_MASTER_KEY": encode(make([]byte, 16))}, "", "must be 32 bytes"},
+		{"missing active key", map[string]string{"BACKUP_KEYFILE": keyfile, "BACKUP_ACTIVE_KEY_ID": "gone"}, "", "not found"},
+		{"missing keyfile", map[string]string{"BACKUP_KEYFILE": filepath.Join(t.TempDir(), "none")}, "", "failed to open keyfile"},
+		{"malformed keyfile", map[string]string{"BACKUP_KEYFILE": badLine}, "", "line 1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, name := range []string{"BACKUP_MASTER_KEY", "BACKUP_MASTER_KEY_ID", "BACKUP_KEYFILE", "BACKUP_ACTIVE_KEY_ID"} {
+				t.Setenv(name, tc.env[name])
+			}
+
+			keyring, err := LoadBackupKeyring()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.active == "" {
+				if keyring != nil {
+					t.Fatalf("keyring = %v, want nil", keyring)
+				}
+				return
+			}
+			if keyring.ActiveKeyID() != tc.active {
+				t.Errorf("ActiveKeyID = %q, want %q", keyring.ActiveKeyID(), tc.active)
+			}
+		})
+	}
+}
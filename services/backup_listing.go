@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"go-microservice/utils"
+)
+
+// ErrInvalidContinuationToken is returned for a token that was not issued
+// by ListBackups for the same namespace
+var ErrInvalidContinuationToken = errors.New("invalid continuation token")
+
+// errPageFull stops a listing once a page has one entry more than its limit
+var errPageFull = errors.New("page full")
+
+// BackupListOptions selects the backups returned by ListBackups
+type BackupListOptions struct {
+	// Prefix filters on the key below the namespace's users/ prefix,
+	// e.g. "4" matches users/4.json and users/42.json
+	Prefix string
+	// Token resumes a listing after the last entry of a previous page
+	Token string
+	// Limit caps the number of entries; zero lists everything
+	Limit int
+}
+
+// BackupEntry describes a stored user backup without its payload
+type BackupEntry struct {
+	Key          string    `json:"key"`
+	UserID       int       `json:"user_id,omitempty"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	ETag         string    `json:"etag"`
+}
+
+// ListBackups calls fn for each user backup in ns in key order, without
+// holding the listing in memory. When opts.Limit entries were returned and
+// more remain, it returns a continuation token for the next page.
+func (s *IntegrationService) ListBackups(ctx context.Context, ns Namespace, opts BackupListOptions, fn func(BackupEntry) error) (_ string, err error) {
+	ctx, span := utils.StartSpan(ctx, "IntegrationService.ListBackups", attribute.String("backup.namespace", ns.Name))
+	defer func() { utils.EndSpan(span, err) }()
+
+	store, err := s.storeFor(ns)
+	if err != nil {
+		return "", err
+	}
+
+	startAfter, err := decodeContinuationToken(ns, opts.Token)
+	if err != nil {
+		return "", err
+	}
+
+	count := 0
+	last := ""
+	visit := func(info ObjectInfo) error {
+		if info.Key <= startAfter {
+			return nil
+		}
+		if opts.Limit > 0 && count == opts.Limit {
+			return errPageFull
+		}
+
+		entry := BackupEntry{
+			Key:          info.Key,
+			Size:         info.Size,
+			LastModified: info.LastModified,
+			ETag:         strings.Trim(info.ETag, `"`),
+		}
+		// Foreign objects under the prefix are listed without a user ID
+		if id, err := ns.UserID(info.Key); err == nil {
+			entry.UserID = id
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+		count++
+		last = info.Key
+		return nil
+	}
+
+	prefix := ns.UsersPrefix() + opts.Prefix
+	if lister, ok := store.(RangeLister); ok {
+		err = lister.ListAfter(ctx, prefix, startAfter, visit)
+	} else {
+		err = store.List(ctx, prefix, visit)
+	}
+
+	if errors.Is(err, errPageFull) {
+		return encodeContinuationToken(last), nil
+	}
+	return "", err
+}
+
+// encodeContinuationToken makes an opaque token from the last listed key
+func encodeContinuationToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodeContinuationToken returns the key a token resumes after; an empty
+// token starts from the beginning
+func decodeContinuationToken(ns Namespace, token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || !strings.HasPrefix(string(key), ns.UsersPrefix()) {
+		return "", fmt.Errorf("%w for namespace %s", ErrInvalidContinuationToken, ns.Name)
+	}
+	return string(key), nil
+}
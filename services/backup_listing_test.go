@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go-microservice/models"
+)
+
+// listOnlyStore hides a store's RangeLister so ListBackups falls back to
+// filtering a full List
+type listOnlyStore struct {
+	BackupStore
+}
+
+// listKeys returns the keys of one ListBackups page and its continuation token
+func listKeys(t *testing.T, s *IntegrationService, ns Namespace, opts BackupListOptions) ([]string, string) {
+	t.Helper()
+	keys := []string{}
+	token, err := s.ListBackups(context.Background(), ns, opts, func(entry BackupEntry) error {
+		keys = append(keys, entry.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListBackups(%+v): %v", opts, err)
+	}
+	return keys, token
+}
+
+func TestListBackupsPagination(t *testing.T) {
+	fsStore, err := NewFileSystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range map[string]BackupStore{
+		"memory":     NewMemoryStore(),
+		"filesystem": fsStore,
+		"list only":  listOnlyStore{NewMemoryStore()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestIntegrationService(store)
+			ns, _ := s.Namespace("")
+
+			var want []string
+			for _, id := range []int{1, 2, 3, 10, 11, 42, 7} {
+				user := &models.User{ID: id, Name: "User", Email: "user@example.com"}
+				if err := s.BackupUser(ctx, ns, user); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Keys sort as strings
+			for _, id := range []string{"1", "10", "11", "2", "3", "42", "7"} {
+				want = append(want, fmt.Sprintf("users/%s.json", id))
+			}
+
+			all, token := listKeys(t, s, ns, BackupListOptions{})
+			if !reflect.DeepEqual(all, want) || token != "" {
+				t.Fatalf("full listing = %v, %q; want %v", all, token, want)
+			}
+
+			var paged []string
+			opts := BackupListOptions{Limit: 3}
+			for pages := 1; ; pages++ {
+				keys, next := listKeys(t, s, ns, opts)
+				paged = append(paged, keys...)
+				if next == "" {
+					if pages != 3 {
+						t.Errorf("listing took %d pages, want 3", pages)
+					}
+					break
+				}
+				if len(keys) != 3 {
+					t.Fatalf("page %d has %d entries before the end", pages, len(keys))
+				}
+				opts.Token = next
+			}
+			if !reflect.DeepEqual(paged, want) {
+				t.Fatalf("paged listing = %v, want %v", paged, want)
+			}
+
+			// An exact final page returns no token
+			if keys, next := listKeys(t, s, ns, BackupListOptions{Limit: len(want)}); len(keys) != len(want) || next != "" {
+				t.Fatalf("exact page = %v, %q", keys, next)
+			}
+
+			keys, _ := listKeys(t, s, ns, BackupListOptions{Prefix: "1"})
+			if !reflect.DeepEqual(keys, []string{"users/1.json", "users/10.json", "users/11.json"}) {
+				t.Fatalf("prefix listing = %v", keys)
+			}
+		})
+	}
+}
+
+func TestListBackupsContinuationTokenStaysValid(t *testing.T) {
+	ctx := context.Background()
+	s := newTestIntegrationService(NewMemoryStore())
+	ns, _ := s.Namespace("")
+	for id := 1; id <= 4; id++ {
+		if err := s.BackupUser(ctx, ns, &models.User{ID: id, Name: "User", Email: "user@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, token := listKeys(t, s, ns, BackupListOptions{Limit: 2})
+	// Deleting the last listed object does not invalidate the token
+	if err := s.DeleteUserBackup(ctx, ns, 2); err != nil {
+		t.Fatal(err)
+	}
+	keys, next := listKeys(t, s, ns, BackupListOptions{Limit: 2, Token: token})
+	if !reflect.DeepEqual(keys, []string{"users/3.json", "users/4.json"}) || next != "" {
+		t.Fatalf("second page = %v, %q", keys, next)
+	}
+}
+
+func TestListBackupsRejectsForeignTokens(t *testing.T) {
+	s := newTestIntegrationService(NewMemoryStore())
+	s.SetNamespaces(newNamespaceTestConfig(t))
+	prod, _ := s.Namespace("production")
+	staging, _ := s.Namespace("staging")
+
+	for name, token := range map[string]string{
+		"not base64":      "%%%",
+		"other namespace": encodeContinuationToken(staging.ObjectName(1)),
+		"outside users/":  encodeContinuationToken("prod/other/1.json"),
+	} {
+		_, err := s.ListBackups(context.Background(), prod, BackupListOptions{Token: token}, func(BackupEntry) error { return nil })
+		if !errors.Is(err, ErrInvalidContinuationToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidContinuationToken", name, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-microservice/models"
+)
+
+// DefaultNamespace is the namespace used when none is configured. It keeps
+// the original layout: users/{id}.json in the store's default bucket.
+const DefaultNamespace = "default"
+
+// ErrUnknownNamespace is returned for a namespace that is not configured
+var ErrUnknownNamespace = errors.New("unknown backup namespace")
+
+// Namespace scopes backups to a bucket and key prefix, so environments or
+// tenants can share one object store without seeing each other's data
+type Namespace struct {
+	Name string `json:"name"`
+	// Bucket overrides the store's default bucket when set
+	Bucket string `json:"bucket,omitempty"`
+	// Prefix is prepended to every key, e.g. "staging/"
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// NamespaceRoute sends backups of users whose email domain matches to a namespace
+type NamespaceRoute struct {
+	EmailDomain string `json:"email_domain"`
+	Namespace   string `json:"namespace"`
+}
+
+// NamespaceConfig holds the configured namespaces and routing policy
+type NamespaceConfig struct {
+	Default    string               `json:"default"`
+	Namespaces map[string]Namespace `json:"namespaces"`
+	Routes     []NamespaceRoute     `json:"routes"`
+}
+
+// DefaultNamespaceConfig returns a config with only DefaultNamespace
+func DefaultNamespaceConfig() *NamespaceConfig {
+	return &NamespaceConfig{
+		Default:    DefaultNamespace,
+		Namespaces: map[string]Namespace{DefaultNamespace: {Name: DefaultNamespace}},
+	}
+}
+
+// LoadNamespaceConfig reads namespaces from BACKUP_NAMESPACES, a JSON
+// NamespaceConfig, for example:
+//
+//	{"default": "production",
+//	 "namespaces": {"production": {"prefix": "prod/"},
+//	                "staging": {"bucket": "users-staging", "prefix": "staging/"}},
+//	 "routes": [{"email_domain": "test.example.com", "namespace": "staging"}]}
+//
+// BACKUP_NAMESPACE overrides the default namespace.
+func LoadNamespaceConfig() (*NamespaceConfig, error) {
+	config := DefaultNamespaceConfig()
+
+	if raw := os.Getenv("BACKUP_NAMESPACES"); raw != "" {
+		config = &NamespaceConfig{}
+		if err := json.Unmarshal([]byte(raw), config); err != nil {
+			return nil, fmt.Errorf("invalid BACKUP_NAMESPACES: %w", err)
+		}
+	}
+	if name := os.Getenv("BACKUP_NAMESPACE"); name != "" {
+		config.Default = name
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// validate normalizes names and prefixes and checks references
+func (c *NamespaceConfig) validate() error {
+	if len(c.Namespaces) == 0 {
+		return errors.New("no backup namespaces configured")
+	}
+
+	for name, ns := range c.Namespaces {
+		ns.Name = name
+		if ns.Prefix != "" && !strings.HasSuffix(ns.Prefix, "/") {
+			ns.Prefix += "/"
+		}
+		if strings.HasPrefix(ns.Prefix, "/") || strings.Contains(ns.Prefix, "..") {
+			return fmt.Errorf("namespace %q: invalid prefix %q", name, ns.Prefix)
+		}
+		c.Namespaces[name] = ns
+	}
+
+	// Two namespaces sharing a bucket must not be able to see each other
+	names := c.Names()
+	for i, a := range names {
+		for _, b := range names[i+1:] {
+			nsA, nsB := c.Namespaces[a], c.Namespaces[b]
+			prefixA, prefixB := nsA.UsersPrefix(), nsB.UsersPrefix()
+			if nsA.Bucket == nsB.Bucket &&
+				(strings.HasPrefix(prefixA, prefixB) || strings.HasPrefix(prefixB, prefixA)) {
+				return fmt.Errorf("namespaces %q and %q overlap", a, b)
+			}
+		}
+	}
+
+	if c.Default == "" {
+		if len(c.Namespaces) != 1 {
+			return errors.New("default backup namespace not set")
+		}
+		c.Default = names[0]
+	}
+	if _, ok := c.Namespaces[c.Default]; !ok {
+		return fmt.Errorf("%w: default %q", ErrUnknownNamespace, c.Default)
+	}
+	for _, route := range c.Routes {
+		if _, ok := c.Namespaces[route.Namespace]; !ok {
+			return fmt.Errorf("%w: route target %q", ErrUnknownNamespace, route.Namespace)
+		}
+	}
+	return nil
+}
+
+// Names returns the configured namespace names in sorted order
+func (c *NamespaceConfig) Names() []string {
+	names := make([]string, 0, len(c.Namespaces))
+	for name := range c.Namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns a namespace by name; an empty name selects the default
+func (c *NamespaceConfig) Get(name string) (Namespace, error) {
+	if name == "" {
+		name = c.Default
+	}
+	ns, ok := c.Namespaces[name]
+	if !ok {
+		return Namespace{}, fmt.Errorf("%w: %s", ErrUnknownNamespace, name)
+	}
+	return ns, nil
+}
+
+// Route picks the namespace for a user's backup from the routing rules,
+// falling back to the default namespace
+func (c *NamespaceConfig) Route(user *models.User) Namespace {
+	domain := ""
+	if at := strings.LastIndex(user.Email, "@"); at >= 0 {
+		domain = strings.ToLower(user.Email[at+1:])
+	}
+
+	for _, route := range c.Routes {
+		if strings.EqualFold(route.EmailDomain, domain) {
+			return c.Namespaces[route.Namespace]
+		}
+	}
+	return c.Namespaces[c.Default]
+}
+
+// UsersPrefix is the key prefix under which the namespace's user backups live
+func (n Namespace) UsersPrefix() string {
+	return n.Prefix + "users/"
+}
+
+// ObjectName returns the key of a user's backup in this namespace
+func (n Namespace) ObjectName(userID int) string {
+	return fmt.Sprintf("%s%d.json", n.UsersPrefix(), userID)
+}
+
+// UserID extracts the user ID from a backup key in this namespace,
+// such as "staging/users/42.json"
+func (n Namespace) UserID(objectName string) (int, error) {
+	name, ok := strings.CutPrefix(objectName, n.UsersPrefix())
+	if !ok || !strings.HasSuffix(name, ".json") {
+		return 0, fmt.Errorf("not a user backup object in namespace %s: %s", n.Name, objectName)
+	}
+
+	id, err := strconv.Atoi(strings.TrimSuffix(name, ".json"))
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid user ID in object name: %s", objectName)
+	}
+	return id, nil
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go-microservice/models"
+)
+
+// newNamespaceTestConfig returns a validated config with a production
+// namespace in the default bucket and a staging namespace in its own bucket
+func newNamespaceTestConfig(t *testing.T) *NamespaceConfig {
+	t.Helper()
+	t.Setenv("BACKUP_NAMESPACE", "")
+	t.Setenv("BACKUP_NAMESPACES", `{
+		"default": "production",
+		"namespaces": {
+			"production": {"prefix": "prod"},
+			"staging": {"bucket": "users-staging", "prefix": "staging/"},
+			"archive": {"prefix": "archive/"}
+		},
+		"routes": [{"email_domain": "Test.Example.com", "namespace": "staging"}]
+	}`)
+
+	config, err := LoadNamespaceConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestNamespaceConfigRoute(t *testing.T) {
+	config := newNamespaceTestConfig(t)
+
+	for email, want := range map[string]string{
+		"ada@example.com":        "production",
+		"grace@test.example.com": "staging",
+		"alan@TEST.EXAMPLE.COM":  "staging",
+		"no-at-sign":             "production",
+	} {
+		if got := config.Route(&models.User{Email: email}); got.Name != want {
+			t.Errorf("Route(%q) = %q, want %q", email, got.Name, want)
+		}
+	}
+
+	ns, err := config.Get("")
+	if err != nil || ns.Name != "production" {
+		t.Fatalf("Get(\"\") = %+v, %v", ns, err)
+	}
+	if _, err := config.Get("nope"); !errors.Is(err, ErrUnknownNamespace) {
+		t.Fatalf("Get(unknown) = %v, want ErrUnknownNamespace", err)
+	}
+}
+
+func TestNamespaceObjectNames(t *testing.T) {
+	config := newNamespaceTestConfig(t)
+	prod := config.Namespaces["production"]
+
+	// A prefix without a trailing slash is normalized
+	if got := prod.ObjectName(42); got != "prod/users/42.json" {
+		t.Fatalf("ObjectName = %q", got)
+	}
+	if id, err := prod.UserID("prod/users/42.json"); err != nil || id != 42 {
+		t.Fatalf("UserID = %d, %v", id, err)
+	}
+	for _, name := range []string{"staging/users/42.json", "prod/users/x.json", "prod/users/0.json", "prod/users/42.txt"} {
+		if _, err := prod.UserID(name); err == nil {
+			t.Errorf("UserID(%q) succeeded", name)
+		}
+	}
+}
+
+func TestLoadNamespaceConfigValidation(t *testing.T) {
+	for name, raw := range map[string]string{
+		"invalid JSON":         `{`,
+		"no namespaces":        `{"namespaces": {}}`,
+		"overlapping prefix":   `{"default": "a", "namespaces": {"a": {}, "b": {"prefix": "users/"}}}`,
+		"absolute prefix":      `{"namespaces": {"a": {"prefix": "/x/"}}}`,
+		"parent prefix":        `{"namespaces": {"a": {"prefix": "../x/"}}}`,
+		"unknown default":      `{"default": "b", "namespaces": {"a": {}}}`,
+		"ambiguous default":    `{"namespaces": {"a": {"prefix": "a/"}, "b": {"prefix": "b/"}}}`,
+		"unknown route target": `{"namespaces": {"a": {}}, "routes": [{"email_domain": "x.com", "namespace": "b"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("BACKUP_NAMESPACE", "")
+			t.Setenv("BACKUP_NAMESPACES", raw)
+			if _, err := LoadNamespaceConfig(); err == nil {
+				t.Fatal("LoadNamespaceConfig accepted the config")
+			}
+		})
+	}
+
+	// The same prefix in different buckets does not overlap
+	t.Setenv("BACKUP_NAMESPACES", `{"default": "a", "namespaces": {"a": {"prefix": "x/"}, "b": {"bucket": "other", "prefix": "x/"}}}`)
+	if _, err := LoadNamespaceConfig(); err != nil {
+		t.Fatalf("separate buckets: %v", err)
+	}
+}
+
+func TestNamespacesRouteToBucketAndPrefix(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	s := newTestIntegrationService(store)
+	s.SetNamespaces(newNamespaceTestConfig(t))
+
+	prodUser := &models.User{ID: 1, Name: "Ada", Email: "ada@example.com"}
+	stagingUser := &models.User{ID: 2, Name: "Grace", Email: "grace@test.example.com"}
+	for _, user := range []*models.User{prodUser, stagingUser} {
+		if err := s.BackupUser(ctx, s.RouteNamespace(user), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Production lives under its prefix in the default bucket
+	if _, err := store.Get(ctx, "prod/users/1.json", ""); err != nil {
+		t.Fatalf("production backup: %v", err)
+	}
+	// Staging lives in its own bucket, invisible from the default bucket
+	if _, err := store.Get(ctx, "staging/users/2.json", ""); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("staging backup in default bucket: err = %v", err)
+	}
+	stagingBucket, err := store.ForBucket("users-staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stagingBucket.Get(ctx, "staging/users/2.json", ""); err != nil {
+		t.Fatalf("staging backup: %v", err)
+	}
+
+	for name, want := range map[string][]string{
+		"production": {"prod/users/1.json"},
+		"staging":    {"staging/users/2.json"},
+		"archive":    {},
+	} {
+		ns, err := s.Namespace(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backups, _ := listKeys(t, s, ns, BackupListOptions{})
+		if len(backups) != len(want) || (len(want) > 0 && backups[0] != want[0]) {
+			t.Errorf("ListBackups(%s) = %v, want %v", name, backups, want)
+		}
+	}
+
+	// A user is only restorable from the namespace holding the backup
+	prod, _ := s.Namespace("production")
+	if _, err := s.RestoreUser(ctx, prod, stagingUser.ID); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("RestoreUser from wrong namespace = %v, want ErrObjectNotFound", err)
+	}
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"go-microservice/models"
+	"go-microservice/utils"
+)
+
+// ReconcileReport lists the differences between the users in UserService
+// and their backups in one namespace
+type ReconcileReport struct {
+	Namespace string `json:"namespace"`
+	Scanned   int    `json:"scanned"`
+	InSync    int    `json:"in_sync"`
+	// Missing holds users routed to the namespace that have no backup
+	Missing []int `json:"missing"`
+	// Stale holds users whose backup differs from the live record or
+	// cannot be read
+	Stale []int `json:"stale"`
+	// Orphaned holds backups of users that no longer exist
+	Orphaned []int `json:"orphaned"`
+	// Misrouted holds backups of users the routing policy sends to
+	// another namespace; repair leaves them alone
+	Misrouted []int `json:"misrouted"`
+
+	Repaired bool     `json:"repaired"`
+	Uploaded int      `json:"uploaded,omitempty"`
+	Deleted  int      `json:"deleted,omitempty"`
+	Failed   []string `json:"failed,omitempty"`
+}
+
+// ReconcileBackups compares users with the backups in ns. Users are
+// expected in the namespace the routing policy sends them to; backups in
+// ns of users routed elsewhere are reported as misrouted and not checked.
+// With repair set, missing and stale backups are uploaded again and
+// orphaned ones deleted. All lists are sorted by user ID.
+func (s *IntegrationService) ReconcileBackups(ctx context.Context, ns Namespace, users []*models.User, repair bool) (_ *ReconcileReport, err error) {
+	ctx, span := utils.StartSpan(ctx, "IntegrationService.ReconcileBackups", attribute.String("backup.namespace", ns.Name), attribute.Bool("backup.repair", repair))
+	defer func() { utils.EndSpan(span, err) }()
+
+	store, err := s.storeFor(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	keyring := s.keyring
+	s.mu.RUnlock()
+
+	live := make(map[int]*models.User, len(users))
+	routed := make(map[int]bool)
+	for _, user := range users {
+		live[user.ID] = user
+		if s.RouteNamespace(user).Name == ns.Name {
+			routed[user.ID] = true
+		}
+	}
+	expected := make(map[int]bool, len(routed))
+	for id := range routed {
+		expected[id] = true
+	}
+
+	report := &ReconcileReport{
+		Namespace: ns.Name,
+		Missing:   []int{},
+		Stale:     []int{},
+		Orphaned:  []int{},
+		Misrouted: []int{},
+	}
+
+	_, err = s.ListBackups(ctx, ns, BackupListOptions{}, func(entry BackupEntry) error {
+		report.Scanned++
+		if entry.UserID == 0 {
+			return nil
+		}
+
+		user, exists := live[entry.UserID]
+		if !exists {
+			report.Orphaned = append(report.Orphaned, entry.UserID)
+			return nil
+		}
+		// Uploading it here would put it in the wrong namespace
+		if !routed[entry.UserID] {
+			report.Misrouted = append(report.Misrouted, entry.UserID)
+			return nil
+		}
+		delete(expected, entry.UserID)
+
+		// Unreadable backups count as stale so a repair replaces them
+		var backup *models.User
+		obj, err := store.Get(ctx, entry.Key, "")
+		if errors.Is(err, ErrCircuitOpen) {
+			return fmt.Errorf("failed to read %s: %w", entry.Key, err)
+		}
+		if err == nil {
+			backup, err = decodeBackup(obj, keyring)
+		}
+		if err != nil || !sameUser(backup, user) {
+			report.Stale = append(report.Stale, entry.UserID)
+			return nil
+		}
+		report.InSync++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for id := range expected {
+		report.Missing = append(report.Missing, id)
+	}
+	// Listings are not in numeric order, nor in any order for some stores
+	for _, ids := range [][]int{report.Missing, report.Stale, report.Orphaned, report.Misrouted} {
+		sort.Ints(ids)
+	}
+
+	if !repair {
+		return report, nil
+	}
+
+	report.Repaired = true
+	for _, id := range append(append([]int{}, report.Missing...), report.Stale...) {
+		if err := s.BackupUser(ctx, ns, live[id]); err != nil {
+			slog.ErrorContext(ctx, "Reconcile upload failed", "user_id", id, "error", err)
+			report.Failed = append(report.Failed, ns.ObjectName(id))
+			continue
+		}
+		report.Uploaded++
+	}
+	for _, id := range report.Orphaned {
+		if err := s.DeleteUserBackup(ctx, ns, id); err != nil {
+			slog.ErrorContext(ctx, "Reconcile delete failed", "user_id", id, "error", err)
+			report.Failed = append(report.Failed, ns.ObjectName(id))
+			continue
+		}
+		report.Deleted++
+	}
+
+	return report, nil
+}
+
+// sameUser reports whether a backup matches the live user record
+func sameUser(backup, user *models.User) bool {
+	return backup.ID == user.ID &&
+		backup.Name == user.Name &&
+		backup.Email == user.Email &&
+		backup.CreatedAt.Equal(user.CreatedAt) &&
+		backup.UpdatedAt.Equal(user.UpdatedAt)
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"go-microservice/models"
+)
+
+func TestReconcileBackups(t *testing.T) {
+	ctx := context.Background()
+	s := newTestIntegrationService(NewMemoryStore())
+	s.SetNamespaces(&NamespaceConfig{
+		Default: "prod",
+		Namespaces: map[string]Namespace{
+			"prod":    {Name: "prod"},
+			"staging": {Name: "staging", Prefix: "staging/"},
+		},
+		Routes: []NamespaceRoute{{EmailDomain: "test.example.com", Namespace: "staging"}},
+	})
+	prod, _ := s.Namespace("prod")
+	staging, _ := s.Namespace("staging")
+
+	user := func(id int, email string) *models.User {
+		return &models.User{ID: id, Name: "User", Email: email}
+	}
+	inSync := user(3, "three@example.com")
+	missing := user(7, "seven@example.com")
+	stale9, stale10 := user(9, "nine@example.com"), user(10, "ten@example.com")
+	misrouted := user(5, "five@test.example.com")
+
+	// IDs 9 and 10, and 90 and 100, list in the opposite of numeric order
+	for _, u := range []*models.User{inSync, stale9, stale10, misrouted, user(90, "gone@example.com"), user(100, "gone@example.com")} {
+		if err := s.BackupUser(ctx, prod, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stale9.Name, stale10.Name = "Renamed", "Renamed"
+	users := []*models.User{inSync, missing, stale9, stale10, misrouted}
+
+	report, err := s.ReconcileBackups(ctx, prod, users, true)
+	if err != nil {
+		t.Fatalf("ReconcileBackups: %v", err)
+	}
+	for name, got := range map[string][]int{
+		"missing": report.Missing, "stale": report.Stale, "orphaned": report.Orphaned, "misrouted": report.Misrouted,
+	} {
+		want := map[string][]int{"missing": {7}, "stale": {9, 10}, "orphaned": {90, 100}, "misrouted": {5}}[name]
+		if !slices.Equal(got, want) {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	if report.InSync != 1 || report.Uploaded != 3 || report.Deleted != 2 || len(report.Failed) != 0 {
+		t.Errorf("report = %+v", report)
+	}
+
+	// The misrouted backup is neither rewritten nor copied to its namespace
+	if _, err := s.RestoreUser(ctx, staging, misrouted.ID); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("misrouted user backed up to staging: %v", err)
+	}
+	if _, err := s.RestoreUser(ctx, prod, misrouted.ID); err != nil {
+		t.Errorf("misrouted backup removed from prod: %v", err)
+	}
+
+	report, err = s.ReconcileBackups(ctx, prod, users, false)
+	if err != nil {
+		t.Fatalf("ReconcileBackups: %v", err)
+	}
+	if report.InSync != 4 || len(report.Missing)+len(report.Stale)+len(report.Orphaned) != 0 {
+		t.Errorf("after repair: %+v", report)
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrObjectNotFound is returned by BackupStore.Get for a missing key or version
+var ErrObjectNotFound = errors.New("backup object not found")
+
+// BackupObject is a stored backup payload together with its metadata
+type BackupObject struct {
+	Key             string
+	Data            []byte
+	ContentType     string
+	ContentEncoding string
+	// Metadata holds user metadata in canonical header form, e.g. "Backup-Sha256"
+	Metadata     map[string]string
+	VersionID    string
+	LastModified time.Time
+}
+
+// ObjectInfo describes a stored backup without its payload
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+// BackupStore is a storage target for user backups
+type BackupStore interface {
+	// Name identifies the backend, e.g. "minio" or "filesystem"
+	Name() string
+	// Put stores obj under obj.Key, replacing any existing object
+	Put(ctx context.Context, obj *BackupObject) error
+	// Get loads an object; an empty versionID selects the latest version
+	Get(ctx context.Context, key, versionID string) (*BackupObject, error)
+	// Delete removes an object; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every object whose key starts with prefix,
+	// stopping at the first error returned by fn
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+	// Health reports whether the backend is reachable
+	Health(ctx context.Context) error
+}
+
+// VersionedStore is implemented by stores that keep object history
+type VersionedStore interface {
+	BackupStore
+	// Versioned reports whether history is currently being kept
+	Versioned() bool
+	// ListVersions returns all versions of key, newest first
+	ListVersions(ctx context.Context, key string) ([]BackupVersion, error)
+}
+
+// MetadataReplacer is implemented by stores that can replace object
+// metadata without uploading the payload again
+type MetadataReplacer interface {
+	ReplaceMetadata(ctx context.Context, obj *BackupObject) error
+}
+
+// RangeLister is implemented by stores that can resume a listing after a
+// given key without enumerating the keys before it
+type RangeLister interface {
+	// ListAfter is List restricted to keys greater than startAfter
+	ListAfter(ctx context.Context, prefix, startAfter string, fn func(ObjectInfo) error) error
+}
+
+// BucketScoped is implemented by stores that can address buckets other
+// than their default one
+type BucketScoped interface {
+	// ForBucket returns a store for bucket; an empty name selects the default
+	ForBucket(bucket string) (BackupStore, error)
+}
+
+// Backup store backends selectable through BACKUP_STORE
+const (
+	StoreMinIO      = "minio"
+	StoreFilesystem = "filesystem"
+	StoreMemory     = "memory"
+)
+
+// NewBackupStoreFromEnv creates the non-MinIO backend named by BACKUP_STORE.
+// It returns nil for "minio" (the default), which is connected separately
+// through IntegrationService.Connect.
+func NewBackupStoreFromEnv() (BackupStore, error) {
+	switch backend := os.Getenv("BACKUP_STORE"); backend {
+	case "", StoreMinIO:
+		return nil, nil
+	case StoreFilesystem:
+		dir := os.Getenv("BACKUP_DIR")
+		if dir == "" {
+			dir = "./backups"
+		}
+		return NewFileSystemStore(dir)
+	case StoreMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown backup store %q", backend)
+	}
+}
+
+// copyMetadata returns an independent copy of meta
+func copyMetadata(meta map[string]string) map[string]string {
+	copied := make(map[string]string, len(meta))
+	for key, value := range meta {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go-microservice/metrics"
+)
+
+// BreakerState is the state of a CircuitBreaker
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// ErrCircuitOpen is returned while a circuit breaker rejects calls
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker stops calling a failing dependency after a run of
+// consecutive failures. Once openTimeout has passed a single trial call is
+// let through; its outcome closes or re-opens the breaker. Outcomes of
+// calls allowed before the last state change are ignored, so a slow call
+// from before the breaker opened cannot decide the trial.
+// A nil *CircuitBreaker allows every call.
+type CircuitBreaker struct {
+	name             string
+	failureThreshold int
+	openTimeout      time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	// generation increases on every state change
+	generation uint64
+}
+
+// NewCircuitBreaker creates a closed breaker
+func NewCircuitBreaker(name string, failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	b := &CircuitBreaker{
+		name:             name,
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		state:            BreakerClosed,
+	}
+	metrics.SetCircuitBreakerState(name, string(BreakerClosed))
+	return b
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be
+// followed by Record with the returned generation.
+func (b *CircuitBreaker) Allow() (uint64, error) {
+	if b == nil {
+		return 0, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return 0, ErrCircuitOpen
+		}
+		b.setState(BreakerHalfOpen)
+		b.probing = true
+		return b.generation, nil
+	case BreakerHalfOpen:
+		// Only one trial call at a time
+		if b.probing {
+			return 0, ErrCircuitOpen
+		}
+		b.probing = true
+		return b.generation, nil
+	default:
+		return b.generation, nil
+	}
+}
+
+// Record reports the outcome of a call allowed in generation. Missing
+// objects and cancelled requests say nothing about the dependency's health
+// and count as successes.
+func (b *CircuitBreaker) Record(generation uint64, err error) {
+	if b == nil {
+		return
+	}
+
+	failed := err != nil &&
+		!errors.Is(err, ErrObjectNotFound) &&
+		!errors.Is(err, context.Canceled)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.failureThreshold {
+		b.openedAt = time.Now()
+		if b.state != BreakerOpen {
+			b.setState(BreakerOpen)
+		}
+	}
+}
+
+// State returns the current breaker state
+func (b *CircuitBreaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// setState records a transition; callers hold b.mu
+func (b *CircuitBreaker) setState(state BreakerState) {
+	slog.Info("Circuit breaker state changed", "breaker", b.name, "from", b.state, "to", state)
+	b.state = state
+	b.generation++
+	metrics.SetCircuitBreakerState(b.name, string(state))
+}
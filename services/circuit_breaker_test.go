@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var errUnavailable = errors.New("connection refused")
+
+// failCalls records n failed calls on b
+func failCalls(t *testing.T, b *CircuitBreaker, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		generation, err := b.Allow()
+		if err != nil {
+			t.Fatalf("call %d rejected: %v", i+1, err)
+		}
+		b.Record(generation, errUnavailable)
+	}
+}
+
+func TestCircuitBreakerOpensAtThreshold(t *testing.T) {
+	b := NewCircuitBreaker("test", 3, time.Hour)
+
+	failCalls(t, b, 2)
+	if b.State() != BreakerClosed {
+		t.Fatalf("state after 2 failures = %s, want closed", b.State())
+	}
+
+	// A success resets the run of failures
+	generation, _ := b.Allow()
+	b.Record(generation, nil)
+	failCalls(t, b, 2)
+	if b.State() != BreakerClosed {
+		t.Fatalf("state after reset and 2 failures = %s, want closed", b.State())
+	}
+
+	failCalls(t, b, 1)
+	if b.State() != BreakerOpen {
+		t.Fatalf("state after 3 failures = %s, want open", b.State())
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow while open = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestCircuitBreakerIgnoresNonFailures(t *testing.T) {
+	b := NewCircuitBreaker("test", 1, time.Hour)
+
+	for _, err := range []error{
+		nil,
+		ErrObjectNotFound,
+		fmt.Errorf("get users/1.json: %w", ErrObjectNotFound),
+		context.Canceled,
+	} {
+		generation, allowErr := b.Allow()
+		if allowErr != nil {
+			t.Fatal(allowErr)
+		}
+		b.Record(generation, err)
+		if b.State() != BreakerClosed {
+			t.Fatalf("Record(%v) opened the breaker", err)
+		}
+	}
+}
+
+func TestCircuitBreakerSingleHalfOpenProbe(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		probeErr error
+		want     BreakerState
+	}{
+		{"probe succeeds", nil, BreakerClosed},
+		{"probe fails", errUnavailable, BreakerOpen},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := NewCircuitBreaker("test", 1, time.Millisecond)
+			failCalls(t, b, 1)
+			time.Sleep(2 * time.Millisecond)
+
+			probe, err := b.Allow()
+			if err != nil {
+				t.Fatalf("probe rejected: %v", err)
+			}
+			if b.State() != BreakerHalfOpen {
+				t.Fatalf("state = %s, want half-open", b.State())
+			}
+			if _, err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("second call during probe = %v, want ErrCircuitOpen", err)
+			}
+
+			b.Record(probe, tc.probeErr)
+			if b.State() != tc.want {
+				t.Fatalf("state after probe = %s, want %s", b.State(), tc.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerIgnoresLateResults(t *testing.T) {
+	b := NewCircuitBreaker("test", 1, time.Millisecond)
+
+	// A slow call allowed while closed, still running when the breaker opens
+	slow, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	failCalls(t, b, 1)
+	time.Sleep(2 * time.Millisecond)
+
+	probe, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The slow call's result neither decides the probe nor frees its slot
+	b.Record(slow, nil)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("state after late success = %s, want half-open", b.State())
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow after late result = %v, want ErrCircuitOpen", err)
+	}
+
+	b.Record(probe, errUnavailable)
+	if b.State() != BreakerOpen {
+		t.Fatalf("state after failed probe = %s, want open", b.State())
+	}
+}
+
+func TestNilCircuitBreakerAllowsCalls(t *testing.T) {
+	var b *CircuitBreaker
+	generation, err := b.Allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Record(generation, errUnavailable)
+	if b.State() != BreakerClosed {
+		t.Fatalf("nil breaker state = %s", b.State())
+	}
+}
+
+func TestBackoffDelayJitterBounds(t *testing.T) {
+	for _, tc := range []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{1, 500 * time.Millisecond},
+		{2, time.Second},
+		{4, 4 * time.Second},
+		{6, 16 * time.Second},
+		{7, 30 * time.Second},
+		{16, 30 * time.Second},
+		{1000, 30 * time.Second},
+	} {
+		for i := 0; i < 100; i++ {
+			delay := backoffDelay(tc.attempt)
+			if delay < tc.ceiling/2 || delay > tc.ceiling {
+				t.Fatalf("backoffDelay(%d) = %s, want within [%s, %s]", tc.attempt, delay, tc.ceiling/2, tc.ceiling)
+			}
+		}
+	}
+}
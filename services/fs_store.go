@@ -0,0 +1,324 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// metaFileSuffix marks the sidecar file that held an object's metadata
+// before it moved into the object file's header
+const metaFileSuffix = ".meta.json"
+
+// objectFileMagic starts every object file written with a metadata header
+const objectFileMagic = "\x00BKOBJ1\n"
+
+// objectHeaderPrefix is the length of the magic and the header length
+const objectHeaderPrefix = len(objectFileMagic) + 4
+
+// FileSystemStore is a BackupStore that keeps objects as files under a
+// root directory. Each file holds the object's metadata as a JSON header
+// followed by the payload, so both are replaced by a single rename. Files
+// without a header, from older versions, are read with their sidecar.
+type FileSystemStore struct {
+	root string
+}
+
+// fileMetadata is the on-disk form of an object's metadata
+type fileMetadata struct {
+	ContentType     string            `json:"content_type"`
+	ContentEncoding string            `json:"content_encoding,omitempty"`
+	Metadata        map[string]string `json:"metadata"`
+}
+
+// NewFileSystemStore creates a store rooted at dir, creating it if needed
+func NewFileSystemStore(dir string) (*FileSystemStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	return &FileSystemStore{root: dir}, nil
+}
+
+// Name returns the backend name
+func (f *FileSystemStore) Name() string {
+	return StoreFilesystem
+}
+
+// ForBucket returns a store for bucket kept under <root>/_buckets/<bucket>
+func (f *FileSystemStore) ForBucket(bucket string) (BackupStore, error) {
+	if bucket == "" {
+		return f, nil
+	}
+	if strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return nil, fmt.Errorf("invalid bucket name: %s", bucket)
+	}
+	return NewFileSystemStore(filepath.Join(f.root, "_buckets", bucket))
+}
+
+// path maps an object key to a file path, rejecting keys that escape root
+func (f *FileSystemStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || clean[1:] != key || strings.HasSuffix(key, metaFileSuffix) {
+		return "", fmt.Errorf("invalid object key: %s", key)
+	}
+	return filepath.Join(f.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the header and payload via a rename, so readers and a crash
+// never leave a payload paired with another write's metadata
+func (f *FileSystemStore) Put(ctx context.Context, obj *BackupObject) error {
+	file, err := f.path(obj.Key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o750); err != nil {
+		return err
+	}
+
+	header, err := json.Marshal(fileMetadata{
+		ContentType:     obj.ContentType,
+		ContentEncoding: obj.ContentEncoding,
+		Metadata:        obj.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, objectHeaderPrefix+len(header)+len(obj.Data)))
+	buf.WriteString(objectFileMagic)
+	binary.Write(buf, binary.BigEndian, uint32(len(header)))
+	buf.Write(header)
+	buf.Write(obj.Data)
+	if err := writeFileAtomic(file, buf.Bytes()); err != nil {
+		return err
+	}
+
+	// The header supersedes a sidecar left by an older version
+	if err := os.Remove(file + metaFileSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// splitObjectFile separates the header of an object file from its payload.
+// It reports false for files written without a header.
+func splitObjectFile(raw []byte) (fileMetadata, []byte, bool, error) {
+	var meta fileMetadata
+	if len(raw) < objectHeaderPrefix || string(raw[:len(objectFileMagic)]) != objectFileMagic {
+		return meta, raw, false, nil
+	}
+	size := int(binary.BigEndian.Uint32(raw[len(objectFileMagic):objectHeaderPrefix]))
+	if size > len(raw)-objectHeaderPrefix {
+		return meta, nil, true, errors.New("truncated header")
+	}
+	if err := json.Unmarshal(raw[objectHeaderPrefix:objectHeaderPrefix+size], &meta); err != nil {
+		return meta, nil, true, err
+	}
+	return meta, raw[objectHeaderPrefix+size:], true, nil
+}
+
+// payloadSize returns the payload size of the object file at file, whose
+// total size is size
+func payloadSize(file string, size int64) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	prefix := make([]byte, objectHeaderPrefix)
+	if _, err := io.ReadFull(f, prefix); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return size, nil
+		}
+		return 0, err
+	}
+	if string(prefix[:len(objectFileMagic)]) != objectFileMagic {
+		return size, nil
+	}
+	return size - int64(objectHeaderPrefix) - int64(binary.BigEndian.Uint32(prefix[len(objectFileMagic):])), nil
+}
+
+// Get reads an object; the filesystem store keeps no history
+func (f *FileSystemStore) Get(ctx context.Context, key, versionID string) (*BackupObject, error) {
+	if versionID != "" {
+		return nil, fmt.Errorf("%w: filesystem store does not keep versions", ErrObjectNotFound)
+	}
+
+	file, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, data, ok, err := splitObjectFile(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata for %s: %w", key, err)
+	}
+	if !ok {
+		if meta, err = readSidecar(file); err != nil {
+			return nil, fmt.Errorf("invalid metadata for %s: %w", key, err)
+		}
+	}
+
+	obj := &BackupObject{
+		Key:             key,
+		Data:            data,
+		ContentType:     meta.ContentType,
+		ContentEncoding: meta.ContentEncoding,
+		Metadata:        meta.Metadata,
+		LastModified:    stat.ModTime().UTC(),
+	}
+	if obj.Metadata == nil {
+		obj.Metadata = map[string]string{}
+	}
+	return obj, nil
+}
+
+// readSidecar reads the metadata of a file written without a header. A
+// missing sidecar leaves the object readable as plain data.
+func readSidecar(file string) (fileMetadata, error) {
+	var meta fileMetadata
+	raw, err := os.ReadFile(file + metaFileSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(raw, &meta)
+	return meta, err
+}
+
+// Delete removes an object and any sidecar
+func (f *FileSystemStore) Delete(ctx context.Context, key string) error {
+	file, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{file, file + metaFileSuffix} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// List walks the directory tree below prefix in lexical order
+func (f *FileSystemStore) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	return f.ListAfter(ctx, prefix, "", fn)
+}
+
+// ListAfter lists objects under prefix with keys after startAfter. Keys are
+// visited in walk order, which matches key order for flat prefixes such as
+// users/; directories entirely before startAfter are skipped.
+func (f *FileSystemStore) ListAfter(ctx context.Context, prefix, startAfter string, fn func(ObjectInfo) error) error {
+	// Start from the deepest directory contained in prefix
+	start := f.root
+	if dir := path.Dir(prefix); dir != "." && dir != "/" {
+		start = filepath.Join(f.root, filepath.FromSlash(dir))
+	}
+
+	err := filepath.WalkDir(start, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(f.root, file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			// Every key below dir starts with dir + "/"
+			if file != start && startAfter != "" && key+"/" < startAfter && !strings.HasPrefix(startAfter, key+"/") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(file, metaFileSuffix) || strings.Contains(d.Name(), ".tmp-") {
+			return nil
+		}
+		if !strings.HasPrefix(key, prefix) || key <= startAfter {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size, err := payloadSize(file, info.Size())
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return fn(ObjectInfo{
+			Key:          key,
+			Size:         size,
+			LastModified: info.ModTime().UTC(),
+			ETag:         fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+		})
+	})
+	return err
+}
+
+// Health checks that the root directory is writable
+func (f *FileSystemStore) Health(ctx context.Context) error {
+	probe, err := os.CreateTemp(f.root, ".health-*")
+	if err != nil {
+		return fmt.Errorf("backup directory not writable: %w", err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+func writeFileAtomic(file string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
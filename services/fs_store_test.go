@@ -0,0 +1,117 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSystemStorePutGet(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewFileSystemStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj := &BackupObject{
+		Key:             "users/user_1.json",
+		Data:            []byte(`{"id":1}`),
+		ContentType:     "application/json",
+		ContentEncoding: "gzip",
+		Metadata:        map[string]string{"Backup-Sha256": "abc"},
+	}
+	if err := store.Put(ctx, obj); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// Header and payload share one file, with no sidecar beside it
+	if _, err := os.Stat(filepath.Join(dir, "users", "user_1.json"+metaFileSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("sidecar written: %v", err)
+	}
+
+	got, err := store.Get(ctx, obj.Key, "")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got.Data, obj.Data) {
+		t.Errorf("Data = %q, want %q", got.Data, obj.Data)
+	}
+	if got.ContentType != obj.ContentType || got.ContentEncoding != obj.ContentEncoding {
+		t.Errorf("content type/encoding = %q/%q", got.ContentType, got.ContentEncoding)
+	}
+	if got.Metadata["Backup-Sha256"] != "abc" {
+		t.Errorf("Metadata = %v", got.Metadata)
+	}
+
+	var sizes []int64
+	err = store.List(ctx, "users/", func(info ObjectInfo) error {
+		sizes = append(sizes, info.Size)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(sizes) != 1 || sizes[0] != int64(len(obj.Data)) {
+		t.Errorf("listed sizes = %v, want [%d]", sizes, len(obj.Data))
+	}
+}
+
+func TestFileSystemStoreReadsLegacySidecar(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewFileSystemStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "user_2.json")
+	if err := os.WriteFile(file, []byte(`{"id":2}`), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	sidecar := `{"content_type":"application/json","metadata":{"Backup-Version":"1"}}`
+	if err := os.WriteFile(file+metaFileSuffix, []byte(sidecar), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Get(ctx, "user_2.json", "")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(got.Data) != `{"id":2}` || got.ContentType != "application/json" || got.Metadata["Backup-Version"] != "1" {
+		t.Fatalf("legacy object = %q %q %v", got.Data, got.ContentType, got.Metadata)
+	}
+
+	// Overwriting drops the sidecar, whose metadata would no longer apply
+	if err := store.Put(ctx, &BackupObject{Key: "user_2.json", Data: []byte(`{"id":2,"v":2}`)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := os.Stat(file + metaFileSuffix); !os.IsNotExist(err) {
+		t.Fatalf("legacy sidecar kept: %v", err)
+	}
+	got, err = store.Get(ctx, "user_2.json", "")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.ContentType != "" || len(got.Metadata) != 0 {
+		t.Errorf("stale metadata returned: %q %v", got.ContentType, got.Metadata)
+	}
+}
+
+func TestFileSystemStoreRejectsTruncatedHeader(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileSystemStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := objectFileMagic + "\x00\x00\x01\x00{"
+	if err := os.WriteFile(filepath.Join(dir, "user_3.json"), []byte(raw), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(context.Background(), "user_3.json", ""); err == nil || !strings.Contains(err.Error(), "invalid metadata") {
+		t.Fatalf("Get = %v, want invalid metadata error", err)
+	}
+}
@@ -1,28 +1,78 @@
 package services
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+	"go.opentelemetry.io/otel/attribute"
 
+	"go-microservice/metrics"
 	"go-microservice/models"
+	"go-microservice/utils"
 )
 
-// IntegrationService handles S3-compatible storage operations via MinIO
+// IntegrationService handles user backups on a pluggable BackupStore,
+// MinIO by default
 type IntegrationService struct {
-	client     *minio.Client
-	bucketName string
-	mu         sync.RWMutex
-	connected  bool
+	store       BackupStore
+	mu          sync.RWMutex
+	keyring     *BackupKeyring
+	compression Compression
+	namespaces  *NamespaceConfig
+
+	// MinIO connection supervision
+	config         MinIOConfig
+	connState      ConnectionState
+	breaker        *CircuitBreaker
+	stopSupervisor context.CancelFunc
+	supervisorDone chan struct{}
+}
+
+// BackupCheck reports the integrity of a single backup object
+type BackupCheck struct {
+	Object string `json:"object"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Backup integrity statuses reported by VerifyBackups
+const (
+	BackupOK         = "ok"
+	BackupCorrupt    = "corrupt"
+	BackupUnreadable = "unreadable"
+	BackupUnverified = "unverified"
+)
+
+// VerifyReport summarizes a VerifyBackups scan
+type VerifyReport struct {
+	Scanned    int           `json:"scanned"`
+	OK         int           `json:"ok"`
+	Unverified int           `json:"unverified"`
+	Problems   []BackupCheck `json:"problems"`
+}
+
+// KeyRotationReport summarizes a RotateBackupKeys run
+type KeyRotationReport struct {
+	ActiveKeyID string   `json:"active_key_id"`
+	Scanned     int      `json:"scanned"`
+	Rewrapped   int      `json:"rewrapped"`
+	Unencrypted int      `json:"unencrypted"`
+	Failed      []string `json:"failed,omitempty"`
+}
+
+// BackupVersion describes one historical version of a user backup
+type BackupVersion struct {
+	VersionID      string    `json:"version_id"`
+	LastModified   time.Time `json:"last_modified"`
+	Size           int64     `json:"size"`
+	IsLatest       bool      `json:"is_latest"`
+	IsDeleteMarker bool      `json:"is_delete_marker"`
 }
 
 var (
@@ -30,13 +80,28 @@ var (
 	integrationOnce     sync.Once
 )
 
-// MinIOConfig holds configuration for MinIO connection
+// MinIOConfig holds configuration for MinIO connection.
+// Use String to log it; the secret key is never marshalled or printed.
 type MinIOConfig struct {
 	Endpoint        string
 	AccessKeyID     string
-	SecretAccessKey string
+	SecretAccessKey string `json:"-"`
 	BucketName      string
 	UseSSL          bool
+
+	// TLS options, paths to PEM files; used when UseSSL is set
+	CAFile         string
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// CredentialChain lists credential providers tried in order:
+	// static, env, file, aws, iam
+	CredentialChain    []string
+	AccessKeyFile      string
+	SecretKeyFile      string
+	AWSCredentialsFile string
+	AWSProfile         string
+	IAMEndpoint        string
 }
 
 // GetDefaultConfig returns default MinIO configuration from environment
@@ -63,12 +128,29 @@ func GetDefaultConfig() MinIOConfig {
 
 	useSSL := os.Getenv("MINIO_USE_SSL") == "true"
 
+	chain := []string{CredentialsStatic}
+	if value := os.Getenv("MINIO_CREDENTIAL_CHAIN"); value != "" {
+		chain = strings.Split(value, ",")
+		for i := range chain {
+			chain[i] = strings.TrimSpace(chain[i])
+		}
+	}
+
 	return MinIOConfig{
-		Endpoint:        endpoint,
-		AccessKeyID:     accessKey,
-		SecretAccessKey: secretKey,
-		BucketName:      bucket,
-		UseSSL:          useSSL,
+		Endpoint:           endpoint,
+		AccessKeyID:        accessKey,
+		SecretAccessKey:    secretKey,
+		BucketName:         bucket,
+		UseSSL:             useSSL,
+		CAFile:             os.Getenv("MINIO_CA_FILE"),
+		ClientCertFile:     os.Getenv("MINIO_CLIENT_CERT_FILE"),
+		ClientKeyFile:      os.Getenv("MINIO_CLIENT_KEY_FILE"),
+		CredentialChain:    chain,
+		AccessKeyFile:      os.Getenv("MINIO_ACCESS_KEY_FILE"),
+		SecretKeyFile:      os.Getenv("MINIO_SECRET_KEY_FILE"),
+		AWSCredentialsFile: os.Getenv("AWS_SHARED_CREDENTIALS_FILE"),
+		AWSProfile:         os.Getenv("AWS_PROFILE"),
+		IAMEndpoint:        os.Getenv("MINIO_IAM_ENDPOINT"),
 	}
 }
 
@@ -76,192 +158,425 @@ func GetDefaultConfig() MinIOConfig {
 func GetIntegrationService() *IntegrationService {
 	integrationOnce.Do(func() {
 		integrationInstance = &IntegrationService{
-			connected: false,
+			compression: CompressionNone,
+			namespaces:  DefaultNamespaceConfig(),
+			connState:   StateDisconnected,
+			breaker:     NewCircuitBreaker("minio", 5, 30*time.Second),
 		}
+		metrics.SetMinIOConnectionState(string(StateDisconnected))
 	})
 	return integrationInstance
 }
 
-// Connect initializes the MinIO client
+// Connect initializes the MinIO client and makes MinIO the backup store.
+// The config is remembered so a running supervisor reconnects with it.
 func (s *IntegrationService) Connect(config MinIOConfig) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.config = config
+	s.mu.Unlock()
 
-	client, err := minio.New(config.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(config.AccessKeyID, config.SecretAccessKey, ""),
-		Secure: config.UseSSL,
-	})
+	store, err := NewMinIOStore(config, s.breaker)
 	if err != nil {
-		return fmt.Errorf("failed to create MinIO client: %w", err)
+		return err
 	}
 
-	s.client = client
-	s.bucketName = config.BucketName
-	s.connected = true
+	s.UseStore(store)
+	s.setConnectionState(StateConnected)
+	return nil
+}
 
-	// Create bucket if it doesn't exist
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// UseStore makes store the target for all backup operations
+func (s *IntegrationService) UseStore(store BackupStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = store
+}
 
-	exists, err := client.BucketExists(ctx, config.BucketName)
-	if err != nil {
-		log.Printf("Warning: failed to check bucket existence: %v", err)
-		return nil // Don't fail connection, bucket might not be accessible yet
-	}
+// IsConnected returns whether a backup store is configured
+func (s *IntegrationService) IsConnected() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store != nil
+}
 
-	if !exists {
-		err = client.MakeBucket(ctx, config.BucketName, minio.MakeBucketOptions{})
-		if err != nil {
-			log.Printf("Warning: failed to create bucket: %v", err)
-		} else {
-			log.Printf("Created bucket: %s", config.BucketName)
-		}
+// StoreName returns the name of the configured backup store, or "" if none
+func (s *IntegrationService) StoreName() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.store == nil {
+		return ""
 	}
+	return s.store.Name()
+}
 
-	log.Printf("Connected to MinIO at %s", config.Endpoint)
-	return nil
+// SetKeyring enables envelope encryption of backups with the given keyring.
+// A nil keyring disables encryption for new backups.
+func (s *IntegrationService) SetKeyring(keyring *BackupKeyring) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keyring = keyring
 }
 
-// IsConnected returns whether the service is connected to MinIO
-func (s *IntegrationService) IsConnected() bool {
+// SetCompression selects the compression applied to new backups
+func (s *IntegrationService) SetCompression(compression Compression) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compression = compression
+}
+
+// SetNamespaces replaces the backup namespace configuration
+func (s *IntegrationService) SetNamespaces(config *NamespaceConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.namespaces = config
+}
+
+// Namespace returns a configured namespace; an empty name selects the default
+func (s *IntegrationService) Namespace(name string) (Namespace, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.namespaces.Get(name)
+}
+
+// RouteNamespace returns the namespace a user's backup belongs in
+func (s *IntegrationService) RouteNamespace(user *models.User) Namespace {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.connected
+	return s.namespaces.Route(user)
 }
 
-// BackupUser stores user data in MinIO
-func (s *IntegrationService) BackupUser(ctx context.Context, user *models.User) error {
+// allNamespaces returns every configured namespace in name order
+func (s *IntegrationService) allNamespaces() []Namespace {
 	s.mu.RLock()
-	if !s.connected || s.client == nil {
-		s.mu.RUnlock()
-		return fmt.Errorf("MinIO client not connected")
+	defer s.mu.RUnlock()
+
+	namespaces := make([]Namespace, 0, len(s.namespaces.Namespaces))
+	for _, name := range s.namespaces.Names() {
+		namespaces = append(namespaces, s.namespaces.Namespaces[name])
 	}
-	client := s.client
-	bucket := s.bucketName
-	s.mu.RUnlock()
+	return namespaces
+}
 
-	// Serialize user to JSON
-	data, err := json.Marshal(user)
+// IsVersioned returns whether the namespace's store keeps object history
+func (s *IntegrationService) IsVersioned(ns Namespace) bool {
+	store, err := s.storeFor(ns)
 	if err != nil {
-		return fmt.Errorf("failed to marshal user: %w", err)
+		return false
 	}
+	versioned, ok := store.(VersionedStore)
+	return ok && versioned.Versioned()
+}
 
-	objectName := fmt.Sprintf("users/%d.json", user.ID)
-	reader := bytes.NewReader(data)
+// getStore returns the configured store or an error if there is none
+func (s *IntegrationService) getStore() (BackupStore, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.store == nil {
+		return nil, fmt.Errorf("backup store not connected")
+	}
+	return s.store, nil
+}
 
-	_, err = client.PutObject(ctx, bucket, objectName, reader, int64(len(data)), minio.PutObjectOptions{
-		ContentType: "application/json",
-	})
-	if err != nil {
-		return fmt.Errorf("failed to upload user backup: %w", err)
+// storeFor returns the store holding a namespace, switching to the
+// namespace's bucket when it names one
+func (s *IntegrationService) storeFor(ns Namespace) (BackupStore, error) {
+	store, err := s.getStore()
+	if err != nil || ns.Bucket == "" {
+		return store, err
 	}
 
-	log.Printf("User %d backed up to MinIO", user.ID)
-	return nil
+	scoped, ok := store.(BucketScoped)
+	if !ok {
+		return nil, fmt.Errorf("%s store does not support buckets (namespace %s)", store.Name(), ns.Name)
+	}
+	return scoped.ForBucket(ns.Bucket)
 }
 
-// RestoreUser retrieves user data from MinIO
-func (s *IntegrationService) RestoreUser(ctx context.Context, userID int) (*models.User, error) {
-	s.mu.RLock()
-	if !s.connected || s.client == nil {
-		s.mu.RUnlock()
-		return nil, fmt.Errorf("MinIO client not connected")
+// BackupUser stores user data in the given namespace, recording the
+// request ID of ctx in the object metadata
+func (s *IntegrationService) BackupUser(ctx context.Context, ns Namespace, user *models.User) (err error) {
+	ctx, span := utils.StartSpan(ctx, "IntegrationService.BackupUser", attribute.String("backup.namespace", ns.Name), attribute.Int("user.id", user.ID))
+	defer func() { utils.EndSpan(span, err) }()
+
+	store, err := s.storeFor(ns)
+	if err != nil {
+		return err
 	}
-	client := s.client
-	bucket := s.bucketName
+
+	s.mu.RLock()
+	keyring := s.keyring
+	compression := s.compression
 	s.mu.RUnlock()
 
-	objectName := fmt.Sprintf("users/%d.json", userID)
+	objectName := ns.ObjectName(user.ID)
 
-	obj, err := client.GetObject(ctx, bucket, objectName, minio.GetObjectOptions{})
+	obj, err := encodeBackup(user, objectName, compression, keyring)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user backup: %w", err)
+		return err
 	}
-	defer obj.Close()
-
-	data, err := io.ReadAll(obj)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read user backup: %w", err)
+	if requestID := utils.RequestIDFromContext(ctx); requestID != "" {
+		obj.Metadata[metaRequestID] = requestID
 	}
 
-	var user models.User
-	if err := json.Unmarshal(data, &user); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+	if err := store.Put(ctx, obj); err != nil {
+		return fmt.Errorf("failed to upload user backup: %w", err)
 	}
 
-	return &user, nil
+	slog.InfoContext(ctx, "User backed up", "user_id", user.ID, "store", store.Name(), "namespace", ns.Name)
+	return nil
 }
 
-// DeleteUserBackup removes user backup from MinIO
-func (s *IntegrationService) DeleteUserBackup(ctx context.Context, userID int) error {
-	s.mu.RLock()
-	if !s.connected || s.client == nil {
-		s.mu.RUnlock()
-		return fmt.Errorf("MinIO client not connected")
+// RestoreUser retrieves the latest user data from a namespace
+func (s *IntegrationService) RestoreUser(ctx context.Context, ns Namespace, userID int) (*models.User, error) {
+	return s.RestoreUserVersion(ctx, ns, userID, "")
+}
+
+// RestoreUserVersion retrieves a specific version of user data from a
+// namespace. An empty versionID selects the latest version.
+func (s *IntegrationService) RestoreUserVersion(ctx context.Context, ns Namespace, userID int, versionID string) (_ *models.User, err error) {
+	ctx, span := utils.StartSpan(ctx, "IntegrationService.RestoreUserVersion", attribute.String("backup.namespace", ns.Name), attribute.Int("user.id", userID))
+	defer func() { utils.EndSpan(span, err) }()
+
+	store, err := s.storeFor(ns)
+	if err != nil {
+		return nil, err
 	}
-	client := s.client
-	bucket := s.bucketName
+
+	s.mu.RLock()
+	keyring := s.keyring
 	s.mu.RUnlock()
 
-	objectName := fmt.Sprintf("users/%d.json", userID)
+	objectName := ns.ObjectName(userID)
+
+	obj, err := store.Get(ctx, objectName, versionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user backup: %w", err)
+	}
+
+	return decodeBackup(obj, keyring)
+}
+
+// DeleteUserBackup removes user backup from a namespace
+func (s *IntegrationService) DeleteUserBackup(ctx context.Context, ns Namespace, userID int) (err error) {
+	ctx, span := utils.StartSpan(ctx, "IntegrationService.DeleteUserBackup", attribute.String("backup.namespace", ns.Name), attribute.Int("user.id", userID))
+	defer func() { utils.EndSpan(span, err) }()
 
-	err := client.RemoveObject(ctx, bucket, objectName, minio.RemoveObjectOptions{})
+	store, err := s.storeFor(ns)
 	if err != nil {
+		return err
+	}
+
+	objectName := ns.ObjectName(userID)
+
+	if err := store.Delete(ctx, objectName); err != nil {
 		return fmt.Errorf("failed to delete user backup: %w", err)
 	}
 
-	log.Printf("User %d backup deleted from MinIO", userID)
+	slog.InfoContext(ctx, "User backup deleted", "user_id", userID, "store", store.Name(), "namespace", ns.Name)
 	return nil
 }
 
-// BackupAllUsers backs up all users to MinIO
-func (s *IntegrationService) BackupAllUsers(ctx context.Context, users []*models.User) error {
+// BackupAllUsers backs up all users into ns, or into the namespace chosen
+// by the routing policy for each user when ns is nil
+func (s *IntegrationService) BackupAllUsers(ctx context.Context, ns *Namespace, users []*models.User) (err error) {
+	ctx, span := utils.StartSpan(ctx, "IntegrationService.BackupAllUsers", attribute.Int("backup.users", len(users)))
+	defer func() { utils.EndSpan(span, err) }()
+
 	for _, user := range users {
-		if err := s.BackupUser(ctx, user); err != nil {
+		target := s.RouteNamespace(user)
+		if ns != nil {
+			target = *ns
+		}
+		if err := s.BackupUser(ctx, target, user); err != nil {
 			return fmt.Errorf("failed to backup user %d: %w", user.ID, err)
 		}
 	}
 	return nil
 }
 
-// ListBackups returns a list of all user backup object names
-func (s *IntegrationService) ListBackups(ctx context.Context) ([]string, error) {
+// RotateBackupKeys re-wraps the data key of every encrypted backup, in all
+// namespaces, whose master key is not the active one. Payloads are not
+// re-encrypted; stores implementing MetadataReplacer (MinIO) only replace
+// object metadata, the others rewrite the same payload. With versioning
+// enabled, older versions keep their original wrapping, so retired master
+// keys must stay in the keyring to restore them.
+func (s *IntegrationService) RotateBackupKeys(ctx context.Context) (_ *KeyRotationReport, err error) {
+	ctx, span := utils.StartSpan(ctx, "IntegrationService.RotateBackupKeys")
+	defer func() { utils.EndSpan(span, err) }()
+
+	if _, err := s.getStore(); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
-	if !s.connected || s.client == nil {
-		s.mu.RUnlock()
-		return nil, fmt.Errorf("MinIO client not connected")
+	keyring := s.keyring
+	s.mu.RUnlock()
+
+	if keyring == nil {
+		return nil, fmt.Errorf("no backup keyring configured")
 	}
-	client := s.client
-	bucket := s.bucketName
+
+	report := &KeyRotationReport{ActiveKeyID: keyring.ActiveKeyID()}
+	for _, ns := range s.allNamespaces() {
+		if err := s.rotateNamespaceKeys(ctx, ns, keyring, report); err != nil {
+			return report, fmt.Errorf("namespace %s: %w", ns.Name, err)
+		}
+	}
+
+	return report, nil
+}
+
+// rotateNamespaceKeys re-wraps the backups of one namespace into report
+func (s *IntegrationService) rotateNamespaceKeys(ctx context.Context, ns Namespace, keyring *BackupKeyring, report *KeyRotationReport) error {
+	store, err := s.storeFor(ns)
+	if err != nil {
+		return err
+	}
+
+	return store.List(ctx, ns.UsersPrefix(), func(info ObjectInfo) error {
+		report.Scanned++
+
+		obj, err := store.Get(ctx, info.Key, "")
+		if err != nil {
+			slog.ErrorContext(ctx, "Key rotation failed", "key", info.Key, "error", err)
+			report.Failed = append(report.Failed, info.Key)
+			return nil
+		}
+		if !isEncrypted(obj.Metadata) {
+			report.Unencrypted++
+			return nil
+		}
+
+		meta, changed, err := keyring.Rewrap(obj.Metadata)
+		if err != nil {
+			slog.ErrorContext(ctx, "Key rotation failed", "key", info.Key, "error", err)
+			report.Failed = append(report.Failed, info.Key)
+			return nil
+		}
+		if !changed {
+			return nil
+		}
+
+		obj.Metadata = meta
+		if replacer, ok := store.(MetadataReplacer); ok {
+			err = replacer.ReplaceMetadata(ctx, obj)
+		} else {
+			err = store.Put(ctx, obj)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Key rotation failed", "key", info.Key, "error", err)
+			report.Failed = append(report.Failed, info.Key)
+			return nil
+		}
+		report.Rewrapped++
+		return nil
+	})
+}
+
+// VerifyBackups downloads every user backup in a namespace, checks it
+// against its stored checksum and confirms it decodes into a user
+func (s *IntegrationService) VerifyBackups(ctx context.Context, ns Namespace) (_ *VerifyReport, err error) {
+	ctx, span := utils.StartSpan(ctx, "IntegrationService.VerifyBackups", attribute.String("backup.namespace", ns.Name))
+	defer func() { utils.EndSpan(span, err) }()
+
+	store, err := s.storeFor(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	keyring := s.keyring
 	s.mu.RUnlock()
 
-	var backups []string
-	objectCh := client.ListObjects(ctx, bucket, minio.ListObjectsOptions{
-		Prefix:    "users/",
-		Recursive: true,
+	report := &VerifyReport{Problems: []BackupCheck{}}
+	err = store.List(ctx, ns.UsersPrefix(), func(info ObjectInfo) error {
+		report.Scanned++
+
+		check := verifyObject(ctx, store, info.Key, keyring)
+		switch check.Status {
+		case BackupOK:
+			report.OK++
+		case BackupUnverified:
+			report.Unverified++
+		default:
+			report.Problems = append(report.Problems, check)
+		}
+		return nil
 	})
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// verifyObject checks a single backup object
+func verifyObject(ctx context.Context, store BackupStore, objectName string, keyring *BackupKeyring) BackupCheck {
+	check := BackupCheck{Object: objectName}
+
+	obj, err := store.Get(ctx, objectName, "")
+	if err != nil {
+		check.Status = BackupUnreadable
+		check.Error = err.Error()
+		return check
+	}
 
-	for object := range objectCh {
-		if object.Err != nil {
-			return nil, fmt.Errorf("error listing objects: %w", object.Err)
+	if _, err := decodeBackup(obj, keyring); err != nil {
+		check.Status = BackupUnreadable
+		if errors.Is(err, ErrChecksumMismatch) {
+			check.Status = BackupCorrupt
 		}
-		backups = append(backups, object.Key)
+		check.Error = err.Error()
+		return check
 	}
 
-	return backups, nil
+	check.Status = BackupOK
+	if _, ok := obj.Metadata[metaChecksum]; !ok {
+		check.Status = BackupUnverified
+	}
+	return check
 }
 
-// HealthCheck checks MinIO connectivity
-func (s *IntegrationService) HealthCheck(ctx context.Context) error {
-	s.mu.RLock()
-	if !s.connected || s.client == nil {
-		s.mu.RUnlock()
-		return fmt.Errorf("MinIO client not connected")
+// ListUserVersions returns all stored versions of a user backup in a
+// namespace, newest first. Stores without history report only the current object.
+func (s *IntegrationService) ListUserVersions(ctx context.Context, ns Namespace, userID int) (_ []BackupVersion, err error) {
+	ctx, span := utils.StartSpan(ctx, "IntegrationService.ListUserVersions", attribute.String("backup.namespace", ns.Name), attribute.Int("user.id", userID))
+	defer func() { utils.EndSpan(span, err) }()
+
+	store, err := s.storeFor(ns)
+	if err != nil {
+		return nil, err
 	}
-	client := s.client
-	bucket := s.bucketName
-	s.mu.RUnlock()
 
-	_, err := client.BucketExists(ctx, bucket)
-	return err
+	objectName := ns.ObjectName(userID)
+
+	if versioned, ok := store.(VersionedStore); ok {
+		return versioned.ListVersions(ctx, objectName)
+	}
+
+	versions := []BackupVersion{}
+	err = store.List(ctx, objectName, func(info ObjectInfo) error {
+		if info.Key == objectName {
+			versions = append(versions, BackupVersion{
+				LastModified: info.LastModified,
+				Size:         info.Size,
+				IsLatest:     true,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// HealthCheck checks backup store connectivity
+func (s *IntegrationService) HealthCheck(ctx context.Context) error {
+	store, err := s.getStore()
+	if err != nil {
+		return err
+	}
+	return store.Health(ctx)
 }
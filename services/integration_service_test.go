@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-microservice/models"
+)
+
+// newTestIntegrationService returns an IntegrationService backed by store
+func newTestIntegrationService(store BackupStore) *IntegrationService {
+	s := &IntegrationService{
+		compression: CompressionNone,
+		namespaces:  DefaultNamespaceConfig(),
+	}
+	s.UseStore(store)
+	return s
+}
+
+func TestIntegrationServiceBackupRestoreMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := newTestIntegrationService(NewMemoryStore())
+	ns, err := s.Namespace("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &models.User{ID: 7, Name: "Ada Lovelace", Email: "ada@example.com", CreatedAt: time.Now().UTC()}
+	if err := s.BackupUser(ctx, ns, user); err != nil {
+		t.Fatalf("BackupUser: %v", err)
+	}
+
+	restored, err := s.RestoreUser(ctx, ns, user.ID)
+	if err != nil {
+		t.Fatalf("RestoreUser: %v", err)
+	}
+	if restored.ID != user.ID || restored.Name != user.Name || restored.Email != user.Email {
+		t.Fatalf("restored = %+v, want %+v", restored, user)
+	}
+
+	if keys, _ := listKeys(t, s, ns, BackupListOptions{}); len(keys) != 1 || keys[0] != "users/7.json" {
+		t.Fatalf("ListBackups = %v", keys)
+	}
+
+	if err := s.DeleteUserBackup(ctx, ns, user.ID); err != nil {
+		t.Fatalf("DeleteUserBackup: %v", err)
+	}
+	if _, err := s.RestoreUser(ctx, ns, user.ID); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("RestoreUser after delete = %v, want ErrObjectNotFound", err)
+	}
+}
+
+func TestIntegrationServiceCompressedBackup(t *testing.T) {
+	ctx := context.Background()
+	s := newTestIntegrationService(NewMemoryStore())
+	s.SetCompression(CompressionGzip)
+	ns, _ := s.Namespace("")
+
+	user := &models.User{ID: 8, Name: "Grace Hopper", Email: "grace@example.com"}
+	if err := s.BackupUser(ctx, ns, user); err != nil {
+		t.Fatalf("BackupUser: %v", err)
+	}
+	report, err := s.VerifyBackups(ctx, ns)
+	if err != nil {
+		t.Fatalf("VerifyBackups: %v", err)
+	}
+	if report.OK != 1 || len(report.Problems) != 0 {
+		t.Fatalf("VerifyBackups report = %+v", report)
+	}
+	restored, err := s.RestoreUser(ctx, ns, user.ID)
+	if err != nil || restored.Email != user.Email {
+		t.Fatalf("RestoreUser = %+v, %v", restored, err)
+	}
+}
+
+func TestVerifyBackupsClassification(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	s := newTestIntegrationService(store)
+	ns, _ := s.Namespace("")
+
+	for id := 1; id <= 4; id++ {
+		user := &models.User{ID: id, Name: "User", Email: "user@example.com"}
+		if err := s.BackupUser(ctx, ns, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// user 2: a flipped byte no longer matches the checksum
+	corrupt, err := store.Get(ctx, "users/2.json", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt.Data[0] ^= 0xff
+	// user 3: a valid payload written before checksums were recorded
+	unverified, err := store.Get(ctx, "users/3.json", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(unverified.Metadata, metaChecksum)
+	// user 4: checksum matches but the payload cannot be decoded
+	unreadable, err := store.Get(ctx, "users/4.json", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreadable.Data = []byte("not json")
+	unreadable.Metadata[metaChecksum] = checksum(unreadable.Data)
+
+	for _, obj := range []*BackupObject{corrupt, unverified, unreadable} {
+		if err := store.Put(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := s.VerifyBackups(ctx, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Scanned != 4 || report.OK != 1 || report.Unverified != 1 || len(report.Problems) != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	want := map[string]string{
+		"users/2.json": BackupCorrupt,
+		"users/4.json": BackupUnreadable,
+	}
+	for _, check := range report.Problems {
+		if want[check.Object] != check.Status {
+			t.Errorf("%s: status %q, want %q", check.Object, check.Status, want[check.Object])
+		}
+	}
+}
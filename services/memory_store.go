@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process BackupStore, intended for tests and for
+// running the service without any persistent backup target
+type MemoryStore struct {
+	objects map[string]*BackupObject
+	buckets map[string]*MemoryStore
+	mu      sync.RWMutex
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		objects: make(map[string]*BackupObject),
+		buckets: make(map[string]*MemoryStore),
+	}
+}
+
+// ForBucket returns a separate in-memory store for bucket
+func (m *MemoryStore) ForBucket(bucket string) (BackupStore, error) {
+	if bucket == "" {
+		return m, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	store, ok := m.buckets[bucket]
+	if !ok {
+		store = NewMemoryStore()
+		m.buckets[bucket] = store
+	}
+	return store, nil
+}
+
+// Name returns the backend name
+func (m *MemoryStore) Name() string {
+	return StoreMemory
+}
+
+// Put stores a copy of obj
+func (m *MemoryStore) Put(ctx context.Context, obj *BackupObject) error {
+	stored := cloneObject(obj)
+	stored.LastModified = time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[obj.Key] = stored
+	return nil
+}
+
+// Get returns a copy of the stored object
+func (m *MemoryStore) Get(ctx context.Context, key, versionID string) (*BackupObject, error) {
+	if versionID != "" {
+		return nil, fmt.Errorf("%w: memory store does not keep versions", ErrObjectNotFound)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	obj, exists := m.objects[key]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	}
+	return cloneObject(obj), nil
+}
+
+// Delete removes an object
+func (m *MemoryStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+// List calls fn for each object under prefix in key order. The snapshot is
+// taken before fn runs, so fn may modify the store.
+func (m *MemoryStore) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	return m.ListAfter(ctx, prefix, "", fn)
+}
+
+// ListAfter lists objects under prefix with keys after startAfter
+func (m *MemoryStore) ListAfter(ctx context.Context, prefix, startAfter string, fn func(ObjectInfo) error) error {
+	m.mu.RLock()
+	infos := make([]ObjectInfo, 0, len(m.objects))
+	for key, obj := range m.objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			infos = append(infos, ObjectInfo{
+				Key:          key,
+				Size:         int64(len(obj.Data)),
+				LastModified: obj.LastModified,
+				ETag:         checksum(obj.Data),
+			})
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	for _, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Health always succeeds
+func (m *MemoryStore) Health(ctx context.Context) error {
+	return nil
+}
+
+// cloneObject deep-copies obj so callers cannot mutate stored state
+func cloneObject(obj *BackupObject) *BackupObject {
+	cloned := *obj
+	cloned.Data = append([]byte(nil), obj.Data...)
+	cloned.Metadata = copyMetadata(obj.Metadata)
+	return &cloned
+}
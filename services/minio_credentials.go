@@ -0,0 +1,178 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Credential providers accepted in MinIOConfig.CredentialChain
+const (
+	CredentialsStatic = "static" // AccessKeyID / SecretAccessKey from the config
+	CredentialsEnv    = "env"    // MINIO_ROOT_USER, MINIO_ACCESS_KEY, AWS_ACCESS_KEY_ID, ...
+	CredentialsFile   = "file"   // AccessKeyFile / SecretKeyFile, e.g. Docker secrets
+	CredentialsAWS    = "aws"    // AWS-style shared credentials file
+	CredentialsIAM    = "iam"    // EC2/ECS/EKS metadata with automatic refresh
+)
+
+// fileCredentialsTTL is how long keys read from files are cached before
+// the files are read again, so rotated secrets are picked up
+const fileCredentialsTTL = 5 * time.Minute
+
+// String describes the config without its secret key
+func (c MinIOConfig) String() string {
+	return fmt.Sprintf("MinIOConfig{Endpoint: %s, Bucket: %s, UseSSL: %t, CredentialChain: %v}",
+		c.Endpoint, c.BucketName, c.UseSSL, c.CredentialChain)
+}
+
+// GoString keeps %#v from printing the secret key
+func (c MinIOConfig) GoString() string {
+	return c.String()
+}
+
+// credentials builds the provider chain named by CredentialChain
+func (c MinIOConfig) credentials() (*credentials.Credentials, error) {
+	chain := c.CredentialChain
+	if len(chain) == 0 {
+		chain = []string{CredentialsStatic}
+	}
+
+	providers := make([]credentials.Provider, 0, len(chain))
+	for _, name := range chain {
+		switch name {
+		case CredentialsStatic:
+			providers = append(providers, &credentials.Static{
+				Value: credentials.Value{
+					AccessKeyID:     c.AccessKeyID,
+					SecretAccessKey: c.SecretAccessKey,
+					SignerType:      credentials.SignatureV4,
+				},
+			})
+		case CredentialsEnv:
+			providers = append(providers, &credentials.EnvMinio{}, &credentials.EnvAWS{})
+		case CredentialsFile:
+			if c.AccessKeyFile == "" || c.SecretKeyFile == "" {
+				return nil, errors.New("file credentials require AccessKeyFile and SecretKeyFile")
+			}
+			providers = append(providers, &fileCredentials{
+				accessKeyFile: c.AccessKeyFile,
+				secretKeyFile: c.SecretKeyFile,
+			})
+		case CredentialsAWS:
+			providers = append(providers, &credentials.FileAWSCredentials{
+				Filename: c.AWSCredentialsFile,
+				Profile:  c.AWSProfile,
+			})
+		case CredentialsIAM:
+			providers = append(providers, &credentials.IAM{
+				Client:   &http.Client{Timeout: 10 * time.Second},
+				Endpoint: c.IAMEndpoint,
+			})
+		default:
+			return nil, fmt.Errorf("unknown credential provider %q", name)
+		}
+	}
+
+	return credentials.NewChainCredentials(providers), nil
+}
+
+// transport builds the HTTP transport, adding a custom CA bundle and a
+// client certificate when configured
+func (c MinIOConfig) transport() (http.RoundTripper, error) {
+	tr, err := minio.DefaultTransport(c.UseSSL)
+	if err != nil {
+		return nil, err
+	}
+	if !c.UseSSL {
+		if c.CAFile != "" || c.ClientCertFile != "" {
+			return nil, errors.New("TLS options require UseSSL")
+		}
+		return tr, nil
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", c.CAFile)
+		}
+		tr.TLSClientConfig.RootCAs = pool
+	}
+
+	if c.ClientCertFile != "" || c.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tr.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tr, nil
+}
+
+// fileCredentials reads keys from two files and re-reads them after
+// fileCredentialsTTL so rotated secrets take effect without a restart
+type fileCredentials struct {
+	accessKeyFile string
+	secretKeyFile string
+
+	mu        sync.Mutex
+	expiresAt time.Time
+}
+
+// Retrieve reads the key files. Errors name the files but never their contents.
+func (f *fileCredentials) Retrieve() (credentials.Value, error) {
+	accessKey, err := readSecretFile(f.accessKeyFile)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	secretKey, err := readSecretFile(f.secretKeyFile)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	f.mu.Lock()
+	f.expiresAt = time.Now().Add(fileCredentialsTTL)
+	f.mu.Unlock()
+
+	return credentials.Value{
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+// IsExpired reports whether the files should be read again
+func (f *fileCredentials) IsExpired() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return time.Now().After(f.expiresAt)
+}
+
+// readSecretFile returns the trimmed contents of a secret file
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read credentials file %s: %w", path, err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("credentials file %s is empty", path)
+	}
+	return value, nil
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMinIOConfigStringRedactsSecret(t *testing.T) {
+	config := MinIOConfig{
+		Endpoint:        "minio:9000",
+		AccessKeyID:     "access-id",
+		SecretAccessKey: "super-secret-key",
+		BucketName:      "users-backup",
+	}
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		out := fmt.Sprintf(format, config)
+		if strings.Contains(out, "super-secret-key") {
+			t.Errorf("%s leaks the secret key: %s", format, out)
+		}
+		if !strings.Contains(out, "minio:9000") {
+			t.Errorf("%s omits the endpoint: %s", format, out)
+		}
+	}
+	if out := fmt.Sprintf("%v", &config); strings.Contains(out, "super-secret-key") {
+		t.Errorf("pointer formatting leaks the secret key: %s", out)
+	}
+}
+
+// writeFile writes content to name in dir and returns its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMinIOConfigCredentialChainOrder(t *testing.T) {
+	dir := t.TempDir()
+	accessFile := writeFile(t, dir, "access", "file-id\n")
+	secretFile := writeFile(t, dir, "secret", "file-secret\n")
+	t.Setenv("MINIO_ROOT_USER", "env-id")
+	t.Setenv("MINIO_ROOT_PASSWORD", "env-secret")
+
+	for _, tc := range []struct {
+		name   string
+		chain  []string
+		static bool
+		files  bool
+		wantID string
+	}{
+		{"default is static", nil, true, true, "static-id"},
+		{"first provider wins", []string{CredentialsFile, CredentialsStatic}, true, true, "file-id"},
+		{"static before file", []string{CredentialsStatic, CredentialsFile}, true, true, "static-id"},
+		{"empty static falls through", []string{CredentialsStatic, CredentialsEnv}, false, true, "env-id"},
+		{"unreadable file falls through", []string{CredentialsFile, CredentialsStatic}, true, false, "static-id"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := MinIOConfig{
+				CredentialChain: tc.chain,
+				AccessKeyFile:   accessFile,
+				SecretKeyFile:   secretFile,
+			}
+			if tc.static {
+				config.AccessKeyID, config.SecretAccessKey = "static-id", "static-secret"
+			}
+			if !tc.files {
+				config.AccessKeyFile = filepath.Join(dir, "missing")
+			}
+
+			creds, err := config.credentials()
+			if err != nil {
+				t.Fatal(err)
+			}
+			value, err := creds.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value.AccessKeyID != tc.wantID {
+				t.Fatalf("AccessKeyID = %q, want %q", value.AccessKeyID, tc.wantID)
+			}
+		})
+	}
+}
+
+func TestMinIOConfigCredentialChainErrors(t *testing.T) {
+	if _, err := (MinIOConfig{CredentialChain: []string{"vault"}}).credentials(); err == nil {
+		t.Error("accepted an unknown provider")
+	}
+	if _, err := (MinIOConfig{CredentialChain: []string{CredentialsFile}}).credentials(); err == nil {
+		t.Error("accepted file credentials without files")
+	}
+}
+
+func TestFileCredentialsRereadAfterExpiry(t *testing.T) {
+	dir := t.TempDir()
+	f := &fileCredentials{
+		accessKeyFile: writeFile(t, dir, "access", "id-1"),
+		secretKeyFile: writeFile(t, dir, "secret", "secret-1"),
+	}
+	if !f.IsExpired() {
+		t.Fatal("unread credentials are not expired")
+	}
+	if value, err := f.Retrieve(); err != nil || value.AccessKeyID != "id-1" {
+		t.Fatalf("Retrieve = %+v, %v", value, err)
+	}
+	if f.IsExpired() {
+		t.Fatal("credentials expired right after Retrieve")
+	}
+
+	writeFile(t, dir, "secret", "  ")
+	if _, err := f.Retrieve(); err == nil || strings.Contains(err.Error(), "id-1") {
+		t.Fatalf("empty secret file: err = %v", err)
+	}
+}
+
+// writeCertificate creates a self-signed certificate and key in dir and
+// returns the paths of their PEM files
+func writeCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "backup-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = writeFile(t, dir, "client.crt", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	keyFile = writeFile(t, dir, "client.key", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+	return certFile, keyFile
+}
+
+func TestMinIOConfigTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := writeFile(t, dir, "ca.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})))
+	certFile, keyFile := writeCertificate(t, dir)
+
+	// Without the CA bundle the test server's certificate is untrusted
+	tr, err := MinIOConfig{UseSSL: true}.transport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := (&http.Client{Transport: tr}).Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("request succeeded without the CA bundle")
+	}
+
+	tr, err = MinIOConfig{UseSSL: true, CAFile: caFile, ClientCertFile: certFile, ClientKeyFile: keyFile}.transport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: tr}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("request with CA bundle: %v", err)
+	}
+	resp.Body.Close()
+	if certs := tr.(*http.Transport).TLSClientConfig.Certificates; len(certs) != 1 {
+		t.Fatalf("transport has %d client certificates, want 1", len(certs))
+	}
+}
+
+func TestMinIOConfigTransportErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, _ := writeCertificate(t, dir)
+	notPEM := writeFile(t, dir, "not.pem", "not a certificate")
+
+	for _, tc := range []struct {
+		name    string
+		config  MinIOConfig
+		wantErr string
+	}{
+		{"TLS options without SSL", MinIOConfig{CAFile: certFile}, "require UseSSL"},
+		{"missing CA bundle", MinIOConfig{UseSSL: true, CAFile: filepath.Join(dir, "missing")}, "failed to read CA bundle"},
+		{"CA bundle without certificates", MinIOConfig{UseSSL: true, CAFile: notPEM}, "no certificates"},
+		{"client certificate without key", MinIOConfig{UseSSL: true, ClientCertFile: certFile}, "client certificate"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.config.transport(); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,377 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-microservice/metrics"
+	"go-microservice/utils"
+)
+
+// MinIOStore is a BackupStore backed by an S3-compatible MinIO bucket
+type MinIOStore struct {
+	client     *minio.Client
+	bucketName string
+	endpoint   string
+	versioned  bool
+	breaker    *CircuitBreaker
+	buckets    *bucketStores
+}
+
+// bucketStores caches the per-bucket stores sharing one MinIO client
+type bucketStores struct {
+	mu     sync.Mutex
+	stores map[string]*MinIOStore
+}
+
+// NewMinIOStore creates a MinIO client, ensures the bucket exists and
+// enables versioning when the server supports it. Bucket setup problems
+// are logged rather than returned, since the bucket may become accessible
+// later. Put and Get are guarded by breaker, which may be nil.
+func NewMinIOStore(config MinIOConfig, breaker *CircuitBreaker) (*MinIOStore, error) {
+	creds, err := config.credentials()
+	if err != nil {
+		return nil, fmt.Errorf("invalid MinIO credentials config: %w", err)
+	}
+
+	transport, err := config.transport()
+	if err != nil {
+		return nil, fmt.Errorf("invalid MinIO TLS config: %w", err)
+	}
+
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:     creds,
+		Secure:    config.UseSSL,
+		Transport: transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MinIO client: %w", err)
+	}
+
+	store := &MinIOStore{
+		client:     client,
+		bucketName: config.BucketName,
+		endpoint:   config.Endpoint,
+		breaker:    breaker,
+		buckets:    &bucketStores{stores: make(map[string]*MinIOStore)},
+	}
+	store.ensureBucket()
+
+	slog.Info("Connected to MinIO", "endpoint", config.Endpoint)
+	return store, nil
+}
+
+// ensureBucket creates the bucket if it doesn't exist and enables
+// versioning. Failures are logged, since the bucket might not be
+// accessible yet.
+func (m *MinIOStore) ensureBucket() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	call := startStorageCall(ctx, "bucket_exists", m.bucketName, "")
+	exists, err := m.client.BucketExists(call.ctx, m.bucketName)
+	call.end(err)
+	if err != nil {
+		slog.Warn("Failed to check bucket existence", "bucket", m.bucketName, "error", err)
+		return
+	}
+
+	if !exists {
+		call = startStorageCall(ctx, "make_bucket", m.bucketName, "")
+		err = m.client.MakeBucket(call.ctx, m.bucketName, minio.MakeBucketOptions{})
+		call.end(err)
+		if err != nil {
+			slog.Warn("Failed to create bucket", "bucket", m.bucketName, "error", err)
+		} else {
+			slog.Info("Created bucket", "bucket", m.bucketName)
+		}
+	}
+
+	// Keep every backup revision when the server supports it
+	call = startStorageCall(ctx, "enable_versioning", m.bucketName, "")
+	err = m.client.EnableVersioning(call.ctx, m.bucketName)
+	call.end(err)
+	if err != nil {
+		slog.Warn("Bucket versioning not available", "bucket", m.bucketName, "error", err)
+	} else {
+		m.versioned = true
+	}
+}
+
+// ForBucket returns a store for another bucket on the same MinIO
+// connection, creating the bucket on first use
+func (m *MinIOStore) ForBucket(bucket string) (BackupStore, error) {
+	if bucket == "" || bucket == m.bucketName {
+		return m, nil
+	}
+
+	m.buckets.mu.Lock()
+	defer m.buckets.mu.Unlock()
+
+	if store, ok := m.buckets.stores[bucket]; ok {
+		return store, nil
+	}
+
+	store := &MinIOStore{
+		client:     m.client,
+		bucketName: bucket,
+		endpoint:   m.endpoint,
+		breaker:    m.breaker,
+		buckets:    m.buckets,
+	}
+	store.ensureBucket()
+	m.buckets.stores[bucket] = store
+	return store, nil
+}
+
+// Name returns the backend name
+func (m *MinIOStore) Name() string {
+	return StoreMinIO
+}
+
+// Versioned reports whether bucket versioning was enabled
+func (m *MinIOStore) Versioned() bool {
+	return m.versioned
+}
+
+// Put uploads an object
+func (m *MinIOStore) Put(ctx context.Context, obj *BackupObject) error {
+	call := startStorageCall(ctx, "put", m.bucketName, obj.Key)
+	generation, err := m.breaker.Allow()
+	if err != nil {
+		call.end(err)
+		return err
+	}
+
+	_, err = m.client.PutObject(call.ctx, m.bucketName, obj.Key, bytes.NewReader(obj.Data), int64(len(obj.Data)), minio.PutObjectOptions{
+		ContentType:     obj.ContentType,
+		ContentEncoding: obj.ContentEncoding,
+		UserMetadata:    obj.Metadata,
+	})
+	m.breaker.Record(generation, err)
+	call.end(err)
+	if err == nil {
+		metrics.AddStorageBytes("put", len(obj.Data))
+	}
+	return err
+}
+
+// Get downloads an object together with its metadata
+func (m *MinIOStore) Get(ctx context.Context, key, versionID string) (*BackupObject, error) {
+	call := startStorageCall(ctx, "get", m.bucketName, key)
+	generation, err := m.breaker.Allow()
+	if err != nil {
+		call.end(err)
+		return nil, err
+	}
+
+	obj, err := m.get(call.ctx, key, versionID)
+	m.breaker.Record(generation, err)
+	call.end(err)
+	if err == nil {
+		metrics.AddStorageBytes("get", len(obj.Data))
+	}
+	return obj, err
+}
+
+// get performs the download for Get
+func (m *MinIOStore) get(ctx context.Context, key, versionID string) (*BackupObject, error) {
+	obj, err := m.client.GetObject(ctx, m.bucketName, key, minio.GetObjectOptions{
+		VersionID: versionID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	info, err := obj.Stat()
+	if err != nil {
+		if code := minio.ToErrorResponse(err).Code; code == "NoSuchKey" || code == "NoSuchVersion" {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+		}
+		return nil, err
+	}
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BackupObject{
+		Key:             key,
+		Data:            data,
+		ContentType:     info.ContentType,
+		ContentEncoding: info.Metadata.Get("Content-Encoding"),
+		Metadata:        info.UserMetadata,
+		VersionID:       info.VersionID,
+		LastModified:    info.LastModified,
+	}, nil
+}
+
+// Delete removes an object
+func (m *MinIOStore) Delete(ctx context.Context, key string) error {
+	call := startStorageCall(ctx, "remove", m.bucketName, key)
+	err := m.client.RemoveObject(call.ctx, m.bucketName, key, minio.RemoveObjectOptions{})
+	call.end(err)
+	return err
+}
+
+// List streams objects under prefix
+func (m *MinIOStore) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	return m.ListAfter(ctx, prefix, "", fn)
+}
+
+// ListAfter lists objects under prefix with keys after startAfter; the
+// server skips the earlier keys
+func (m *MinIOStore) ListAfter(ctx context.Context, prefix, startAfter string, fn func(ObjectInfo) error) (err error) {
+	// Time spent in fn is included, since listing is paced by the consumer
+	call := startStorageCall(ctx, "list", m.bucketName, prefix)
+	defer func() { call.end(err) }()
+
+	// Cancelling stops the listing goroutine if fn returns early
+	ctx, cancel := context.WithCancel(call.ctx)
+	defer cancel()
+
+	objectCh := m.client.ListObjects(ctx, m.bucketName, minio.ListObjectsOptions{
+		Prefix:     prefix,
+		StartAfter: startAfter,
+		Recursive:  true,
+	})
+
+	for object := range objectCh {
+		if object.Err != nil {
+			return fmt.Errorf("error listing objects: %w", object.Err)
+		}
+		err := fn(ObjectInfo{
+			Key:          object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+			ETag:         object.ETag,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListVersions returns all versions of key, newest first
+func (m *MinIOStore) ListVersions(ctx context.Context, key string) (versions []BackupVersion, err error) {
+	call := startStorageCall(ctx, "list_versions", m.bucketName, key)
+	defer func() { call.end(err) }()
+
+	versions = []BackupVersion{}
+	objectCh := m.client.ListObjects(call.ctx, m.bucketName, minio.ListObjectsOptions{
+		Prefix:       key,
+		WithVersions: true,
+	})
+
+	for object := range objectCh {
+		if object.Err != nil {
+			return nil, fmt.Errorf("error listing object versions: %w", object.Err)
+		}
+		// Prefix matching would also return e.g. users/1.json.bak
+		if object.Key != key {
+			continue
+		}
+		versions = append(versions, BackupVersion{
+			VersionID:      object.VersionID,
+			LastModified:   object.LastModified,
+			Size:           object.Size,
+			IsLatest:       object.IsLatest,
+			IsDeleteMarker: object.IsDeleteMarker,
+		})
+	}
+
+	return versions, nil
+}
+
+// ReplaceMetadata rewrites object metadata through a server-side copy
+func (m *MinIOStore) ReplaceMetadata(ctx context.Context, obj *BackupObject) error {
+	meta := copyMetadata(obj.Metadata)
+	// Replacing metadata drops standard headers unless they are resent
+	meta["Content-Type"] = obj.ContentType
+	if obj.ContentEncoding != "" {
+		meta["Content-Encoding"] = obj.ContentEncoding
+	}
+
+	call := startStorageCall(ctx, "copy", m.bucketName, obj.Key)
+	_, err := m.client.CopyObject(call.ctx,
+		minio.CopyDestOptions{
+			Bucket:          m.bucketName,
+			Object:          obj.Key,
+			UserMetadata:    meta,
+			ReplaceMetadata: true,
+		},
+		minio.CopySrcOptions{
+			Bucket:    m.bucketName,
+			Object:    obj.Key,
+			VersionID: obj.VersionID,
+		},
+	)
+	call.end(err)
+	return err
+}
+
+// Health checks that the bucket is reachable
+func (m *MinIOStore) Health(ctx context.Context) error {
+	call := startStorageCall(ctx, "bucket_exists", m.bucketName, "")
+	_, err := m.client.BucketExists(call.ctx, m.bucketName)
+	call.end(err)
+	return err
+}
+
+// storageCall times and traces one MinIO call
+type storageCall struct {
+	// ctx carries the call's span; pass it to the client
+	ctx       context.Context
+	span      trace.Span
+	operation string
+	start     time.Time
+}
+
+// startStorageCall starts a client span for operation on key, empty for
+// bucket operations, and starts timing the call
+func startStorageCall(ctx context.Context, operation, bucket, key string) *storageCall {
+	ctx, span := utils.StartSpan(ctx, "minio "+operation,
+		attribute.String("storage.operation", operation),
+		attribute.String("storage.bucket", bucket))
+	if key != "" {
+		span.SetAttributes(attribute.String("storage.key", key))
+	}
+	return &storageCall{ctx: ctx, span: span, operation: operation, start: time.Now()}
+}
+
+// end records metrics for the call and ends its span
+func (c *storageCall) end(err error) {
+	outcome := storageOutcome(err)
+	metrics.ObserveStorageOperation(c.ctx, c.operation, outcome, time.Since(c.start))
+	c.span.SetAttributes(attribute.String("storage.outcome", outcome))
+	utils.EndSpan(c.span, err)
+}
+
+// storageOutcome classifies an operation error for the outcome label
+func storageOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, ErrCircuitOpen):
+		return "rejected"
+	case errors.Is(err, ErrObjectNotFound):
+		return "not_found"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"go-microservice/metrics"
+)
+
+// ConnectionState describes the supervisor's view of the MinIO connection
+type ConnectionState string
+
+const (
+	StateDisconnected ConnectionState = "disconnected"
+	StateConnecting   ConnectionState = "connecting"
+	StateConnected    ConnectionState = "connected"
+)
+
+// Supervisor tuning
+const (
+	reconnectBaseDelay  = 500 * time.Millisecond
+	reconnectMaxDelay   = 30 * time.Second
+	healthCheckInterval = 15 * time.Second
+	healthCheckTimeout  = 5 * time.Second
+)
+
+// StartSupervisor connects to MinIO in the background and keeps the
+// connection alive: failed attempts are retried with jittered exponential
+// backoff, and a failing periodic health check triggers a reconnect.
+// Calling it again restarts the supervisor with the new config.
+func (s *IntegrationService) StartSupervisor(config MinIOConfig) {
+	s.StopSupervisor()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	s.mu.Lock()
+	s.config = config
+	s.stopSupervisor = cancel
+	s.supervisorDone = done
+	s.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		s.supervise(ctx)
+	}()
+}
+
+// StopSupervisor stops the background supervisor and waits for it to exit
+func (s *IntegrationService) StopSupervisor() {
+	s.mu.Lock()
+	cancel, done := s.stopSupervisor, s.supervisorDone
+	s.stopSupervisor, s.supervisorDone = nil, nil
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// supervise runs the connect / health-check loop until ctx is cancelled
+func (s *IntegrationService) supervise(ctx context.Context) {
+	attempt := 0
+	for {
+		var wait time.Duration
+
+		if s.ConnectionState() == StateConnected {
+			wait = healthCheckInterval
+			if err := s.checkHealth(ctx); err != nil {
+				slog.Warn("MinIO health check failed, reconnecting", "error", err)
+				s.setConnectionState(StateConnecting)
+				wait = 0
+			}
+		} else {
+			attempt++
+			if err := s.tryConnect(ctx); err != nil {
+				metrics.IncrementMinIOReconnectAttempts("failure")
+				wait = backoffDelay(attempt)
+				slog.Warn("MinIO connection attempt failed", "attempt", attempt, "retry_in", wait.Round(time.Millisecond), "error", err)
+			} else {
+				metrics.IncrementMinIOReconnectAttempts("success")
+				attempt = 0
+				wait = healthCheckInterval
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// tryConnect creates a fresh MinIO store and installs it once it is healthy
+func (s *IntegrationService) tryConnect(ctx context.Context) error {
+	s.mu.RLock()
+	config := s.config
+	s.mu.RUnlock()
+
+	s.setConnectionState(StateConnecting)
+
+	store, err := NewMinIOStore(config, s.breaker)
+	if err != nil {
+		return err
+	}
+
+	healthCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	if err := store.Health(healthCtx); err != nil {
+		return err
+	}
+
+	s.UseStore(store)
+	s.setConnectionState(StateConnected)
+	return nil
+}
+
+// checkHealth probes the current store
+func (s *IntegrationService) checkHealth(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	return s.HealthCheck(ctx)
+}
+
+// backoffDelay returns the jittered delay before retry number attempt:
+// a random duration between half and all of base*2^(attempt-1), capped
+func backoffDelay(attempt int) time.Duration {
+	delay := reconnectMaxDelay
+	if attempt < 16 {
+		delay = min(reconnectBaseDelay<<(attempt-1), reconnectMaxDelay)
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// ConnectionState returns the current MinIO connection state
+func (s *IntegrationService) ConnectionState() ConnectionState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.connState
+}
+
+// BreakerState returns the state of the MinIO circuit breaker
+func (s *IntegrationService) BreakerState() BreakerState {
+	return s.breaker.State()
+}
+
+// setConnectionState records a connection state transition
+func (s *IntegrationService) setConnectionState(state ConnectionState) {
+	s.mu.Lock()
+	previous := s.connState
+	s.connState = state
+	s.mu.Unlock()
+
+	if previous != state {
+		slog.Info("MinIO connection state changed", "from", previous, "to", state)
+		metrics.SetMinIOConnectionState(string(state))
+	}
+}
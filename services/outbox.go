@@ -0,0 +1,412 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-microservice/metrics"
+	"go-microservice/utils"
+)
+
+// OutboxKind selects how the relay delivers an outbox entry
+type OutboxKind string
+
+const (
+	// OutboxNotification entries are sent through NotificationService
+	OutboxNotification OutboxKind = "notification"
+	// OutboxAudit entries are written to the audit log
+	OutboxAudit OutboxKind = "audit"
+)
+
+// outboxCompactThreshold is the number of acknowledged entries after which
+// the journal is rewritten with only the pending ones
+const outboxCompactThreshold = 10000
+
+// ErrOutboxUnavailable is returned when a change could not be recorded in
+// the outbox; the change itself is not applied
+var ErrOutboxUnavailable = errors.New("outbox unavailable")
+
+// OutboxEntry is a side effect of a user change waiting to be delivered.
+// ID is the idempotency key: it stays the same across redeliveries so
+// receivers can discard duplicates.
+type OutboxEntry struct {
+	ID     string     `json:"id"`
+	Kind   OutboxKind `json:"kind"`
+	UserID int        `json:"user_id"`
+	// Type is the notification type or audit action
+	Type    string `json:"type"`
+	Message string `json:"message,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Locale  string `json:"locale,omitempty"`
+	// Audit is the full audit record for audit entries
+	Audit *utils.LogEntry `json:"audit,omitempty"`
+	// RequestID is the ID of the request that made the change, if any
+	RequestID string `json:"request_id,omitempty"`
+	// CreatedAt is when the change was made
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OutboxConfig tunes the relay and names the journal file
+type OutboxConfig struct {
+	// File is an append-only journal of added and acknowledged entries
+	File        string
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// LoadOutboxConfig reads the outbox config from the environment:
+// OUTBOX_FILE (default ./data/outbox.jsonl), OUTBOX_MAX_ATTEMPTS (default
+// 10) and OUTBOX_RETRY_BASE and OUTBOX_RETRY_MAX (durations, default 1s
+// and 5m)
+func LoadOutboxConfig() (OutboxConfig, error) {
+	config := OutboxConfig{
+		File:        "./data/outbox.jsonl",
+		MaxAttempts: 10,
+		BaseDelay:   time.Second,
+		MaxDelay:    5 * time.Minute,
+	}
+
+	if value := os.Getenv("OUTBOX_FILE"); value != "" {
+		config.File = value
+	}
+	if value := os.Getenv("OUTBOX_MAX_ATTEMPTS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return config, fmt.Errorf("invalid OUTBOX_MAX_ATTEMPTS: %q", value)
+		}
+		config.MaxAttempts = n
+	}
+	for name, target := range map[string]*time.Duration{
+		"OUTBOX_RETRY_BASE": &config.BaseDelay,
+		"OUTBOX_RETRY_MAX":  &config.MaxDelay,
+	} {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return config, fmt.Errorf("invalid %s: %q", name, value)
+			}
+			*target = d
+		}
+	}
+	return config, nil
+}
+
+// pendingEntry is an undelivered entry with its retry state
+type pendingEntry struct {
+	entry     OutboxEntry
+	attempts  int
+	nextTry   time.Time
+	lastError string
+}
+
+// outboxRecord is one journal line: either an added entry or the ID of an
+// acknowledged one
+type outboxRecord struct {
+	Entry *OutboxEntry `json:"entry,omitempty"`
+	Ack   string       `json:"ack,omitempty"`
+}
+
+// Outbox holds the side effects of user changes until the relay has
+// delivered them. UserService adds entries while it holds its lock, in the
+// same step as the change, so a change is never applied without them.
+// Entries are journaled to disk before Add returns and are only removed
+// after delivery, giving at-least-once delivery across restarts.
+type Outbox struct {
+	mu     sync.Mutex
+	config OutboxConfig
+	// pending is ordered by creation
+	pending []*pendingEntry
+	journal *os.File
+	acked   int
+
+	wake chan struct{}
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// NewOutbox creates an Outbox that keeps entries in memory until Start
+// opens its journal
+func NewOutbox() *Outbox {
+	return &Outbox{wake: make(chan struct{}, 1)}
+}
+
+// Add journals entries and queues them for delivery, assigning their IDs.
+// Either all entries are recorded or, on error, none are.
+func (o *Outbox) Add(entries ...OutboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var buf bytes.Buffer
+	added := make([]*pendingEntry, 0, len(entries))
+	for _, entry := range entries {
+		entry.ID = randomID(16)
+		added = append(added, &pendingEntry{entry: entry})
+		if o.journal != nil {
+			line, err := json.Marshal(outboxRecord{Entry: &entry})
+			if err != nil {
+				return err
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+	}
+
+	// One write per change, so a crash cannot leave half of it journaled
+	if o.journal != nil {
+		if _, err := o.journal.Write(buf.Bytes()); err != nil {
+			// Rewrite the journal so a partial line cannot swallow the next one
+			if compactErr := o.compactLocked(); compactErr != nil {
+				slog.Error("Failed to rewrite outbox journal", "error", compactErr)
+			}
+			return fmt.Errorf("%w: %v", ErrOutboxUnavailable, err)
+		}
+	}
+
+	o.pending = append(o.pending, added...)
+	metrics.SetOutboxPending(float64(len(o.pending)))
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pending returns the number of undelivered entries
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
+
+// open replays the journal, queueing entries that were never acknowledged
+// ahead of any added in memory, then compacts it and keeps it open for
+// appending. An empty File keeps the outbox in memory.
+func (o *Outbox) open() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.config.File == "" {
+		return nil
+	}
+
+	replayed, err := readOutboxJournal(o.config.File)
+	if err != nil {
+		return err
+	}
+	o.pending = append(replayed, o.pending...)
+	metrics.SetOutboxPending(float64(len(o.pending)))
+
+	if err := os.MkdirAll(filepath.Dir(o.config.File), 0o750); err != nil {
+		return fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+	return o.compactLocked()
+}
+
+// close closes the journal. Entries added afterwards stay in memory only.
+func (o *Outbox) close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.journal == nil {
+		return nil
+	}
+	err := o.journal.Close()
+	o.journal = nil
+	return err
+}
+
+// readOutboxJournal returns the entries added to the journal at path and
+// not acknowledged since. A missing journal is empty; a torn last line
+// from a crash mid-write is ignored.
+func readOutboxJournal(path string) ([]*pendingEntry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox journal: %w", err)
+	}
+	defer file.Close()
+
+	var order []string
+	entries := make(map[string]*pendingEntry)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		var record outboxRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		switch {
+		case record.Entry != nil:
+			if _, seen := entries[record.Entry.ID]; !seen {
+				order = append(order, record.Entry.ID)
+			}
+			entries[record.Entry.ID] = &pendingEntry{entry: *record.Entry}
+		case record.Ack != "":
+			delete(entries, record.Ack)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read outbox journal: %w", err)
+	}
+
+	pending := make([]*pendingEntry, 0, len(entries))
+	for _, id := range order {
+		if p, ok := entries[id]; ok {
+			pending = append(pending, p)
+			delete(entries, id)
+		}
+	}
+	return pending, nil
+}
+
+// compactLocked rewrites the journal with only the pending entries and
+// reopens it for appending. Callers hold o.mu.
+func (o *Outbox) compactLocked() error {
+	var buf bytes.Buffer
+	for _, p := range o.pending {
+		line, err := json.Marshal(outboxRecord{Entry: &p.entry})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	if o.journal != nil {
+		o.journal.Close()
+		o.journal = nil
+	}
+	if err := writeFileAtomic(o.config.File, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write outbox journal: %w", err)
+	}
+	journal, err := os.OpenFile(o.config.File, os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open outbox journal: %w", err)
+	}
+	o.journal = journal
+	o.acked = 0
+	return nil
+}
+
+// due returns copies of the entries whose next attempt is due, in order.
+// An entry waiting for a retry holds back the later entries of its stream,
+// even those that are due themselves.
+func (o *Outbox) due(now time.Time) []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var due []OutboxEntry
+	waiting := make(map[outboxStream]bool)
+	for _, p := range o.pending {
+		stream := outboxStream{p.entry.Kind, p.entry.UserID}
+		if waiting[stream] {
+			continue
+		}
+		if p.nextTry.After(now) {
+			waiting[stream] = true
+			continue
+		}
+		due = append(due, p.entry)
+	}
+	return due
+}
+
+// ack removes a delivered entry and journals the acknowledgement
+func (o *Outbox) ack(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.removeLocked(id)
+}
+
+// removeLocked drops an entry from the queue and journals its removal.
+// Callers hold o.mu.
+func (o *Outbox) removeLocked(id string) {
+	for i, p := range o.pending {
+		if p.entry.ID == id {
+			o.pending = append(o.pending[:i], o.pending[i+1:]...)
+			break
+		}
+	}
+	metrics.SetOutboxPending(float64(len(o.pending)))
+
+	if o.journal == nil {
+		return
+	}
+	line, _ := json.Marshal(outboxRecord{Ack: id})
+	if _, err := o.journal.Write(append(line, '\n')); err != nil {
+		// The entry is delivered again after a restart, which is allowed
+		slog.Error("Failed to journal outbox ack", "id", id, "error", err)
+		return
+	}
+	o.acked++
+	if o.acked >= outboxCompactThreshold {
+		if err := o.compactLocked(); err != nil {
+			slog.Error("Failed to compact outbox journal", "error", err)
+		}
+	}
+}
+
+// fail schedules a retry of an entry, or drops it after MaxAttempts.
+// It reports whether the entry was dropped.
+func (o *Outbox) fail(id string, deliveryErr error) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, p := range o.pending {
+		if p.entry.ID != id {
+			continue
+		}
+		p.attempts++
+		p.lastError = deliveryErr.Error()
+		if p.attempts >= o.config.MaxAttempts {
+			slog.Error("Dropping outbox entry after too many attempts",
+				"id", id, "kind", p.entry.Kind, "type", p.entry.Type, "user_id", p.entry.UserID,
+				"attempts", p.attempts, "error", p.lastError)
+			o.removeLocked(id)
+			return true
+		}
+		p.nextTry = time.Now().Add(o.retryDelay(p.attempts))
+		return false
+	}
+	return false
+}
+
+// retryDelay returns the exponential backoff before retry number attempt
+func (o *Outbox) retryDelay(attempt int) time.Duration {
+	delay := o.config.BaseDelay
+	for i := 1; i < attempt && delay < o.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, o.config.MaxDelay)
+}
+
+// localeKey is the context key for the recipient's preferred locale
+type localeKey struct{}
+
+// WithLocale returns a context carrying the locale notifications about the
+// change should be rendered in
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// localeFromContext returns the locale set by WithLocale, if any
+func localeFromContext(ctx context.Context) string {
+	locale, _ := ctx.Value(localeKey{}).(string)
+	return locale
+}
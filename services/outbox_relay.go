@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go-microservice/metrics"
+	"go-microservice/utils"
+)
+
+// outboxPollInterval is how often the outbox is checked for due retries
+const outboxPollInterval = time.Second
+
+// outboxStream identifies entries that must be delivered in order
+type outboxStream struct {
+	kind   OutboxKind
+	userID int
+}
+
+// Start opens the journal, queueing entries left undelivered by the last
+// run, and starts the relay. Calling it again restarts the relay with the
+// new config.
+func (o *Outbox) Start(config OutboxConfig) error {
+	o.Stop()
+
+	o.mu.Lock()
+	o.config = config
+	o.mu.Unlock()
+
+	if err := o.open(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	o.mu.Lock()
+	o.stop = cancel
+	o.done = done
+	o.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		o.relay(ctx)
+	}()
+	return nil
+}
+
+// Stop stops the relay after the entry in progress and closes the journal.
+// Undelivered entries are delivered by the next Start.
+func (o *Outbox) Stop() {
+	o.mu.Lock()
+	cancel, done := o.stop, o.done
+	o.stop, o.done = nil, nil
+	o.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+		if err := o.close(); err != nil {
+			slog.Error("Failed to close outbox journal", "error", err)
+		}
+	}
+}
+
+// relay delivers due entries until ctx is cancelled
+func (o *Outbox) relay(ctx context.Context) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		o.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// deliverDue attempts every due entry once, in creation order. Entries of
+// the same kind for the same user form a stream: after a failure its later
+// entries wait until the failed one is delivered or dropped, so for example
+// an ACCOUNT_DELETED notification never overtakes a WELCOME.
+func (o *Outbox) deliverDue(ctx context.Context) {
+	blocked := make(map[outboxStream]bool)
+	for _, entry := range o.due(time.Now()) {
+		if ctx.Err() != nil {
+			return
+		}
+		stream := outboxStream{entry.Kind, entry.UserID}
+		if blocked[stream] {
+			continue
+		}
+
+		err := deliverOutboxEntry(ctx, entry)
+		// A shutdown interrupting delivery does not use up an attempt
+		if ctx.Err() != nil {
+			return
+		}
+
+		outcome := "success"
+		if err == nil {
+			o.ack(entry.ID)
+		} else {
+			blocked[stream] = true
+			outcome = "retry"
+			if o.fail(entry.ID, err) {
+				outcome = "dropped"
+			}
+		}
+		metrics.IncrementOutboxDeliveries(string(entry.Kind), outcome)
+	}
+}
+
+// deliverOutboxEntry hands an entry to the service that handles its kind,
+// passing its ID along as the idempotency key
+func deliverOutboxEntry(ctx context.Context, entry OutboxEntry) error {
+	switch entry.Kind {
+	case OutboxNotification:
+		return utils.GetNotificationService().Deliver(ctx, utils.Notification{
+			UserID:         entry.UserID,
+			Type:           entry.Type,
+			Message:        entry.Message,
+			Email:          entry.Email,
+			Name:           entry.Name,
+			Locale:         entry.Locale,
+			IdempotencyKey: entry.ID,
+			RequestID:      entry.RequestID,
+		})
+	case OutboxAudit:
+		audit := utils.LogEntry{
+			Timestamp: entry.CreatedAt,
+			Action:    entry.Type,
+			Actor:     utils.ActorSystem,
+			UserID:    entry.UserID,
+			Outcome:   utils.OutcomeSuccess,
+			Details:   entry.Message,
+		}
+		if entry.Audit != nil {
+			audit = *entry.Audit
+		}
+		audit.ID = entry.ID
+		return utils.GetAuditLogger().LogSync(audit)
+	default:
+		return fmt.Errorf("unknown outbox entry kind %q", entry.Kind)
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go-microservice/utils"
+)
+
+// recordingNotifier records the notifications it accepts and fails the
+// first failFirst attempts
+type recordingNotifier struct {
+	mu        sync.Mutex
+	failFirst int
+	attempts  int
+	delivered []string
+}
+
+func (r *recordingNotifier) Name() string { return "recording" }
+
+func (r *recordingNotifier) Notify(ctx context.Context, msg utils.NotificationMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+	if r.attempts <= r.failFirst {
+		return errors.New("receiver unavailable")
+	}
+	r.delivered = append(r.delivered, fmt.Sprintf("%d:%s", msg.UserID, msg.Type))
+	return nil
+}
+
+func (r *recordingNotifier) Delivered() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.delivered)
+}
+
+func TestOutboxKeepsStreamOrderAcrossRetries(t *testing.T) {
+	receiver := &recordingNotifier{failFirst: 1}
+	config := utils.DefaultNotificationConfig()
+	config.Notifiers = map[string]utils.Notifier{receiver.Name(): receiver}
+	config.Routes = map[string][]string{"*": {receiver.Name()}}
+	utils.GetNotificationService().Configure(config)
+	t.Cleanup(func() { utils.GetNotificationService().Configure(utils.DefaultNotificationConfig()) })
+
+	outbox := NewOutbox()
+	outbox.config = OutboxConfig{MaxAttempts: 5, BaseDelay: 50 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	err := outbox.Add(
+		OutboxEntry{Kind: OutboxNotification, UserID: 1, Type: utils.NotifyWelcome},
+		OutboxEntry{Kind: OutboxNotification, UserID: 1, Type: utils.NotifyAccountDeleted},
+		OutboxEntry{Kind: OutboxNotification, UserID: 2, Type: utils.NotifyWelcome},
+	)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	// The first WELCOME fails; user 1's ACCOUNT_DELETED must wait for its
+	// retry, even on passes where it is due and the WELCOME is not
+	ctx := context.Background()
+	outbox.deliverDue(ctx)
+	outbox.deliverDue(ctx)
+	if got, want := receiver.Delivered(), []string{"2:WELCOME"}; !slices.Equal(got, want) {
+		t.Fatalf("delivered before retry = %v, want %v", got, want)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	outbox.deliverDue(ctx)
+	want := []string{"2:WELCOME", "1:WELCOME", "1:ACCOUNT_DELETED"}
+	if got := receiver.Delivered(); !slices.Equal(got, want) {
+		t.Fatalf("delivered = %v, want %v", got, want)
+	}
+	if n := outbox.Pending(); n != 0 {
+		t.Fatalf("Pending() = %d, want 0", n)
+	}
+}
+
+func TestOutboxDropUnblocksStream(t *testing.T) {
+	receiver := &recordingNotifier{failFirst: 2}
+	config := utils.DefaultNotificationConfig()
+	config.Notifiers = map[string]utils.Notifier{receiver.Name(): receiver}
+	config.Routes = map[string][]string{"*": {receiver.Name()}}
+	utils.GetNotificationService().Configure(config)
+	t.Cleanup(func() { utils.GetNotificationService().Configure(utils.DefaultNotificationConfig()) })
+
+	outbox := NewOutbox()
+	outbox.config = OutboxConfig{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	err := outbox.Add(
+		OutboxEntry{Kind: OutboxNotification, UserID: 1, Type: utils.NotifyWelcome},
+		OutboxEntry{Kind: OutboxNotification, UserID: 1, Type: utils.NotifyProfileUpdated},
+	)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		outbox.deliverDue(ctx)
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got, want := receiver.Delivered(), []string{"1:PROFILE_UPDATED"}; !slices.Equal(got, want) {
+		t.Fatalf("delivered = %v, want %v", got, want)
+	}
+}
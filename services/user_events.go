@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go-microservice/models"
+)
+
+// UserEventType names a kind of user mutation
+type UserEventType string
+
+const (
+	EventUserCreated UserEventType = "UserCreated"
+	EventUserUpdated UserEventType = "UserUpdated"
+	EventUserDeleted UserEventType = "UserDeleted"
+)
+
+// Event bus sizing
+const (
+	// eventHistorySize is how many past events are kept for resuming
+	eventHistorySize = 10000
+	// subscriberBuffer is how far a subscriber may fall behind before it is dropped
+	subscriberBuffer = 256
+)
+
+// ErrEventsExpired is returned when resuming after an event that is no
+// longer in the history
+var ErrEventsExpired = errors.New("requested events are no longer available")
+
+// UserEvent is a single change to a user. Before is nil for UserCreated,
+// After is nil for UserDeleted.
+type UserEvent struct {
+	Sequence uint64        `json:"sequence"`
+	Type     UserEventType `json:"type"`
+	UserID   int           `json:"user_id"`
+	Before   *models.User  `json:"before,omitempty"`
+	After    *models.User  `json:"after,omitempty"`
+	// RequestID is the ID of the request that made the change, if any
+	RequestID string    `json:"request_id,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// EventBus assigns sequence numbers to user events, keeps a bounded
+// history and fans events out to subscribers. Publish never blocks:
+// a subscriber that falls behind has its channel closed and is expected
+// to resubscribe from the last sequence it saw.
+type EventBus struct {
+	mu       sync.Mutex
+	sequence uint64
+	// history is a ring buffer; event N is stored at (N-1) % eventHistorySize
+	history     []UserEvent
+	subscribers map[chan UserEvent]struct{}
+}
+
+// NewEventBus creates an empty EventBus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		history:     make([]UserEvent, eventHistorySize),
+		subscribers: make(map[chan UserEvent]struct{}),
+	}
+}
+
+// Publish stamps event with the next sequence number and delivers it
+func (b *EventBus) Publish(event UserEvent) UserEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sequence++
+	event.Sequence = b.sequence
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	b.history[(event.Sequence-1)%eventHistorySize] = event
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Too slow; the subscriber resumes from history
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return event
+}
+
+// Subscribe returns the retained events after afterSequence followed by a
+// channel of new ones. Call cancel to unsubscribe. afterSequence 0 only
+// delivers new events.
+func (b *EventBus) Subscribe(afterSequence uint64) (backlog []UserEvent, events <-chan UserEvent, cancel func(), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// A sequence beyond ours was issued before a restart
+	if afterSequence > b.sequence {
+		return nil, nil, nil, ErrEventsExpired
+	}
+	if afterSequence > 0 {
+		if b.sequence-afterSequence > eventHistorySize {
+			return nil, nil, nil, ErrEventsExpired
+		}
+		for seq := afterSequence + 1; seq <= b.sequence; seq++ {
+			backlog = append(backlog, b.history[(seq-1)%eventHistorySize])
+		}
+	}
+
+	ch := make(chan UserEvent, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, cancel, nil
+}
+
+// Close ends all current subscriptions, e.g. so open streams do not hold
+// up a graceful shutdown. Publishing and new subscriptions still work.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Sequence returns the sequence number of the latest event
+func (b *EventBus) Sequence() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sequence
+}
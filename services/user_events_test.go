@@ -0,0 +1,117 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// publishN publishes n UserUpdated events for consecutive user IDs
+func publishN(b *EventBus, n int) {
+	for i := 0; i < n; i++ {
+		b.Publish(UserEvent{Type: EventUserUpdated, UserID: i + 1})
+	}
+}
+
+func TestEventBusResumeFromHistory(t *testing.T) {
+	b := NewEventBus()
+	publishN(b, 5)
+
+	backlog, _, cancel, err := b.Subscribe(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if len(backlog) != 3 {
+		t.Fatalf("backlog has %d events, want 3", len(backlog))
+	}
+	for i, event := range backlog {
+		if event.Sequence != uint64(i+3) || event.UserID != i+3 || event.Timestamp.IsZero() {
+			t.Errorf("backlog[%d] = %+v", i, event)
+		}
+	}
+
+	// Sequence 0 subscribes to new events only
+	backlog, _, cancel0, err := b.Subscribe(0)
+	if err != nil || len(backlog) != 0 {
+		t.Fatalf("Subscribe(0) = %v, %v", backlog, err)
+	}
+	cancel0()
+}
+
+func TestEventBusRingBufferWraps(t *testing.T) {
+	b := NewEventBus()
+	publishN(b, eventHistorySize+10)
+
+	// The oldest retained event is sequence 11
+	backlog, _, cancel, err := b.Subscribe(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if len(backlog) != eventHistorySize || backlog[0].Sequence != 11 || backlog[len(backlog)-1].Sequence != eventHistorySize+10 {
+		t.Fatalf("backlog spans %d..%d (%d events)", backlog[0].Sequence, backlog[len(backlog)-1].Sequence, len(backlog))
+	}
+	for i := 1; i < len(backlog); i++ {
+		if backlog[i].Sequence != backlog[i-1].Sequence+1 {
+			t.Fatalf("backlog out of order at %d", i)
+		}
+	}
+
+	if _, _, _, err := b.Subscribe(9); !errors.Is(err, ErrEventsExpired) {
+		t.Fatalf("Subscribe(expired) = %v, want ErrEventsExpired", err)
+	}
+	if _, _, _, err := b.Subscribe(eventHistorySize + 11); !errors.Is(err, ErrEventsExpired) {
+		t.Fatalf("Subscribe(future) = %v, want ErrEventsExpired", err)
+	}
+}
+
+func TestEventBusDropsSlowSubscribers(t *testing.T) {
+	b := NewEventBus()
+	_, slow, cancelSlow, err := b.Subscribe(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelSlow()
+	_, fast, cancelFast, err := b.Subscribe(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancelFast()
+
+	// Fill both buffers, then let only one subscriber catch up
+	publishN(b, subscriberBuffer)
+	for i := 0; i < subscriberBuffer; i++ {
+		<-fast
+	}
+
+	// One more event overflows the slow subscriber without blocking Publish
+	done := make(chan struct{})
+	go func() {
+		publishN(b, 1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a slow subscriber")
+	}
+
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Fatalf("slow subscriber got %d events before being closed, want %d", n, subscriberBuffer)
+	}
+	if event := <-fast; event.Sequence != subscriberBuffer+1 {
+		t.Fatalf("fast subscriber got sequence %d, want %d", event.Sequence, subscriberBuffer+1)
+	}
+
+	// Close ends the remaining subscription; cancel afterwards is a no-op
+	b.Close()
+	if _, ok := <-fast; ok {
+		t.Fatal("subscription still open after Close")
+	}
+	cancelFast()
+}
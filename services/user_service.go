@@ -1,12 +1,18 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 
 	"go-microservice/metrics"
 	"go-microservice/models"
+	"go-microservice/utils"
 )
 
 // UserService handles business logic for user operations
@@ -14,6 +20,47 @@ type UserService struct {
 	users     map[int]*models.User
 	mu        sync.RWMutex
 	idCounter int64
+	events    *EventBus
+	outbox    *Outbox
+}
+
+// ConflictPolicy determines how Restore handles a user ID that already exists
+type ConflictPolicy string
+
+const (
+	// ConflictSkip leaves the existing user untouched
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces the existing user with the restored one
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictFail rejects the restore with ErrUserExists
+	ConflictFail ConflictPolicy = "fail"
+	// ConflictKeepNewer keeps whichever user has the later UpdatedAt
+	ConflictKeepNewer ConflictPolicy = "keep-newer"
+)
+
+// RestoreOutcome describes what Restore did with a user
+type RestoreOutcome string
+
+const (
+	RestoreCreated     RestoreOutcome = "created"
+	RestoreOverwritten RestoreOutcome = "overwritten"
+	RestoreSkipped     RestoreOutcome = "skipped"
+)
+
+// ErrUserExists is returned by Restore under ConflictFail
+var ErrUserExists = errors.New("user already exists")
+
+// ParseConflictPolicy converts a query value into a ConflictPolicy.
+// An empty value selects ConflictFail.
+func ParseConflictPolicy(value string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(value); policy {
+	case "":
+		return ConflictFail, nil
+	case ConflictSkip, ConflictOverwrite, ConflictFail, ConflictKeepNewer:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q", value)
+	}
 }
 
 var (
@@ -27,13 +74,20 @@ func GetUserService() *UserService {
 		userServiceInstance = &UserService{
 			users:     make(map[int]*models.User),
 			idCounter: 0,
+			events:    NewEventBus(),
+			outbox:    NewOutbox(),
 		}
 	})
 	return userServiceInstance
 }
 
-// Create creates a new user and returns it with assigned ID
-func (s *UserService) Create(user models.User) (*models.User, error) {
+// Create creates a new user and returns it with assigned ID. A welcome
+// notification and an audit entry are recorded in the outbox with it,
+// rendered in the locale set on ctx by WithLocale.
+func (s *UserService) Create(ctx context.Context, user models.User) (_ *models.User, err error) {
+	ctx, span := utils.StartSpan(ctx, "UserService.Create")
+	defer func() { utils.EndSpan(span, err) }()
+
 	// Sanitize input
 	user.Sanitize()
 
@@ -44,12 +98,27 @@ func (s *UserService) Create(user models.User) (*models.User, error) {
 
 	// Generate new ID atomically
 	newID := int(atomic.AddInt64(&s.idCounter, 1))
-	user.ID = newID
+
+	now := time.Now().UTC()
+	user.CreatedAt = now
+	user.UpdatedAt = now
 
 	// Store user
-	s.mu.Lock()
+	s.lock(ctx)
+	// Skip IDs claimed by a concurrent Restore
+	for s.users[newID] != nil {
+		newID = int(atomic.AddInt64(&s.idCounter, 1))
+	}
+	user.ID = newID
+	span.SetAttributes(attribute.Int("user.id", newID))
+	if err := s.recordChange(ctx, "CREATE", utils.NotifyWelcome, "User account created successfully", nil, &user); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
 	s.users[newID] = &user
 	count := len(s.users)
+	// Published under the lock so sequence order matches mutation order
+	s.publish(ctx, EventUserCreated, nil, &user)
 	s.mu.Unlock()
 
 	// Update metrics
@@ -59,8 +128,11 @@ func (s *UserService) Create(user models.User) (*models.User, error) {
 }
 
 // GetByID retrieves a user by ID
-func (s *UserService) GetByID(id int) (*models.User, error) {
-	s.mu.RLock()
+func (s *UserService) GetByID(ctx context.Context, id int) (_ *models.User, err error) {
+	ctx, span := utils.StartSpan(ctx, "UserService.GetByID", attribute.Int("user.id", id))
+	defer func() { utils.EndSpan(span, err) }()
+
+	s.rlock(ctx)
 	defer s.mu.RUnlock()
 
 	user, exists := s.users[id]
@@ -74,8 +146,11 @@ func (s *UserService) GetByID(id int) (*models.User, error) {
 }
 
 // GetAll retrieves all users
-func (s *UserService) GetAll() []*models.User {
-	s.mu.RLock()
+func (s *UserService) GetAll(ctx context.Context) []*models.User {
+	ctx, span := utils.StartSpan(ctx, "UserService.GetAll")
+	defer span.End()
+
+	s.rlock(ctx)
 	defer s.mu.RUnlock()
 
 	users := make([]*models.User, 0, len(s.users))
@@ -87,8 +162,12 @@ func (s *UserService) GetAll() []*models.User {
 	return users
 }
 
-// Update updates an existing user
-func (s *UserService) Update(id int, updated models.User) (*models.User, error) {
+// Update updates an existing user, recording a notification and an audit
+// entry in the outbox
+func (s *UserService) Update(ctx context.Context, id int, updated models.User) (_ *models.User, err error) {
+	ctx, span := utils.StartSpan(ctx, "UserService.Update", attribute.Int("user.id", id))
+	defer func() { utils.EndSpan(span, err) }()
+
 	// Sanitize input
 	updated.Sanitize()
 
@@ -97,7 +176,7 @@ func (s *UserService) Update(id int, updated models.User) (*models.User, error)
 		return nil, err
 	}
 
-	s.mu.Lock()
+	s.lock(ctx)
 	defer s.mu.Unlock()
 
 	existing, exists := s.users[id]
@@ -105,25 +184,45 @@ func (s *UserService) Update(id int, updated models.User) (*models.User, error)
 		return nil, errors.New("user not found")
 	}
 
+	before := *existing
+
 	// Update fields while preserving ID
-	existing.Name = updated.Name
-	existing.Email = updated.Email
+	next := *existing
+	next.Name = updated.Name
+	next.Email = updated.Email
+	next.UpdatedAt = time.Now().UTC()
+
+	if err := s.recordChange(ctx, "UPDATE", utils.NotifyProfileUpdated, "Your profile has been updated", existing, &next); err != nil {
+		return nil, err
+	}
+	*existing = next
+
+	s.publish(ctx, EventUserUpdated, &before, existing)
 
 	// Return a copy
 	userCopy := *existing
 	return &userCopy, nil
 }
 
-// Delete removes a user by ID
-func (s *UserService) Delete(id int) error {
-	s.mu.Lock()
+// Delete removes a user by ID, recording a notification and an audit entry
+// in the outbox
+func (s *UserService) Delete(ctx context.Context, id int) (err error) {
+	ctx, span := utils.StartSpan(ctx, "UserService.Delete", attribute.Int("user.id", id))
+	defer func() { utils.EndSpan(span, err) }()
+
+	s.lock(ctx)
 	defer s.mu.Unlock()
 
-	if _, exists := s.users[id]; !exists {
+	existing, exists := s.users[id]
+	if !exists {
 		return errors.New("user not found")
 	}
 
+	if err := s.recordChange(ctx, "DELETE", utils.NotifyAccountDeleted, "User account has been deleted", existing, nil); err != nil {
+		return err
+	}
 	delete(s.users, id)
+	s.publish(ctx, EventUserDeleted, existing, nil)
 
 	// Update metrics
 	metrics.SetActiveUsers(float64(len(s.users)))
@@ -131,6 +230,171 @@ func (s *UserService) Delete(id int) error {
 	return nil
 }
 
+// Restore inserts a user under its original ID, resolving an existing
+// user with the same ID according to policy. The ID counter is advanced
+// past the restored ID so later Create calls do not collide with it.
+// Restores that change the user are audited through the outbox, and the
+// change event carries the request ID of ctx.
+func (s *UserService) Restore(ctx context.Context, user models.User, policy ConflictPolicy) (_ *models.User, _ RestoreOutcome, err error) {
+	ctx, span := utils.StartSpan(ctx, "UserService.Restore",
+		attribute.Int("user.id", user.ID),
+		attribute.String("restore.policy", string(policy)))
+	defer func() { utils.EndSpan(span, err) }()
+
+	user.Sanitize()
+
+	if user.ID <= 0 {
+		return nil, "", errors.New("invalid user ID")
+	}
+	if err := user.Validate(); err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now().UTC()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = user.CreatedAt
+	}
+
+	s.lock(ctx)
+	defer s.mu.Unlock()
+
+	outcome := RestoreCreated
+	existing, exists := s.users[user.ID]
+	if exists {
+		switch policy {
+		case ConflictSkip:
+			userCopy := *existing
+			return &userCopy, RestoreSkipped, nil
+		case ConflictKeepNewer:
+			if !user.UpdatedAt.After(existing.UpdatedAt) {
+				userCopy := *existing
+				return &userCopy, RestoreSkipped, nil
+			}
+		case ConflictOverwrite:
+		default:
+			return nil, "", ErrUserExists
+		}
+		outcome = RestoreOverwritten
+	}
+
+	// Restores are audited like any other change but notify nobody
+	if err := s.recordChange(ctx, "RESTORE", "", "", existing, &user); err != nil {
+		return nil, "", err
+	}
+	s.users[user.ID] = &user
+	s.advanceIDCounter(int64(user.ID))
+
+	if exists {
+		s.publish(ctx, EventUserUpdated, existing, &user)
+	} else {
+		s.publish(ctx, EventUserCreated, nil, &user)
+	}
+
+	// Update metrics
+	metrics.SetActiveUsers(float64(len(s.users)))
+
+	userCopy := user
+	span.SetAttributes(attribute.String("restore.outcome", string(outcome)))
+	return &userCopy, outcome, nil
+}
+
+// lock takes the write lock, tracing the wait in a child span of ctx so
+// lock contention shows up in traces
+func (s *UserService) lock(ctx context.Context) {
+	_, span := utils.StartSpan(ctx, "UserService.lock")
+	s.mu.Lock()
+	span.End()
+}
+
+// rlock takes the read lock, tracing the wait like lock
+func (s *UserService) rlock(ctx context.Context) {
+	_, span := utils.StartSpan(ctx, "UserService.rlock")
+	s.mu.RLock()
+	span.End()
+}
+
+// advanceIDCounter raises idCounter to at least id
+func (s *UserService) advanceIDCounter(id int64) {
+	for {
+		current := atomic.LoadInt64(&s.idCounter)
+		if current >= id || atomic.CompareAndSwapInt64(&s.idCounter, current, id) {
+			return
+		}
+	}
+}
+
+// Events returns the bus user changes are published to
+func (s *UserService) Events() *EventBus {
+	return s.events
+}
+
+// Outbox returns the outbox user changes record their side effects in
+func (s *UserService) Outbox() *Outbox {
+	return s.outbox
+}
+
+// recordChange adds the audit entry and user notification for a change
+// from before to after (nil when the user does not exist on that side) to
+// the outbox; an empty notifType records the audit entry only. Callers hold
+// s.mu and apply the change only if it succeeds.
+func (s *UserService) recordChange(ctx context.Context, action, notifType, message string, before, after *models.User) (err error) {
+	ctx, span := utils.StartSpan(ctx, "UserService.recordChange", attribute.String("audit.action", action))
+	defer func() { utils.EndSpan(span, err) }()
+
+	user := after
+	if user == nil {
+		user = before
+	}
+
+	now := time.Now().UTC()
+	audit := utils.NewAuditEntry(ctx, action, user.ID)
+	audit.Timestamp = now
+	audit.Changes = utils.Diff(before, after)
+
+	entries := []OutboxEntry{{
+		Kind:      OutboxAudit,
+		UserID:    user.ID,
+		Type:      action,
+		Audit:     &audit,
+		RequestID: audit.RequestID,
+		CreatedAt: now,
+	}}
+	if notifType != "" {
+		entries = append(entries, OutboxEntry{
+			Kind:      OutboxNotification,
+			UserID:    user.ID,
+			Type:      notifType,
+			Message:   message,
+			Email:     user.Email,
+			Name:      user.Name,
+			Locale:    localeFromContext(ctx),
+			RequestID: audit.RequestID,
+			CreatedAt: now,
+		})
+	}
+	return s.outbox.Add(entries...)
+}
+
+// publish sends a change event with copies of before and after, tagged
+// with the request ID of ctx. Callers hold s.mu.
+func (s *UserService) publish(ctx context.Context, eventType UserEventType, before, after *models.User) {
+	event := UserEvent{Type: eventType, RequestID: utils.RequestIDFromContext(ctx)}
+	if before != nil {
+		beforeCopy := *before
+		event.Before = &beforeCopy
+		event.UserID = before.ID
+	}
+	if after != nil {
+		afterCopy := *after
+		event.After = &afterCopy
+		event.UserID = after.ID
+	}
+	s.events.Publish(event)
+}
+
 // Count returns the number of users
 func (s *UserService) Count() int {
 	s.mu.RLock()
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"go-microservice/models"
+	"go-microservice/utils"
+)
+
+// newTestUserService returns an empty UserService with its own event bus
+// and in-memory outbox
+func newTestUserService() *UserService {
+	return &UserService{
+		users:  make(map[int]*models.User),
+		events: NewEventBus(),
+		outbox: NewOutbox(),
+	}
+}
+
+// outboxEntries describes the queued outbox entries as "kind type userID"
+func outboxEntries(s *UserService) []string {
+	var entries []string
+	for _, entry := range s.outbox.due(time.Now()) {
+		entries = append(entries, fmt.Sprintf("%s %s %d", entry.Kind, entry.Type, entry.UserID))
+	}
+	return entries
+}
+
+// newRestoreTestService returns a UserService holding user 5, last
+// updated at updatedAt, with an empty outbox
+func newRestoreTestService(t *testing.T, updatedAt time.Time) *UserService {
+	t.Helper()
+	s := newTestUserService()
+	s.users[5] = &models.User{ID: 5, Name: "Existing", Email: "existing@example.com", CreatedAt: updatedAt, UpdatedAt: updatedAt}
+	s.advanceIDCounter(5)
+	return s
+}
+
+func TestRestoreConflictPolicies(t *testing.T) {
+	now := time.Now().UTC()
+	older, newer := now.Add(-time.Hour), now.Add(time.Hour)
+
+	for _, tc := range []struct {
+		name      string
+		policy    ConflictPolicy
+		updatedAt time.Time
+		outcome   RestoreOutcome
+		err       error
+		wantName  string
+	}{
+		{"skip", ConflictSkip, newer, RestoreSkipped, nil, "Existing"},
+		{"overwrite", ConflictOverwrite, older, RestoreOverwritten, nil, "Restored"},
+		{"fail", ConflictFail, newer, "", ErrUserExists, "Existing"},
+		{"keep-newer with newer backup", ConflictKeepNewer, newer, RestoreOverwritten, nil, "Restored"},
+		{"keep-newer with older backup", ConflictKeepNewer, older, RestoreSkipped, nil, "Existing"},
+		{"keep-newer with same time", ConflictKeepNewer, now, RestoreSkipped, nil, "Existing"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newRestoreTestService(t, now)
+
+			backup := models.User{ID: 5, Name: "Restored", Email: "restored@example.com", CreatedAt: older, UpdatedAt: tc.updatedAt}
+			user, outcome, err := s.Restore(context.Background(), backup, tc.policy)
+			if !errors.Is(err, tc.err) || outcome != tc.outcome {
+				t.Fatalf("Restore = %q, %v; want %q, %v", outcome, err, tc.outcome, tc.err)
+			}
+			if err == nil && user.Name != tc.wantName {
+				t.Errorf("returned user %q, want %q", user.Name, tc.wantName)
+			}
+
+			stored, err := s.GetByID(context.Background(), 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Name != tc.wantName {
+				t.Errorf("stored user %q, want %q", stored.Name, tc.wantName)
+			}
+
+			// Only a restore that changed the user is audited, without a notification
+			var want []string
+			if tc.outcome == RestoreOverwritten {
+				want = []string{"audit RESTORE 5"}
+			}
+			if got := outboxEntries(s); !slices.Equal(got, want) {
+				t.Fatalf("outbox = %v, want %v", got, want)
+			}
+			if want != nil {
+				change := s.outbox.due(time.Now())[0].Audit.Changes["name"]
+				if change.Before != "Existing" || change.After != "Restored" {
+					t.Errorf("audited name change = %+v", change)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreAdvancesIDCounter(t *testing.T) {
+	ctx := context.Background()
+	s := newTestUserService()
+
+	if _, _, err := s.Restore(ctx, models.User{ID: 41, Name: "Restored", Email: "restored@example.com"}, ConflictFail); err != nil {
+		t.Fatal(err)
+	}
+	created, err := s.Create(ctx, models.User{Name: "Created", Email: "created@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != 42 {
+		t.Fatalf("Create after restoring ID 41 assigned ID %d, want 42", created.ID)
+	}
+
+	// Restoring a lower ID does not move the counter back
+	if _, _, err := s.Restore(ctx, models.User{ID: 3, Name: "Lower", Email: "lower@example.com"}, ConflictFail); err != nil {
+		t.Fatal(err)
+	}
+	created, err = s.Create(ctx, models.User{Name: "Next", Email: "next@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != 43 {
+		t.Fatalf("Create assigned ID %d, want 43", created.ID)
+	}
+
+	want := []string{
+		"audit RESTORE 41",
+		"audit CREATE 42", "notification WELCOME 42",
+		"audit RESTORE 3",
+		"audit CREATE 43", "notification WELCOME 43",
+	}
+	if got := outboxEntries(s); !slices.Equal(got, want) {
+		t.Errorf("outbox = %v, want %v", got, want)
+	}
+}
+
+func TestRestoreRejectsInvalidUser(t *testing.T) {
+	ctx := context.Background()
+	s := newTestUserService()
+
+	if _, _, err := s.Restore(ctx, models.User{Name: "No ID", Email: "noid@example.com"}, ConflictFail); err == nil {
+		t.Error("Restore accepted a user without ID")
+	}
+	if _, _, err := s.Restore(ctx, models.User{ID: 1, Name: "Bad", Email: "not-an-email"}, ConflictFail); err == nil {
+		t.Error("Restore accepted an invalid email")
+	}
+	if s.Count() != 0 || s.outbox.Pending() != 0 {
+		t.Errorf("Count() = %d, outbox pending %d after rejected restores", s.Count(), s.outbox.Pending())
+	}
+}
+
+func TestChangesCarryRequestID(t *testing.T) {
+	s := newTestUserService()
+	ctx := utils.WithRequestID(context.Background(), "req-7")
+	_, events, cancel, err := s.Events().Subscribe(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if _, err := s.Create(ctx, models.User{Name: "Ada", Email: "ada@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := s.outbox.due(time.Now())
+	if len(entries) != 2 {
+		t.Fatalf("outbox = %v", outboxEntries(s))
+	}
+	for _, entry := range entries {
+		if entry.RequestID != "req-7" {
+			t.Errorf("%s entry RequestID = %q, want req-7", entry.Kind, entry.RequestID)
+		}
+	}
+	if entries[0].Audit == nil || entries[0].Audit.RequestID != "req-7" {
+		t.Errorf("audit entry = %+v", entries[0].Audit)
+	}
+	if event := <-events; event.RequestID != "req-7" {
+		t.Fatalf("event RequestID = %q, want req-7", event.RequestID)
+	}
+}
@@ -0,0 +1,316 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
+	"go-microservice/metrics"
+	"go-microservice/utils"
+)
+
+// Headers sent with every webhook delivery
+const (
+	WebhookHeaderID        = "X-Webhook-Id"
+	WebhookHeaderEvent     = "X-Webhook-Event"
+	WebhookHeaderTimestamp = "X-Webhook-Timestamp"
+	WebhookHeaderSignature = "X-Webhook-Signature"
+)
+
+// webhookPollInterval is how often the queue is checked for due retries
+const webhookPollInterval = time.Second
+
+// SignWebhookPayload returns the X-Webhook-Signature value for a delivery:
+// "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>" keyed
+// with the subscription secret. Receivers recompute it to authenticate
+// deliveries and reject stale timestamps to prevent replays.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Start loads the persisted state, subscribes to bus and begins delivering.
+// Calling it again restarts the service with the new config.
+func (w *WebhookService) Start(config WebhookConfig, bus *EventBus) error {
+	w.Stop()
+
+	w.mu.Lock()
+	w.config = config
+	w.client = &http.Client{Timeout: config.Timeout}
+	w.mu.Unlock()
+
+	if err := w.load(); err != nil {
+		return err
+	}
+
+	// Subscribe before returning so events published from here on are delivered
+	_, events, unsubscribe, err := bus.Subscribe(0)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	w.mu.Lock()
+	w.stop = cancel
+	w.done = done
+	w.mu.Unlock()
+
+	go func() {
+		defer close(done)
+
+		intakeDone := make(chan struct{})
+		go func() {
+			defer close(intakeDone)
+			w.consume(ctx, bus, events, unsubscribe)
+		}()
+
+		w.dispatch(ctx)
+		<-intakeDone
+		w.workers.Wait()
+	}()
+	return nil
+}
+
+// Stop stops delivering and waits for in-flight attempts to finish.
+// Pending deliveries stay queued in the state file.
+func (w *WebhookService) Stop() {
+	w.mu.Lock()
+	cancel, done := w.stop, w.done
+	w.stop, w.done = nil, nil
+	w.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// consume queues a delivery for each event received on events. When the
+// bus drops this subscriber it resubscribes after the last event it handled.
+func (w *WebhookService) consume(ctx context.Context, bus *EventBus, events <-chan UserEvent, cancel func()) {
+	last := bus.Sequence()
+	handle := func(event UserEvent) {
+		if err := w.enqueue(event); err != nil {
+			slog.Error("Failed to queue webhook deliveries", "sequence", event.Sequence, "error", err)
+		}
+		last = event.Sequence
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			cancel()
+			return
+		case event, ok := <-events:
+			if ok {
+				handle(event)
+				continue
+			}
+		}
+
+		cancel()
+		var backlog []UserEvent
+		var err error
+		backlog, events, cancel, err = bus.Subscribe(last)
+		if errors.Is(err, ErrEventsExpired) {
+			slog.Warn("Webhook intake lost events", "after_sequence", last)
+			backlog, events, cancel, err = bus.Subscribe(0)
+		}
+		if err != nil {
+			slog.Error("Webhook intake failed to resubscribe", "error", err)
+			return
+		}
+		for _, event := range backlog {
+			handle(event)
+		}
+	}
+}
+
+// dispatch attempts due deliveries until ctx is cancelled
+func (w *WebhookService) dispatch(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	slots := make(chan struct{}, max(w.config.Workers, 1))
+	for {
+		for _, id := range w.claimDue(time.Now()) {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				w.release(id)
+				continue
+			}
+
+			w.workers.Add(1)
+			go func(id string) {
+				defer w.workers.Done()
+				defer func() { <-slots }()
+				w.attempt(ctx, id)
+			}(id)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// claimDue marks due pending deliveries as in flight and returns their IDs
+func (w *WebhookService) claimDue(now time.Time) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var due []string
+	for _, d := range w.deliveries {
+		if d.Status == DeliveryPending && !w.inFlight[d.ID] && !d.NextAttemptAt.After(now) {
+			w.inFlight[d.ID] = true
+			due = append(due, d.ID)
+		}
+	}
+	return due
+}
+
+// release clears the in-flight mark of a delivery that was not attempted
+func (w *WebhookService) release(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.inFlight, id)
+}
+
+// attempt sends one delivery and records the result
+func (w *WebhookService) attempt(ctx context.Context, id string) {
+	w.mu.Lock()
+	var delivery *WebhookDelivery
+	for _, d := range w.deliveries {
+		if d.ID == id {
+			delivery = d
+			break
+		}
+	}
+	if delivery == nil {
+		delete(w.inFlight, id)
+		w.mu.Unlock()
+		return
+	}
+	sub, subscribed := w.subscriptions[delivery.SubscriptionID]
+	var target, secret string
+	if subscribed {
+		target, secret = sub.URL, sub.Secret
+	}
+	payload := delivery.Payload
+	eventType := delivery.EventType
+	w.mu.Unlock()
+
+	start := time.Now()
+	record := WebhookAttempt{At: start.UTC()}
+	if !subscribed {
+		record.Error = "subscription removed"
+	} else {
+		record.StatusCode, record.Error = w.send(ctx, target, secret, id, eventType, payload)
+	}
+	record.DurationMs = time.Since(start).Milliseconds()
+
+	// A shutdown interrupting the request does not use up an attempt
+	if ctx.Err() != nil {
+		w.release(id)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.inFlight, id)
+
+	delivery.Attempts = append(delivery.Attempts, record)
+	delivery.UpdatedAt = time.Now().UTC()
+
+	outcome := "retry"
+	switch {
+	case record.Error == "":
+		delivery.Status = DeliverySucceeded
+		delivery.NextAttemptAt = time.Time{}
+		outcome = "success"
+	case !subscribed || len(delivery.Attempts) >= w.config.MaxAttempts:
+		delivery.Status = DeliveryDead
+		delivery.NextAttemptAt = time.Time{}
+		outcome = "dead"
+		slog.Warn("Webhook delivery dead-lettered",
+			"delivery_id", id, "attempts", len(delivery.Attempts), "error", record.Error)
+	default:
+		delivery.NextAttemptAt = delivery.UpdatedAt.Add(w.retryDelay(len(delivery.Attempts)))
+	}
+	metrics.IncrementWebhookDeliveries(outcome)
+
+	if err := w.saveLocked(); err != nil {
+		slog.Error("Failed to persist webhook delivery", "delivery_id", id, "error", err)
+	}
+}
+
+// send posts a signed payload and returns the status code and an error
+// message for anything but a 2xx response
+func (w *WebhookService) send(ctx context.Context, target, secret, deliveryID string, eventType UserEventType, payload []byte) (status int, failure string) {
+	ctx, span := utils.StartSpan(ctx, "webhook deliver",
+		attribute.String("webhook.delivery_id", deliveryID),
+		attribute.String("webhook.event", string(eventType)))
+	defer func() {
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if failure != "" {
+			span.SetStatus(codes.Error, failure)
+		}
+		span.End()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookHeaderID, deliveryID)
+	req.Header.Set(WebhookHeaderEvent, string(eventType))
+	req.Header.Set(WebhookHeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookHeaderSignature, SignWebhookPayload(secret, timestamp, payload))
+	utils.InjectTraceContext(ctx, req.Header)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	// Drain a bounded amount so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, ""
+}
+
+// retryDelay returns the exponential backoff before retry number attempt
+func (w *WebhookService) retryDelay(attempt int) time.Duration {
+	delay := w.config.BaseDelay
+	for i := 1; i < attempt && delay < w.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, w.config.MaxDelay)
+}
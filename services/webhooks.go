@@ -0,0 +1,420 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// webhookHistoryLimit caps the finished deliveries kept for the history
+// endpoints; pending deliveries are never dropped
+const webhookHistoryLimit = 1000
+
+// ErrWebhookNotFound is returned for an unknown subscription or delivery
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// WebhookSubscription sends user events of the listed types to URL.
+// An empty EventTypes list subscribes to every event.
+type WebhookSubscription struct {
+	ID         string          `json:"id"`
+	URL        string          `json:"url"`
+	EventTypes []UserEventType `json:"event_types"`
+	// Secret signs deliveries; it is only returned when the subscription is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookAttempt records one HTTP attempt of a delivery
+type WebhookAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// WebhookDelivery is one event queued for one subscription
+type WebhookDelivery struct {
+	ID             string           `json:"id"`
+	SubscriptionID string           `json:"subscription_id"`
+	EventType      UserEventType    `json:"event_type"`
+	EventSequence  uint64           `json:"event_sequence"`
+	Payload        json.RawMessage  `json:"payload"`
+	Status         string           `json:"status"`
+	Attempts       []WebhookAttempt `json:"attempts"`
+	NextAttemptAt  time.Time        `json:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+}
+
+// WebhookConfig tunes delivery and names the state file
+type WebhookConfig struct {
+	// StateFile persists subscriptions and the delivery queue
+	StateFile   string
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Timeout     time.Duration
+	Workers     int
+}
+
+// LoadWebhookConfig reads the webhook config from the environment:
+// WEBHOOK_STATE_FILE (default ./data/webhooks.json), WEBHOOK_MAX_ATTEMPTS
+// (default 8), WEBHOOK_RETRY_BASE and WEBHOOK_RETRY_MAX (durations,
+// default 2s and 10m), WEBHOOK_TIMEOUT (default 10s) and WEBHOOK_WORKERS
+// (default 4)
+func LoadWebhookConfig() (WebhookConfig, error) {
+	config := WebhookConfig{
+		StateFile:   "./data/webhooks.json",
+		MaxAttempts: 8,
+		BaseDelay:   2 * time.Second,
+		MaxDelay:    10 * time.Minute,
+		Timeout:     10 * time.Second,
+		Workers:     4,
+	}
+
+	if value := os.Getenv("WEBHOOK_STATE_FILE"); value != "" {
+		config.StateFile = value
+	}
+	for name, target := range map[string]*int{
+		"WEBHOOK_MAX_ATTEMPTS": &config.MaxAttempts,
+		"WEBHOOK_WORKERS":      &config.Workers,
+	} {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return config, fmt.Errorf("invalid %s: %q", name, value)
+			}
+			*target = n
+		}
+	}
+	for name, target := range map[string]*time.Duration{
+		"WEBHOOK_RETRY_BASE": &config.BaseDelay,
+		"WEBHOOK_RETRY_MAX":  &config.MaxDelay,
+		"WEBHOOK_TIMEOUT":    &config.Timeout,
+	} {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return config, fmt.Errorf("invalid %s: %q", name, value)
+			}
+			*target = d
+		}
+	}
+	return config, nil
+}
+
+// WebhookService delivers user events to subscribed URLs. Subscriptions
+// and the delivery queue are written to a state file on every change, so
+// pending deliveries survive a restart.
+type WebhookService struct {
+	mu            sync.Mutex
+	config        WebhookConfig
+	client        *http.Client
+	subscriptions map[string]*WebhookSubscription
+	// deliveries is ordered by creation
+	deliveries []*WebhookDelivery
+	inFlight   map[string]bool
+
+	wake    chan struct{}
+	stop    context.CancelFunc
+	done    chan struct{}
+	workers sync.WaitGroup
+}
+
+// webhookState is the on-disk form of the service's state
+type webhookState struct {
+	Subscriptions []*WebhookSubscription `json:"subscriptions"`
+	Deliveries    []*WebhookDelivery     `json:"deliveries"`
+}
+
+var (
+	webhookInstance *WebhookService
+	webhookOnce     sync.Once
+)
+
+// GetWebhookService returns a singleton instance of WebhookService
+func GetWebhookService() *WebhookService {
+	webhookOnce.Do(func() {
+		webhookInstance = NewWebhookService()
+	})
+	return webhookInstance
+}
+
+// NewWebhookService creates a stopped WebhookService; call Start to load
+// its state and begin delivering
+func NewWebhookService() *WebhookService {
+	return &WebhookService{
+		subscriptions: make(map[string]*WebhookSubscription),
+		inFlight:      make(map[string]bool),
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+// Subscribe registers a webhook. A secret is generated when none is given;
+// the returned subscription is the only place it is shown.
+func (w *WebhookService) Subscribe(rawURL string, eventTypes []UserEventType, secret string) (*WebhookSubscription, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", rawURL)
+	}
+	for _, eventType := range eventTypes {
+		switch eventType {
+		case EventUserCreated, EventUserUpdated, EventUserDeleted:
+		default:
+			return nil, fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	if secret == "" {
+		secret = randomID(32)
+	}
+
+	sub := &WebhookSubscription{
+		ID:         randomID(8),
+		URL:        target.String(),
+		EventTypes: eventTypes,
+		Secret:     secret,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscriptions[sub.ID] = sub
+	if err := w.saveLocked(); err != nil {
+		delete(w.subscriptions, sub.ID)
+		return nil, err
+	}
+
+	created := *sub
+	return &created, nil
+}
+
+// Unsubscribe removes a subscription; its pending deliveries are dead-lettered
+// when next attempted
+func (w *WebhookService) Unsubscribe(id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.subscriptions[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(w.subscriptions, id)
+	return w.saveLocked()
+}
+
+// Subscriptions returns all subscriptions without their secrets
+func (w *WebhookService) Subscriptions() []WebhookSubscription {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	subs := make([]WebhookSubscription, 0, len(w.subscriptions))
+	for _, sub := range w.subscriptions {
+		redacted := *sub
+		redacted.Secret = ""
+		subs = append(subs, redacted)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].CreatedAt.Before(subs[j].CreatedAt) })
+	return subs
+}
+
+// Deliveries returns the deliveries of a subscription, newest first,
+// optionally filtered by status. An empty subscriptionID returns all.
+func (w *WebhookService) Deliveries(subscriptionID, status string) []WebhookDelivery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	deliveries := []WebhookDelivery{}
+	for i := len(w.deliveries) - 1; i >= 0; i-- {
+		d := w.deliveries[i]
+		if (subscriptionID == "" || d.SubscriptionID == subscriptionID) && (status == "" || d.Status == status) {
+			deliveries = append(deliveries, *d)
+		}
+	}
+	return deliveries
+}
+
+// Redeliver puts a dead-lettered delivery back in the queue with a fresh
+// attempt budget
+func (w *WebhookService) Redeliver(id string) (*WebhookDelivery, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, d := range w.deliveries {
+		if d.ID != id {
+			continue
+		}
+		if d.Status != DeliveryDead {
+			return nil, fmt.Errorf("delivery %s is %s, only dead deliveries can be retried", id, d.Status)
+		}
+		d.Status = DeliveryPending
+		d.Attempts = nil
+		d.NextAttemptAt = time.Now().UTC()
+		d.UpdatedAt = d.NextAttemptAt
+		if err := w.saveLocked(); err != nil {
+			return nil, err
+		}
+		w.signal()
+
+		redelivered := *d
+		return &redelivered, nil
+	}
+	return nil, ErrWebhookNotFound
+}
+
+// enqueue creates a delivery of event for every matching subscription
+func (w *WebhookService) enqueue(event UserEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now().UTC()
+	queued := 0
+	for _, sub := range w.subscriptions {
+		if !sub.matches(event.Type) {
+			continue
+		}
+		w.deliveries = append(w.deliveries, &WebhookDelivery{
+			ID:             randomID(8),
+			SubscriptionID: sub.ID,
+			EventType:      event.Type,
+			EventSequence:  event.Sequence,
+			Payload:        payload,
+			Status:         DeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		})
+		queued++
+	}
+	if queued == 0 {
+		return nil
+	}
+
+	if err := w.saveLocked(); err != nil {
+		return err
+	}
+	w.signal()
+	return nil
+}
+
+// matches reports whether the subscription wants eventType
+func (s *WebhookSubscription) matches(eventType UserEventType) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// signal wakes the dispatcher without blocking
+func (w *WebhookService) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// load reads the state file; a missing file starts empty
+func (w *WebhookService) load() error {
+	raw, err := os.ReadFile(w.config.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read webhook state: %w", err)
+	}
+
+	var state webhookState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return fmt.Errorf("invalid webhook state %s: %w", w.config.StateFile, err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscriptions = make(map[string]*WebhookSubscription, len(state.Subscriptions))
+	for _, sub := range state.Subscriptions {
+		w.subscriptions[sub.ID] = sub
+	}
+	w.deliveries = state.Deliveries
+	return nil
+}
+
+// saveLocked trims the history and writes the state file. Callers hold w.mu.
+func (w *WebhookService) saveLocked() error {
+	finished := 0
+	for _, d := range w.deliveries {
+		if d.Status != DeliveryPending {
+			finished++
+		}
+	}
+	if excess := finished - webhookHistoryLimit; excess > 0 {
+		kept := w.deliveries[:0]
+		for _, d := range w.deliveries {
+			if excess > 0 && d.Status != DeliveryPending {
+				excess--
+				continue
+			}
+			kept = append(kept, d)
+		}
+		w.deliveries = kept
+	}
+
+	// Without a state file (e.g. in tests) everything stays in memory
+	if w.config.StateFile == "" {
+		return nil
+	}
+
+	state := webhookState{
+		Subscriptions: make([]*WebhookSubscription, 0, len(w.subscriptions)),
+		Deliveries:    w.deliveries,
+	}
+	for _, sub := range w.subscriptions {
+		state.Subscriptions = append(state.Subscriptions, sub)
+	}
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(w.config.StateFile), 0o750); err != nil {
+		return fmt.Errorf("failed to create webhook state directory: %w", err)
+	}
+	if err := writeFileAtomic(w.config.StateFile, raw); err != nil {
+		return fmt.Errorf("failed to write webhook state: %w", err)
+	}
+	return nil
+}
+
+// randomID returns n random bytes, hex encoded
+func randomID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is an httptest receiver answering with status and
+// recording the requests it got
+type webhookReceiver struct {
+	*httptest.Server
+	status   atomic.Int32
+	mu       sync.Mutex
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+func newWebhookReceiver(t *testing.T, status int) *webhookReceiver {
+	t.Helper()
+	r := &webhookReceiver{}
+	r.status.Store(int32(status))
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.requests = append(r.requests, receivedWebhook{header: req.Header.Clone(), body: body, at: time.Now()})
+		r.mu.Unlock()
+		w.WriteHeader(int(r.status.Load()))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) received() []receivedWebhook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedWebhook(nil), r.requests...)
+}
+
+// testWebhookConfig retries quickly and keeps state in memory
+func testWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		MaxAttempts: 3,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    20 * time.Millisecond,
+		Timeout:     time.Second,
+		Workers:     2,
+	}
+}
+
+// waitForDelivery waits until the only delivery of w has status
+func waitForDelivery(t *testing.T, w *WebhookService, status string) WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if deliveries := w.Deliveries("", status); len(deliveries) == 1 {
+			return deliveries[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("no %s delivery; deliveries: %+v", status, w.Deliveries("", ""))
+	return WebhookDelivery{}
+}
+
+func TestWebhookDeliverySignature(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusNoContent)
+	bus := NewEventBus()
+	w := NewWebhookService()
+	if err := w.Start(testWebhookConfig(), bus); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Stop)
+
+	sub, err := w.Subscribe(receiver.URL, []UserEventType{EventUserCreated}, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish(UserEvent{Type: EventUserDeleted, UserID: 1})
+	bus.Publish(UserEvent{Type: EventUserCreated, UserID: 1})
+
+	delivery := waitForDelivery(t, w, DeliverySucceeded)
+	if delivery.SubscriptionID != sub.ID || delivery.EventType != EventUserCreated {
+		t.Fatalf("delivery = %+v", delivery)
+	}
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	timestamp, err := strconv.ParseInt(req.header.Get(WebhookHeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("bad %s header: %v", WebhookHeaderTimestamp, err)
+	}
+	if got, want := req.header.Get(WebhookHeaderSignature), SignWebhookPayload("s3cret", timestamp, req.body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if got := req.header.Get(WebhookHeaderSignature); got == SignWebhookPayload("other", timestamp, req.body) {
+		t.Error("signature does not depend on the secret")
+	}
+	if req.header.Get(WebhookHeaderID) != delivery.ID || req.header.Get(WebhookHeaderEvent) != string(EventUserCreated) {
+		t.Errorf("headers = %v", req.header)
+	}
+}
+
+func TestWebhookRetriesUntilDeadAndRedeliver(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError)
+	bus := NewEventBus()
+	config := testWebhookConfig()
+	w := NewWebhookService()
+	if err := w.Start(config, bus); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Stop)
+
+	if _, err := w.Subscribe(receiver.URL, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish(UserEvent{Type: EventUserUpdated, UserID: 2})
+
+	dead := waitForDelivery(t, w, DeliveryDead)
+	if len(dead.Attempts) != config.MaxAttempts {
+		t.Fatalf("dead after %d attempts, want %d", len(dead.Attempts), config.MaxAttempts)
+	}
+	for _, attempt := range dead.Attempts {
+		if attempt.StatusCode != http.StatusInternalServerError || attempt.Error == "" {
+			t.Errorf("attempt = %+v", attempt)
+		}
+	}
+	requests := receiver.received()
+	if len(requests) != config.MaxAttempts {
+		t.Fatalf("receiver got %d requests, want %d", len(requests), config.MaxAttempts)
+	}
+	for i := 1; i < len(requests); i++ {
+		if gap := requests[i].at.Sub(requests[i-1].at); gap < w.retryDelay(i) {
+			t.Errorf("retry %d after %s, want at least %s", i, gap, w.retryDelay(i))
+		}
+	}
+
+	if _, err := w.Redeliver("missing"); err != ErrWebhookNotFound {
+		t.Errorf("Redeliver(missing) = %v, want ErrWebhookNotFound", err)
+	}
+	receiver.status.Store(http.StatusOK)
+	redelivered, err := w.Redeliver(dead.ID)
+	if err != nil {
+		t.Fatalf("Redeliver: %v", err)
+	}
+	if redelivered.Status != DeliveryPending || len(redelivered.Attempts) != 0 {
+		t.Fatalf("redelivered = %+v", redelivered)
+	}
+
+	succeeded := waitForDelivery(t, w, DeliverySucceeded)
+	if succeeded.ID != dead.ID || len(succeeded.Attempts) != 1 {
+		t.Fatalf("succeeded = %+v", succeeded)
+	}
+	if _, err := w.Redeliver(dead.ID); err == nil {
+		t.Error("Redeliver of a succeeded delivery did not fail")
+	}
+}
+
+func TestWebhookPendingDeliveriesSurviveRestart(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusOK)
+	config := testWebhookConfig()
+	config.StateFile = filepath.Join(t.TempDir(), "webhooks.json")
+
+	// Queue a delivery without starting the dispatcher, as if the
+	// process stopped before attempting it
+	before := NewWebhookService()
+	before.config = config
+	sub, err := before.Subscribe(receiver.URL, nil, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := before.enqueue(UserEvent{Sequence: 1, Type: EventUserCreated, UserID: 3}); err != nil {
+		t.Fatal(err)
+	}
+	pending := before.Deliveries("", DeliveryPending)
+	if len(pending) != 1 {
+		t.Fatalf("pending = %+v", pending)
+	}
+
+	after := NewWebhookService()
+	if err := after.Start(config, NewEventBus()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(after.Stop)
+
+	delivered := waitForDelivery(t, after, DeliverySucceeded)
+	if delivered.ID != pending[0].ID || delivered.SubscriptionID != sub.ID {
+		t.Fatalf("delivered = %+v, want %+v", delivered, pending[0])
+	}
+	requests := receiver.received()
+	if len(requests) != 1 || requests[0].header.Get(WebhookHeaderSignature) == "" {
+		t.Fatalf("receiver got %+v", requests)
+	}
+}
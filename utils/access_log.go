@@ -0,0 +1,287 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Access log formats selectable with ACCESS_LOG_FORMAT
+const (
+	// AccessLogCombined is the Apache Combined Log Format followed by
+	// latency, route and request ID fields
+	AccessLogCombined = "combined"
+	// AccessLogJSON writes one JSON object per request
+	AccessLogJSON = "json"
+	// AccessLogOff disables the access log
+	AccessLogOff = "off"
+)
+
+// AccessLogConfig configures the access log
+type AccessLogConfig struct {
+	Format string
+	// File is appended to; empty writes to stdout
+	File string
+	// SuccessSampleRate is the fraction of requests answered with a status
+	// below 400 that are logged. Failed requests are always logged.
+	SuccessSampleRate float64
+}
+
+// LoadAccessLogConfig reads the access log settings: ACCESS_LOG_FORMAT
+// (combined, json or off; default combined), ACCESS_LOG_FILE (default
+// stdout) and ACCESS_LOG_SUCCESS_SAMPLE (0 to 1; default 1)
+func LoadAccessLogConfig() (AccessLogConfig, error) {
+	config := AccessLogConfig{
+		Format:            AccessLogCombined,
+		File:              os.Getenv("ACCESS_LOG_FILE"),
+		SuccessSampleRate: 1,
+	}
+
+	switch format := strings.ToLower(os.Getenv("ACCESS_LOG_FORMAT")); format {
+	case "", AccessLogCombined:
+	case AccessLogJSON, AccessLogOff:
+		config.Format = format
+	default:
+		return config, fmt.Errorf("invalid ACCESS_LOG_FORMAT: %q", format)
+	}
+	if value := os.Getenv("ACCESS_LOG_SUCCESS_SAMPLE"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return config, fmt.Errorf("invalid ACCESS_LOG_SUCCESS_SAMPLE: %q", value)
+		}
+		config.SuccessSampleRate = rate
+	}
+	return config, nil
+}
+
+// AccessLogEntry is one logged request
+type AccessLogEntry struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	// Route is the route template, e.g. /api/users/{id:[0-9]+}
+	Route string `json:"route"`
+	// URI is the request target as sent by the client
+	URI        string  `json:"uri"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+	ClientIP   string  `json:"client_ip"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+	Referer    string  `json:"referer,omitempty"`
+	RequestID  string  `json:"request_id,omitempty"`
+	TraceID    string  `json:"trace_id,omitempty"`
+}
+
+// AccessLogger writes one line per request asynchronously
+type AccessLogger struct {
+	pipeline *Pipeline[AccessLogEntry]
+	mu       sync.RWMutex
+	config   AccessLogConfig
+	out      io.Writer
+	// file is the opened ACCESS_LOG_FILE, nil for stdout
+	file *os.File
+}
+
+var (
+	accessLogger     *AccessLogger
+	accessLoggerOnce sync.Once
+)
+
+// GetAccessLogger returns a singleton instance of AccessLogger, writing
+// Combined lines to stdout until configured. Lines are written in batches
+// configured by the ACCESS_LOG_ pipeline variables; when the queue is full
+// new lines are dropped unless ACCESS_LOG_OVERFLOW says otherwise.
+func GetAccessLogger() *AccessLogger {
+	accessLoggerOnce.Do(func() {
+		accessLogger = &AccessLogger{
+			config: AccessLogConfig{Format: AccessLogCombined, SuccessSampleRate: 1},
+			out:    os.Stdout,
+		}
+		accessLogger.pipeline = NewPipeline(LoadPipelineConfig("ACCESS_LOG", PipelineConfig{
+			Name:          "access",
+			Capacity:      10000,
+			BatchSize:     100,
+			FlushInterval: 10 * time.Millisecond,
+			Policy:        OverflowDropNewest,
+			BlockTimeout:  time.Second,
+		}), accessLogger.write)
+	})
+	return accessLogger
+}
+
+// Configure applies config, opening its file and closing the previous one
+func (a *AccessLogger) Configure(config AccessLogConfig) error {
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if config.File != "" && config.Format != AccessLogOff {
+		var err error
+		file, err = os.OpenFile(config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+		if err != nil {
+			return fmt.Errorf("failed to open access log: %w", err)
+		}
+		out = file
+	}
+
+	a.mu.Lock()
+	previous := a.file
+	a.config, a.out, a.file = config, out, file
+	a.mu.Unlock()
+
+	if previous != nil {
+		return previous.Close()
+	}
+	return nil
+}
+
+// Enabled reports whether requests are logged at all
+func (a *AccessLogger) Enabled() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.config.Format != AccessLogOff
+}
+
+// sampled decides whether a request answered with status is logged
+func (a *AccessLogger) sampled(status int) bool {
+	if status >= http.StatusBadRequest {
+		return true
+	}
+	a.mu.RLock()
+	rate := a.config.SuccessSampleRate
+	a.mu.RUnlock()
+	return rate >= 1 || rand.Float64() < rate
+}
+
+// Log queues entry for writing
+func (a *AccessLogger) Log(entry AccessLogEntry) {
+	a.pipeline.Submit(entry)
+}
+
+// write formats a batch and writes it with a single call
+func (a *AccessLogger) write(batch []AccessLogEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.config.Format == AccessLogOff {
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range batch {
+		switch a.config.Format {
+		case AccessLogJSON:
+			line, err := json.Marshal(entry)
+			if err != nil {
+				continue
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+		default:
+			writeCombined(&buf, entry)
+		}
+	}
+	if _, err := a.out.Write(buf.Bytes()); err != nil {
+		slog.Error("Failed to write access log", "count", len(batch), "error", err)
+	}
+}
+
+// writeCombined appends entry in the Combined Log Format, followed by the
+// latency in seconds, the route template and the request ID. Quoted
+// fields are escaped so client-supplied values cannot break the line.
+func writeCombined(buf *bytes.Buffer, entry AccessLogEntry) {
+	size := "-"
+	if entry.Bytes > 0 {
+		size = strconv.Itoa(entry.Bytes)
+	}
+	referer := entry.Referer
+	if referer == "" {
+		referer = "-"
+	}
+	userAgent := entry.UserAgent
+	if userAgent == "" {
+		userAgent = "-"
+	}
+	requestID := entry.RequestID
+	if requestID == "" {
+		requestID = "-"
+	}
+	fmt.Fprintf(buf, "%s - - [%s] %s %d %s %s %s %.6f %s %s\n",
+		entry.ClientIP,
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(entry.Method+" "+entry.URI+" "+entry.Proto),
+		entry.Status,
+		size,
+		strconv.Quote(referer),
+		strconv.Quote(userAgent),
+		entry.DurationMs/1000,
+		strconv.Quote(entry.Route),
+		requestID)
+}
+
+// Close waits for queued lines to be written, or ctx to be done, and
+// closes the log file
+func (a *AccessLogger) Close(ctx context.Context) error {
+	err := a.pipeline.Close(ctx)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		if closeErr := a.file.Close(); err == nil {
+			err = closeErr
+		}
+		// Lines logged after Close are written synchronously to stdout
+		a.out, a.file = os.Stdout, nil
+	}
+	return err
+}
+
+// AccessLogMiddleware logs every failed request and a sample of the
+// successful ones to the AccessLogger. It goes after TracingMiddleware so
+// lines carry the trace ID.
+func AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := GetAccessLogger()
+		if r.URL.Path == "/metrics" || !logger.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		recorder := newStatusRecorder(w)
+		next.ServeHTTP(recorder, r)
+		duration := time.Since(start)
+
+		if !logger.sampled(recorder.Status()) {
+			return
+		}
+		entry := AccessLogEntry{
+			Time:       start,
+			Method:     r.Method,
+			Route:      RouteTemplate(r),
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Status:     recorder.Status(),
+			Bytes:      recorder.BytesWritten(),
+			DurationMs: float64(duration.Microseconds()) / 1000,
+			ClientIP:   ClientIP(r),
+			UserAgent:  r.UserAgent(),
+			Referer:    r.Referer(),
+			RequestID:  RequestIDFromContext(r.Context()),
+		}
+		if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+			entry.TraceID = span.TraceID().String()
+		}
+		logger.Log(entry)
+	})
+}
@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// useAccessLog points the access logger at a file in a temporary
+// directory for the duration of the test and returns its path
+func useAccessLog(t *testing.T, config AccessLogConfig) string {
+	t.Helper()
+	config.File = filepath.Join(t.TempDir(), "access.log")
+	if err := GetAccessLogger().Configure(config); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		GetAccessLogger().Configure(AccessLogConfig{Format: AccessLogCombined, SuccessSampleRate: 1})
+	})
+	return config.File
+}
+
+// newAccessLogRouter serves the status given in the status query
+// parameter on a templated route, behind the access log middleware
+func newAccessLogRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.Use(RequestIDMiddleware)
+	router.Use(TracingMiddleware)
+	router.Use(AccessLogMiddleware)
+	router.HandleFunc("/api/users/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		switch r.URL.Query().Get("status") {
+		case "201":
+			status = http.StatusCreated
+		case "404":
+			status = http.StatusNotFound
+		case "500":
+			status = http.StatusInternalServerError
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("hello"))
+	})
+	router.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("# metrics\n"))
+	})
+	return router
+}
+
+// readAccessLog waits until path holds at least want lines and returns them
+func readAccessLog(t *testing.T, path string, want int) []string {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if len(data) == 0 {
+			lines = nil
+		}
+		if len(lines) >= want {
+			return lines
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("access log has %d lines, want %d: %q", len(lines), want, data)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// serveAccessLog sends a GET for target through router
+func serveAccessLog(router http.Handler, target string, header map[string]string) {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	router.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	path := useAccessLog(t, AccessLogConfig{Format: AccessLogCombined, SuccessSampleRate: 1})
+
+	serveAccessLog(newAccessLogRouter(), "/api/users/7?status=201", map[string]string{
+		"User-Agent":   `agent "quoted"`,
+		"Referer":      "https://example.com/",
+		"X-Request-ID": "req-1",
+	})
+
+	lines := readAccessLog(t, path, 1)
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1: %q", len(lines), lines)
+	}
+	line := lines[0]
+	if !strings.HasPrefix(line, "192.0.2.1 - - [") {
+		t.Errorf("line does not start with the client and time: %s", line)
+	}
+	want := `] "GET /api/users/7?status=201 HTTP/1.1" 201 5 "https://example.com/" "agent \"quoted\"" `
+	if !strings.Contains(line, want) {
+		t.Errorf("line = %s, want it to contain %s", line, want)
+	}
+	if !strings.HasSuffix(line, ` "/api/users/{id:[0-9]+}" req-1`) {
+		t.Errorf("line does not end with the route and request ID: %s", line)
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	useInMemoryTracing(t)
+	path := useAccessLog(t, AccessLogConfig{Format: AccessLogJSON, SuccessSampleRate: 1})
+
+	serveAccessLog(newAccessLogRouter(), "/api/users/7?status=404", map[string]string{
+		"X-Request-ID": "req-2",
+		"traceparent":  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	})
+
+	lines := readAccessLog(t, path, 1)
+	var entry AccessLogEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("line is not JSON: %v: %s", err, lines[0])
+	}
+	if entry.Method != http.MethodGet || entry.Route != "/api/users/{id:[0-9]+}" || entry.URI != "/api/users/7?status=404" {
+		t.Errorf("request = %s %s (%s)", entry.Method, entry.URI, entry.Route)
+	}
+	if entry.Status != http.StatusNotFound || entry.Bytes != 5 {
+		t.Errorf("status = %d, bytes = %d", entry.Status, entry.Bytes)
+	}
+	if entry.ClientIP != "192.0.2.1" || entry.RequestID != "req-2" {
+		t.Errorf("client = %s, request ID = %s", entry.ClientIP, entry.RequestID)
+	}
+	if entry.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID = %s", entry.TraceID)
+	}
+	if entry.Time.IsZero() || entry.DurationMs < 0 {
+		t.Errorf("time = %v, duration = %v", entry.Time, entry.DurationMs)
+	}
+}
+
+func TestAccessLogSkipsMetrics(t *testing.T) {
+	path := useAccessLog(t, AccessLogConfig{Format: AccessLogCombined, SuccessSampleRate: 1})
+	router := newAccessLogRouter()
+
+	serveAccessLog(router, "/metrics", nil)
+	// Lines are written in order, so once this one is out /metrics was skipped
+	serveAccessLog(router, "/api/users/1", nil)
+
+	lines := readAccessLog(t, path, 1)
+	if len(lines) != 1 || !strings.Contains(lines[0], `"GET /api/users/1 HTTP/1.1"`) {
+		t.Errorf("lines = %q", lines)
+	}
+}
+
+func TestAccessLogSamplesSuccessfulRequests(t *testing.T) {
+	path := useAccessLog(t, AccessLogConfig{Format: AccessLogJSON, SuccessSampleRate: 0})
+	router := newAccessLogRouter()
+
+	for _, status := range []string{"200", "201", "404", "200", "500"} {
+		serveAccessLog(router, "/api/users/1?status="+status, nil)
+	}
+
+	lines := readAccessLog(t, path, 2)
+	var statuses []int
+	for _, line := range lines {
+		var entry AccessLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, entry.Status)
+	}
+	if len(statuses) != 2 || statuses[0] != http.StatusNotFound || statuses[1] != http.StatusInternalServerError {
+		t.Errorf("logged statuses = %v, want only the failures", statuses)
+	}
+}
+
+func TestAccessLogSampleRate(t *testing.T) {
+	logger := &AccessLogger{config: AccessLogConfig{Format: AccessLogCombined, SuccessSampleRate: 0.25}}
+
+	const requests = 10000
+	sampled := 0
+	for i := 0; i < requests; i++ {
+		if logger.sampled(http.StatusOK) {
+			sampled++
+		}
+		if !logger.sampled(http.StatusBadRequest) {
+			t.Fatal("a failed request was not logged")
+		}
+	}
+	if sampled < requests/5 || sampled > requests*3/10 {
+		t.Errorf("sampled %d of %d successful requests at rate 0.25", sampled, requests)
+	}
+}
+
+func TestLoadAccessLogConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		format  string
+		sample  string
+		want    AccessLogConfig
+		wantErr bool
+	}{
+		{"defaults", "", "", AccessLogConfig{Format: AccessLogCombined, SuccessSampleRate: 1}, false},
+		{"json", "JSON", "0.1", AccessLogConfig{Format: AccessLogJSON, SuccessSampleRate: 0.1}, false},
+		{"off", "off", "", AccessLogConfig{Format: AccessLogOff, SuccessSampleRate: 1}, false},
+		{"unknown format", "xml", "", AccessLogConfig{}, true},
+		{"sample above 1", "", "1.5", AccessLogConfig{}, true},
+		{"sample not a number", "", "half", AccessLogConfig{}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("ACCESS_LOG_FORMAT", tc.format)
+			t.Setenv("ACCESS_LOG_SUCCESS_SAMPLE", tc.sample)
+			t.Setenv("ACCESS_LOG_FILE", "")
+			config, err := LoadAccessLogConfig()
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want an error", config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config != tc.want {
+				t.Errorf("config = %+v, want %+v", config, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"go-microservice/metrics"
+)
+
+// LogConfig configures the application logger
+type LogConfig struct {
+	Level slog.Level
+	// JSON selects JSON output instead of key=value text
+	JSON bool
+	// Within each SampleInterval, the first SampleFirst records with the
+	// same level and message are logged, then every SampleThereafter-th.
+	// A zero SampleInterval logs everything.
+	SampleInterval   time.Duration
+	SampleFirst      int
+	SampleThereafter int
+}
+
+// LoadLogConfig reads the application logger settings: LOG_LEVEL (debug,
+// info, warn or error; default info), LOG_FORMAT (text or json; default
+// text), LOG_SAMPLE_INTERVAL (default 1s, 0 disables sampling),
+// LOG_SAMPLE_FIRST (default 10) and LOG_SAMPLE_THEREAFTER (default 100)
+func LoadLogConfig() (LogConfig, error) {
+	config := LogConfig{
+		Level:            slog.LevelInfo,
+		SampleInterval:   time.Second,
+		SampleFirst:      10,
+		SampleThereafter: 100,
+	}
+
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := config.Level.UnmarshalText([]byte(value)); err != nil {
+			return config, fmt.Errorf("invalid LOG_LEVEL: %q", value)
+		}
+	}
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "text":
+	case "json":
+		config.JSON = true
+	default:
+		return config, fmt.Errorf("invalid LOG_FORMAT: %q", format)
+	}
+
+	if value := os.Getenv("LOG_SAMPLE_INTERVAL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return config, fmt.Errorf("invalid LOG_SAMPLE_INTERVAL: %q", value)
+		}
+		config.SampleInterval = d
+	}
+	var err error
+	if config.SampleFirst, err = envInt("LOG_SAMPLE_FIRST", config.SampleFirst, 0); err != nil {
+		return config, err
+	}
+	if config.SampleThereafter, err = envInt("LOG_SAMPLE_THEREAFTER", config.SampleThereafter, 0); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// NewLogHandler creates the application log handler writing to w. Records
+// logged with a request context carry its request attributes.
+func NewLogHandler(config LogConfig, w io.Writer) slog.Handler {
+	options := &slog.HandlerOptions{Level: config.Level}
+	var handler slog.Handler
+	if config.JSON {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+
+	if config.SampleInterval > 0 {
+		handler = &samplingHandler{
+			Handler: handler,
+			sampler: &logSampler{
+				interval:   config.SampleInterval,
+				first:      config.SampleFirst,
+				thereafter: config.SampleThereafter,
+				counts:     make(map[string]int),
+			},
+		}
+	}
+	return &contextHandler{Handler: handler}
+}
+
+// ConfigureLogging makes a logger built from config the slog default. The
+// standard log package writes through it too, at info level.
+func ConfigureLogging(config LogConfig) {
+	slog.SetDefault(slog.New(NewLogHandler(config, os.Stderr)))
+}
+
+// logAttrsKey is the context key for request-scoped log attributes
+type logAttrsKey struct{}
+
+// WithLogAttrs returns a context whose log records carry attrs in
+// addition to any the parent carries
+func WithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	parent, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	combined := make([]slog.Attr, 0, len(parent)+len(attrs))
+	combined = append(append(combined, parent...), attrs...)
+	return context.WithValue(ctx, logAttrsKey{}, combined)
+}
+
+// LogAttrsFromContext returns the request-scoped attributes of ctx: the
+// request ID, the trace and span IDs of the current span, the client IP
+// and actor set by AuditContextMiddleware, and any added with WithLogAttrs
+func LogAttrsFromContext(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	var attrs []slog.Attr
+	if id := RequestIDFromContext(ctx); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		attrs = append(attrs,
+			slog.String("trace_id", span.TraceID().String()),
+			slog.String("span_id", span.SpanID().String()))
+	}
+	if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+		attrs = append(attrs, slog.String("client_ip", info.ClientIP), slog.String("actor", info.Actor))
+	}
+	extra, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	return append(attrs, extra...)
+}
+
+// LogContextMiddleware adds the request method and path to the log
+// attributes of the request context. It goes after AuditContextMiddleware.
+func LogContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithLogAttrs(r.Context(),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// contextHandler adds the request-scoped attributes of the context a
+// record is logged with
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle implements slog.Handler
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs := LogAttrsFromContext(ctx); len(attrs) > 0 {
+		record = record.Clone()
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs implements slog.Handler
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// samplingHandler drops repetitive records as decided by its sampler,
+// which is shared with the handlers derived from it
+type samplingHandler struct {
+	slog.Handler
+	sampler *logSampler
+}
+
+// Handle implements slog.Handler
+func (h *samplingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if !h.sampler.allow(record) {
+		metrics.IncrementLogsSampled(record.Level.String())
+		return nil
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs implements slog.Handler
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithAttrs(attrs), sampler: h.sampler}
+}
+
+// WithGroup implements slog.Handler
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithGroup(name), sampler: h.sampler}
+}
+
+// logSampler counts records by level and message per interval
+type logSampler struct {
+	interval   time.Duration
+	first      int
+	thereafter int
+
+	mu     sync.Mutex
+	window time.Time
+	counts map[string]int
+}
+
+// allow reports whether record should be logged
+func (s *logSampler) allow(record slog.Record) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if window := time.Now().Truncate(s.interval); !window.Equal(s.window) {
+		s.window = window
+		clear(s.counts)
+	}
+	key := record.Level.String() + "|" + record.Message
+	s.counts[key]++
+	n := s.counts[key]
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
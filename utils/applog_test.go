@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogSampling(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewLogHandler(LogConfig{
+		Level:            slog.LevelInfo,
+		SampleInterval:   time.Hour,
+		SampleFirst:      3,
+		SampleThereafter: 5,
+	}, &buf))
+
+	for i := 1; i <= 20; i++ {
+		logger.Info("repeated", "n", i)
+		logger.Warn("repeated", "n", i)
+	}
+	logger.Info("other")
+
+	var info, warn []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		n := line[strings.LastIndex(line, "n=")+2:]
+		switch {
+		case strings.Contains(line, "msg=other"):
+		case strings.Contains(line, "level=INFO"):
+			info = append(info, n)
+		case strings.Contains(line, "level=WARN"):
+			warn = append(warn, n)
+		}
+	}
+	// The first 3, then every 5th after them; levels are sampled apart
+	want := "[1 2 3 8 13 18]"
+	if got := strings.Join(info, " "); "["+got+"]" != want {
+		t.Errorf("info records %v, want %s", info, want)
+	}
+	if got := strings.Join(warn, " "); "["+got+"]" != want {
+		t.Errorf("warn records %v, want %s", warn, want)
+	}
+	if !strings.Contains(buf.String(), "msg=other") {
+		t.Error("a different message was sampled away")
+	}
+}
+
+func TestLogSamplerResetsEachWindow(t *testing.T) {
+	sampler := &logSampler{interval: time.Hour, first: 1, counts: make(map[string]int)}
+	record := slog.NewRecord(time.Now(), slog.LevelInfo, "repeated", 0)
+
+	if !sampler.allow(record) || sampler.allow(record) {
+		t.Fatal("want only the first record in a window")
+	}
+	// A new window starts counting again
+	sampler.window = sampler.window.Add(-time.Hour)
+	if !sampler.allow(record) {
+		t.Fatal("first record of a new window dropped")
+	}
+}
+
+func TestLogSamplingDisabled(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewLogHandler(LogConfig{Level: slog.LevelDebug}, &buf))
+	for i := 0; i < 50; i++ {
+		logger.Debug("repeated")
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 50 {
+		t.Fatalf("logged %d records, want 50", n)
+	}
+}
+
+func TestLogHandlerAddsContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewLogHandler(LogConfig{Level: slog.LevelInfo, JSON: true}, &buf))
+
+	ctx := WithLogAttrs(context.Background(), slog.String("method", "GET"))
+	ctx = WithLogAttrs(ctx, slog.String("path", "/api/users"))
+	logger.InfoContext(ctx, "handled")
+	logger.Debug("below level")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("%v: %s", err, buf.String())
+	}
+	if record["msg"] != "handled" || record["method"] != "GET" || record["path"] != "/api/users" {
+		t.Fatalf("record = %v", record)
+	}
+}
+
+func TestLoadLogConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		env     map[string]string
+		want    LogConfig
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			want: LogConfig{Level: slog.LevelInfo, SampleInterval: time.Second, SampleFirst: 10, SampleThereafter: 100},
+		},
+		{
+			name: "configured",
+			env: map[string]string{
+				"LOG_LEVEL": "debug", "LOG_FORMAT": "JSON", "LOG_SAMPLE_INTERVAL": "0",
+				"LOG_SAMPLE_FIRST": "1", "LOG_SAMPLE_THEREAFTER": "0",
+			},
+			want: LogConfig{Level: slog.LevelDebug, JSON: true, SampleFirst: 1},
+		},
+		{name: "bad level", env: map[string]string{"LOG_LEVEL": "loud"}, wantErr: true},
+		{name: "bad format", env: map[string]string{"LOG_FORMAT": "xml"}, wantErr: true},
+		{name: "negative interval", env: map[string]string{"LOG_SAMPLE_INTERVAL": "-1s"}, wantErr: true},
+		{name: "bad first", env: map[string]string{"LOG_SAMPLE_FIRST": "x"}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, name := range []string{"LOG_LEVEL", "LOG_FORMAT", "LOG_SAMPLE_INTERVAL", "LOG_SAMPLE_FIRST", "LOG_SAMPLE_THEREAFTER"} {
+				t.Setenv(name, tc.env[name])
+			}
+			config, err := LoadLogConfig()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && config != tc.want {
+				t.Fatalf("config = %+v, want %+v", config, tc.want)
+			}
+		})
+	}
+}
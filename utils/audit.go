@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Audit outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Audit actors recorded when no request supplies one
+const (
+	ActorSystem    = "system"
+	ActorAnonymous = "anonymous"
+	ActorAdmin     = "admin"
+)
+
+// FieldChange is the before and after value of one changed field.
+// A nil side means the object did not exist.
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Diff returns the JSON fields that differ between before and after,
+// either of which may be nil. Both are compared in their JSON form, so
+// the keys match what API clients see.
+func Diff(before, after interface{}) map[string]FieldChange {
+	beforeFields := jsonFields(before)
+	afterFields := jsonFields(after)
+
+	keys := make([]string, 0, len(beforeFields)+len(afterFields))
+	for key := range beforeFields {
+		keys = append(keys, key)
+	}
+	for key := range afterFields {
+		if _, ok := beforeFields[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := make(map[string]FieldChange)
+	for _, key := range keys {
+		b, a := beforeFields[key], afterFields[key]
+		if !reflect.DeepEqual(b, a) {
+			changes[key] = FieldChange{Before: b, After: a}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// jsonFields returns the top-level fields of v's JSON object form
+func jsonFields(v interface{}) map[string]interface{} {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil()) {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+// RequestInfo identifies the request behind an audit entry
+type RequestInfo struct {
+	RequestID string
+	ClientIP  string
+	Actor     string
+}
+
+// requestInfoKey is the context key for *RequestInfo
+type requestInfoKey struct{}
+
+// AuditContextMiddleware records the request ID, client IP and an
+// anonymous actor in the request context for audit entries. The request
+// ID is the one set by RequestIDMiddleware, which goes before it.
+func AuditContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &RequestInfo{
+			RequestID: RequestIDFromContext(r.Context()),
+			ClientIP:  ClientIP(r),
+			Actor:     ActorAnonymous,
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+	})
+}
+
+// ClientIP returns the host part of the request's remote address
+func ClientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// RequestInfoFromContext returns a copy of the request details stored by
+// AuditContextMiddleware; outside a request the actor is ActorSystem
+func RequestInfoFromContext(ctx context.Context) RequestInfo {
+	if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+		return *info
+	}
+	return RequestInfo{Actor: ActorSystem}
+}
+
+// SetAuditActor names the authenticated caller of the request in ctx.
+// It must be called before the request's audit entries are logged.
+func SetAuditActor(ctx context.Context, actor string) {
+	if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+		info.Actor = actor
+	}
+}
+
+// NewAuditEntry returns an entry for action on userID carrying the
+// request details in ctx, timestamped now with a successful outcome
+func NewAuditEntry(ctx context.Context, action string, userID int) LogEntry {
+	info := RequestInfoFromContext(ctx)
+	if info.RequestID == "" {
+		// Work done for a request outside its handler
+		info.RequestID = RequestIDFromContext(ctx)
+	}
+	return LogEntry{
+		Timestamp: time.Now().UTC(),
+		Action:    action,
+		Actor:     info.Actor,
+		UserID:    userID,
+		RequestID: info.RequestID,
+		ClientIP:  info.ClientIP,
+		Outcome:   OutcomeSuccess,
+	}
+}
+
+// LogRequestAction logs an action taken while handling the request in ctx
+func LogRequestAction(ctx context.Context, action string, userID int, outcome, details string) {
+	entry := NewAuditEntry(ctx, action, userID)
+	entry.Outcome = outcome
+	entry.Details = details
+	GetAuditLogger().Log(entry)
+}
@@ -0,0 +1,418 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// genesisHash is the previous hash of the first entry in a chain
+var genesisHash = strings.Repeat("0", sha256.Size*2)
+
+// DefaultAuditChainFile is where the chain sink writes unless
+// AUDIT_CHAIN_FILE says otherwise
+const DefaultAuditChainFile = "./data/audit-chain.jsonl"
+
+// AuditCheckpoint is a signed statement of the chain head at Seq. Because
+// only the holder of the signing key can produce one, rewriting the chain
+// and recomputing its hashes is still detected.
+type AuditCheckpoint struct {
+	Seq  uint64    `json:"seq"`
+	Hash string    `json:"hash"`
+	Time time.Time `json:"time"`
+	// Signature is the base64 Ed25519 signature of checkpointMessage
+	Signature string `json:"signature"`
+}
+
+// checkpointMessage returns the bytes a checkpoint signature covers
+func checkpointMessage(seq uint64, hash string, at time.Time) []byte {
+	return []byte(fmt.Sprintf("audit-checkpoint:%d:%s:%d", seq, hash, at.UnixNano()))
+}
+
+// hashEntry returns the chain hash of entry: the hex SHA-256 of its JSON
+// form with Hash left empty. PrevHash is part of that form, which links
+// each entry to the one before it.
+func hashEntry(entry LogEntry) (string, error) {
+	entry.Hash = ""
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ChainSink appends entries to a hash-chained, append-only file. Each
+// entry gets the next sequence number, the hash of the entry before it and
+// its own hash. Every checkpointEvery entries, and on Close, the chain
+// head is signed and appended to <path>.checkpoints.
+type ChainSink struct {
+	path            string
+	key             ed25519.PrivateKey
+	checkpointEvery uint64
+
+	file        *os.File
+	checkpoints *os.File
+	// size is the length of the chain file after the last complete write
+	size     int64
+	seq      uint64
+	lastHash string
+	unsigned uint64
+}
+
+// NewChainSink opens the chain at path, continuing after its last entry
+func NewChainSink(path string, key ed25519.PrivateKey, checkpointEvery int) (*ChainSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create audit chain directory: %w", err)
+	}
+
+	s := &ChainSink{
+		path:            path,
+		key:             key,
+		checkpointEvery: uint64(max(checkpointEvery, 1)),
+		lastHash:        genesisHash,
+	}
+	if err := s.resume(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if s.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640); err != nil {
+		return nil, fmt.Errorf("failed to open audit chain: %w", err)
+	}
+	if s.checkpoints, err = os.OpenFile(path+".checkpoints", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640); err != nil {
+		s.file.Close()
+		return nil, fmt.Errorf("failed to open audit checkpoints: %w", err)
+	}
+	return s, nil
+}
+
+// resume reads the sequence number and hash of the last entry. A final
+// line cut short by a crash is truncated so the chain continues cleanly.
+func (s *ChainSink) resume() error {
+	file, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read audit chain: %w", err)
+	}
+	defer file.Close()
+
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				slog.Warn("Truncating incomplete last audit chain entry", "path", s.path, "offset", offset)
+				if err := file.Truncate(offset); err != nil {
+					return fmt.Errorf("failed to truncate audit chain: %w", err)
+				}
+			}
+			s.size = offset
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read audit chain: %w", err)
+		}
+		offset += int64(len(line))
+
+		var entry LogEntry
+		if json.Unmarshal(line, &entry) == nil && entry.Seq > 0 {
+			s.seq = entry.Seq
+			s.lastHash = entry.Hash
+		}
+	}
+}
+
+// Write chains copies of entries and appends them with a single write.
+// A failed write is truncated away, so the entries can be written again
+// without leaving a broken line in the chain.
+func (s *ChainSink) Write(entries []LogEntry) error {
+	seq, lastHash := s.seq, s.lastHash
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		seq++
+		entry.Seq = seq
+		entry.PrevHash = lastHash
+		hash, err := hashEntry(entry)
+		if err != nil {
+			return err
+		}
+		entry.Hash = hash
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+		lastHash = hash
+	}
+
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		if truncErr := os.Truncate(s.path, s.size); truncErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to truncate partial audit chain write: %w", truncErr))
+		}
+		return err
+	}
+	s.size += int64(buf.Len())
+	s.unsigned += seq - s.seq
+	s.seq, s.lastHash = seq, lastHash
+
+	if s.unsigned >= s.checkpointEvery {
+		return s.checkpoint()
+	}
+	return nil
+}
+
+// checkpoint syncs the chain and appends a signed checkpoint of its head
+func (s *ChainSink) checkpoint() error {
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+
+	cp := AuditCheckpoint{Seq: s.seq, Hash: s.lastHash, Time: time.Now().UTC()}
+	cp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, checkpointMessage(cp.Seq, cp.Hash, cp.Time)))
+	line, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if _, err := s.checkpoints.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := s.checkpoints.Sync(); err != nil {
+		return err
+	}
+	s.unsigned = 0
+	return nil
+}
+
+// Close signs the entries written since the last checkpoint and closes
+// the files
+func (s *ChainSink) Close() error {
+	var errs []error
+	if s.unsigned > 0 {
+		errs = append(errs, s.checkpoint())
+	}
+	errs = append(errs, s.file.Close(), s.checkpoints.Close())
+	return errors.Join(errs...)
+}
+
+// ChainBreak locates the first entry that fails verification
+type ChainBreak struct {
+	Seq uint64 `json:"seq"`
+	// Line is the line number in the chain file, 0 for checkpoint problems
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// ChainReport is the result of VerifyAuditChain
+type ChainReport struct {
+	Valid bool `json:"valid"`
+	// Entries is the number of entries verified before any break
+	Entries  int    `json:"entries"`
+	LastSeq  uint64 `json:"last_seq"`
+	LastHash string `json:"last_hash,omitempty"`
+	// Checkpoints is the number of signed checkpoints matched against
+	// the chain
+	Checkpoints int         `json:"checkpoints"`
+	Break       *ChainBreak `json:"first_break,omitempty"`
+}
+
+// VerifyAuditChain walks the chain at path, checking sequence numbers,
+// links and hashes, and that every checkpoint is signed by key and agrees
+// with the chain. It stops at the first problem. A final line without a
+// newline is an entry still being written and is not checked.
+func VerifyAuditChain(path string, key ed25519.PublicKey) (*ChainReport, error) {
+	checkpoints, report, err := readCheckpoints(path+".checkpoints", key)
+	if err != nil || report.Break != nil {
+		return report, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lastHash := genesisHash
+	lineNo := 0
+	fail := func(seq uint64, reason string) (*ChainReport, error) {
+		report.Break = &ChainBreak{Seq: seq, Line: lineNo, Reason: reason}
+		return report, nil
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit chain: %w", err)
+		}
+		lineNo++
+		expected := report.LastSeq + 1
+
+		var entry LogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fail(expected, "line is not a valid audit entry")
+		}
+		if entry.Seq != expected {
+			return fail(expected, fmt.Sprintf("expected sequence %d, found %d", expected, entry.Seq))
+		}
+		if entry.PrevHash != lastHash {
+			return fail(entry.Seq, "previous hash does not match the preceding entry")
+		}
+		hash, err := hashEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if hash != entry.Hash {
+			return fail(entry.Seq, "entry hash does not match its contents")
+		}
+		if cp, ok := checkpoints[entry.Seq]; ok {
+			if cp.Hash != entry.Hash {
+				return fail(entry.Seq, "entry does not match the signed checkpoint")
+			}
+			report.Checkpoints++
+		}
+
+		report.Entries++
+		report.LastSeq = entry.Seq
+		report.LastHash = entry.Hash
+		lastHash = entry.Hash
+	}
+
+	var signedSeq uint64
+	for seq := range checkpoints {
+		signedSeq = max(signedSeq, seq)
+	}
+	if signedSeq > report.LastSeq {
+		lineNo = 0
+		return fail(report.LastSeq+1,
+			fmt.Sprintf("chain ends at sequence %d but a checkpoint covers %d; entries were removed", report.LastSeq, signedSeq))
+	}
+
+	report.Valid = true
+	return report, nil
+}
+
+// readCheckpoints loads the checkpoints at path keyed by sequence number,
+// returning a report with a break for the first one not signed by key
+func readCheckpoints(path string, key ed25519.PublicKey) (map[uint64]AuditCheckpoint, *ChainReport, error) {
+	report := &ChainReport{}
+	checkpoints := make(map[uint64]AuditCheckpoint)
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoints, report, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read audit checkpoints: %w", err)
+	}
+
+	lines := bytes.Split(raw, []byte("\n"))
+	// The last element is empty or a checkpoint still being written
+	for _, line := range lines[:len(lines)-1] {
+		var cp AuditCheckpoint
+		if err := json.Unmarshal(line, &cp); err != nil {
+			report.Break = &ChainBreak{Reason: "checkpoint is not valid JSON"}
+			return nil, report, nil
+		}
+		signature, err := base64.StdEncoding.DecodeString(cp.Signature)
+		if err != nil || !ed25519.Verify(key, checkpointMessage(cp.Seq, cp.Hash, cp.Time), signature) {
+			report.Break = &ChainBreak{Seq: cp.Seq, Reason: fmt.Sprintf("checkpoint at sequence %d has an invalid signature", cp.Seq)}
+			return nil, report, nil
+		}
+		checkpoints[cp.Seq] = cp
+	}
+	return checkpoints, report, nil
+}
+
+// AuditChainFile returns the chain path from AUDIT_CHAIN_FILE
+func AuditChainFile() string {
+	if path := os.Getenv("AUDIT_CHAIN_FILE"); path != "" {
+		return path
+	}
+	return DefaultAuditChainFile
+}
+
+// LoadAuditSigningKey reads the checkpoint signing key from
+// AUDIT_SIGNING_KEY: a base64 Ed25519 seed (32 bytes) or private key
+// (64 bytes)
+func LoadAuditSigningKey() (ed25519.PrivateKey, error) {
+	encoded := os.Getenv("AUDIT_SIGNING_KEY")
+	if encoded == "" {
+		return nil, errors.New("AUDIT_SIGNING_KEY is required for the chain audit sink")
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("AUDIT_SIGNING_KEY is not valid base64")
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	default:
+		return nil, fmt.Errorf("AUDIT_SIGNING_KEY must be %d or %d bytes, got %d",
+			ed25519.SeedSize, ed25519.PrivateKeySize, len(raw))
+	}
+}
+
+// LoadAuditVerifyKey returns the public key checkpoints are verified
+// with: AUDIT_VERIFY_KEY (base64) when set, so verification does not need
+// the private key, otherwise the public half of AUDIT_SIGNING_KEY
+func LoadAuditVerifyKey() (ed25519.PublicKey, error) {
+	if encoded := os.Getenv("AUDIT_VERIFY_KEY"); encoded != "" {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, errors.New("AUDIT_VERIFY_KEY is not a base64 Ed25519 public key")
+		}
+		return ed25519.PublicKey(raw), nil
+	}
+
+	key, err := LoadAuditSigningKey()
+	if err != nil {
+		return nil, errors.New("AUDIT_VERIFY_KEY or AUDIT_SIGNING_KEY is required to verify the audit chain")
+	}
+	return key.Public().(ed25519.PublicKey), nil
+}
+
+// newChainSinkFromEnv creates the chain sink configured by
+// AUDIT_CHAIN_FILE, AUDIT_SIGNING_KEY and AUDIT_CHECKPOINT_EVERY
+// (default 1000 entries)
+func newChainSinkFromEnv() (*ChainSink, error) {
+	key, err := LoadAuditSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	every := 1000
+	if value := os.Getenv("AUDIT_CHECKPOINT_EVERY"); value != "" {
+		if every, err = strconv.Atoi(value); err != nil || every <= 0 {
+			return nil, fmt.Errorf("invalid AUDIT_CHECKPOINT_EVERY: %q", value)
+		}
+	}
+
+	sink, err := NewChainSink(AuditChainFile(), key, every)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Audit checkpoints are signed",
+		"public_key", base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+	return sink, nil
+}
@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSigningKey returns a deterministic Ed25519 key derived from b
+func testSigningKey(b byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{b}, ed25519.SeedSize))
+}
+
+// auditEntries returns n entries for consecutive users
+func auditEntries(n int) []LogEntry {
+	entries := make([]LogEntry, n)
+	for i := range entries {
+		entries[i] = LogEntry{
+			Timestamp: time.Date(2026, 1, 1, 0, 0, i, 0, time.UTC),
+			Action:    "UPDATE",
+			Actor:     ActorAdmin,
+			UserID:    i + 1,
+			Outcome:   OutcomeSuccess,
+		}
+	}
+	return entries
+}
+
+// writeChain writes n entries to a new chain in a temp dir, one write
+// per entry, with a checkpoint every checkpointEvery entries
+func writeChain(t *testing.T, n, checkpointEvery int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit-chain.jsonl")
+	sink, err := NewChainSink(path, testSigningKey(1), checkpointEvery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range auditEntries(n) {
+		if err := sink.Write([]LogEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// editLines rewrites the chain file at path through edit
+func editLines(t *testing.T, path string, edit func(lines []string) []string) {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(raw), "\n")
+	if err := os.WriteFile(path, []byte(strings.Join(edit(lines), "")), 0o640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func verifyChain(t *testing.T, path string, key ed25519.PrivateKey) *ChainReport {
+	t.Helper()
+	report, err := VerifyAuditChain(path, key.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestVerifyAuditChainIntact(t *testing.T) {
+	path := writeChain(t, 5, 2)
+
+	report := verifyChain(t, path, testSigningKey(1))
+	// Checkpoints at 2 and 4, and at 5 on Close
+	if !report.Valid || report.Entries != 5 || report.LastSeq != 5 || report.Checkpoints != 3 || report.Break != nil {
+		t.Fatalf("report = %+v", report)
+	}
+}
+
+func TestVerifyAuditChainDetectsTampering(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		edit   func(lines []string) []string
+		seq    uint64
+		line   int
+		reason string
+	}{
+		{
+			name: "edited entry",
+			edit: func(lines []string) []string {
+				lines[2] = strings.Replace(lines[2], `"target_user_id":3`, `"target_user_id":33`, 1)
+				return lines
+			},
+			seq: 3, line: 3, reason: "entry hash does not match",
+		},
+		{
+			name: "deleted middle line",
+			edit: func(lines []string) []string {
+				return append(lines[:1:1], lines[2:]...)
+			},
+			seq: 2, line: 2, reason: "expected sequence 2, found 3",
+		},
+		{
+			name: "entries truncated after a checkpoint",
+			edit: func(lines []string) []string {
+				return lines[:3]
+			},
+			seq: 4, line: 0, reason: "entries were removed",
+		},
+		{
+			name: "garbage line",
+			edit: func(lines []string) []string {
+				lines[1] = "not json\n"
+				return lines
+			},
+			seq: 2, line: 2, reason: "not a valid audit entry",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeChain(t, 5, 2)
+			editLines(t, path, tc.edit)
+
+			report := verifyChain(t, path, testSigningKey(1))
+			if report.Valid || report.Break == nil {
+				t.Fatalf("tampered chain verified: %+v", report)
+			}
+			if report.Break.Seq != tc.seq || report.Break.Line != tc.line || !strings.Contains(report.Break.Reason, tc.reason) {
+				t.Fatalf("break = %+v, want seq %d line %d %q", report.Break, tc.seq, tc.line, tc.reason)
+			}
+		})
+	}
+}
+
+func TestVerifyAuditChainDetectsRehashedChain(t *testing.T) {
+	path := writeChain(t, 4, 2)
+
+	// Rewrite entry 2 and recompute every hash after it, as an attacker
+	// without the signing key could
+	editLines(t, path, func(lines []string) []string {
+		var out []string
+		prev := genesisHash
+		for i, line := range lines[:4] {
+			var entry LogEntry
+			if err := jsonUnmarshal(line, &entry); err != nil {
+				t.Fatal(err)
+			}
+			if i == 1 {
+				entry.Details = "rewritten"
+			}
+			entry.PrevHash = prev
+			hash, err := hashEntry(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry.Hash = hash
+			prev = hash
+			out = append(out, jsonLine(t, entry))
+		}
+		return out
+	})
+
+	report := verifyChain(t, path, testSigningKey(1))
+	if report.Valid || report.Break == nil || report.Break.Seq != 2 || !strings.Contains(report.Break.Reason, "signed checkpoint") {
+		t.Fatalf("report = %+v", report)
+	}
+}
+
+func TestVerifyAuditChainWrongKey(t *testing.T) {
+	path := writeChain(t, 3, 2)
+
+	report := verifyChain(t, path, testSigningKey(2))
+	if report.Valid || report.Break == nil || report.Break.Seq != 2 || report.Break.Line != 0 ||
+		!strings.Contains(report.Break.Reason, "invalid signature") {
+		t.Fatalf("report = %+v", report)
+	}
+}
+
+func TestChainSinkResumesAfterTornWrite(t *testing.T) {
+	path := writeChain(t, 3, 10)
+
+	// A crash cut the next entry short
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(file, `{"time":"2026-01-01T00:00:00Z","action":"UPD`)
+	file.Close()
+
+	sink, err := NewChainSink(path, testSigningKey(1), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(auditEntries(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	report := verifyChain(t, path, testSigningKey(1))
+	if !report.Valid || report.Entries != 5 || report.LastSeq != 5 {
+		t.Fatalf("report = %+v", report)
+	}
+}
+
+func TestChainSinkTruncatesFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit-chain.jsonl")
+	sink, err := NewChainSink(path, testSigningKey(1), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(auditEntries(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Part of a write reached the file before the write failed
+	partial, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(partial, `{"time":"2026-01-01T00:00:00Z","act`)
+	partial.Close()
+	writable := sink.file
+	if sink.file, err = os.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(auditEntries(1)); err == nil {
+		t.Fatal("write to a read-only file succeeded")
+	}
+	sink.file.Close()
+	sink.file = writable
+
+	// The failed entries are written again with the same sequence numbers
+	if err := sink.Write(auditEntries(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	report := verifyChain(t, path, testSigningKey(1))
+	if !report.Valid || report.Entries != 3 || report.LastSeq != 3 {
+		t.Fatalf("report = %+v, break %+v", report, report.Break)
+	}
+}
+
+func jsonUnmarshal(line string, v interface{}) error {
+	return json.Unmarshal([]byte(line), v)
+}
+
+func jsonLine(t *testing.T, v interface{}) string {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw) + "\n"
+}
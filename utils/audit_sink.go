@@ -0,0 +1,425 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditSink stores audit entries. AuditLogger calls Write with one batch
+// at a time; the entries of each user are in the order they were logged.
+type AuditSink interface {
+	// Write stores a batch of entries
+	Write(entries []LogEntry) error
+	// Close flushes anything buffered and releases the sink
+	Close() error
+}
+
+// encodeEntries renders entries as JSON lines
+func encodeEntries(entries []LogEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// WriterSink writes entries as JSON lines to an io.Writer
+type WriterSink struct {
+	w io.Writer
+}
+
+// NewWriterSink creates a WriterSink writing to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// NewStdoutSink creates a sink writing JSON lines to standard output
+func NewStdoutSink() *WriterSink {
+	return NewWriterSink(os.Stdout)
+}
+
+// Write writes entries with a single call to the writer
+func (s *WriterSink) Write(entries []LogEntry) error {
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(data)
+	return err
+}
+
+// Close does nothing; the writer belongs to the caller
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// RotatingFileSink appends JSON lines to a file, renaming it to
+// <path>.1 once it would exceed maxSize and shifting older files up to
+// <path>.<maxBackups>. The oldest file beyond that is removed.
+type RotatingFileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+// NewRotatingFileSink opens path for appending, creating its directory
+func NewRotatingFileSink(path string, maxSize int64, maxBackups int) (*RotatingFileSink, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid audit file size limit %d", maxSize)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	sink := &RotatingFileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// open opens the current file and records its size
+func (s *RotatingFileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// Write appends entries, rotating first if they would not fit. A batch
+// larger than the limit still goes into a single file.
+func (s *RotatingFileSink) Write(entries []LogEntry) error {
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.size > 0 && s.size+int64(len(data)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	return err
+}
+
+// rotate shifts the backups, moves the current file to <path>.1 and
+// starts a new one
+func (s *RotatingFileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	if s.maxBackups <= 0 {
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return s.open()
+	}
+
+	os.Remove(s.backupName(s.maxBackups))
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(s.backupName(i), s.backupName(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, s.backupName(1)); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// backupName returns the name of rotated file number i
+func (s *RotatingFileSink) backupName(i int) string {
+	return s.path + "." + strconv.Itoa(i)
+}
+
+// Close closes the current file
+func (s *RotatingFileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// HTTPBatchSink posts entries to a collector as a JSON array, in batches
+// of up to batchSize or every interval, whichever comes first. Batches
+// that fail are kept and resent on the next tick; beyond maxBuffered
+// entries the oldest are dropped.
+type HTTPBatchSink struct {
+	url         string
+	batchSize   int
+	interval    time.Duration
+	maxBuffered int
+	client      *http.Client
+
+	mu      sync.Mutex
+	pending []LogEntry
+	dropped int
+	// retryAt holds back size-triggered sends after a failure, so an
+	// unreachable collector does not stall every Write
+	retryAt time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewHTTPBatchSink creates an HTTPBatchSink and starts its flush timer
+func NewHTTPBatchSink(url string, batchSize int, interval time.Duration) *HTTPBatchSink {
+	s := &HTTPBatchSink{
+		url:         url,
+		batchSize:   batchSize,
+		interval:    interval,
+		maxBuffered: batchSize * 100,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				if err := s.flush(); err != nil {
+					slog.Error("Failed to send audit batch", "error", err)
+				}
+			}
+		}
+	}()
+	return s
+}
+
+// Write buffers entries and sends a batch once enough have accumulated
+func (s *HTTPBatchSink) Write(entries []LogEntry) error {
+	s.mu.Lock()
+	s.pending = append(s.pending, entries...)
+	if excess := len(s.pending) - s.maxBuffered; excess > 0 {
+		s.pending = append(s.pending[:0], s.pending[excess:]...)
+		s.dropped += excess
+	}
+	full := len(s.pending) >= s.batchSize && !time.Now().Before(s.retryAt)
+	s.mu.Unlock()
+
+	if full {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush sends buffered entries in batches until the buffer is empty or a
+// request fails
+func (s *HTTPBatchSink) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dropped > 0 {
+		slog.Warn("Dropped audit entries while the collector was unavailable", "dropped", s.dropped)
+		s.dropped = 0
+	}
+	for len(s.pending) > 0 {
+		batch := s.pending[:min(len(s.pending), s.batchSize)]
+		if err := s.send(batch); err != nil {
+			s.retryAt = time.Now().Add(s.interval)
+			return err
+		}
+		s.pending = s.pending[len(batch):]
+	}
+	s.pending = nil
+	return nil
+}
+
+// send posts one batch and expects a 2xx response
+func (s *HTTPBatchSink) send(batch []LogEntry) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close stops the timer and sends what is left
+func (s *HTTPBatchSink) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.flush()
+}
+
+// multiSink writes every batch to several sinks
+type multiSink []AuditSink
+
+// Write writes entries to each sink, returning their combined errors
+func (m multiSink) Write(entries []LogEntry) error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.Write(entries); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes each sink
+func (m multiSink) Close() error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LoadAuditSink builds the audit sink from the environment and seeds the
+// AuditStore from the first file sink's existing entries. AUDIT_SINKS
+// lists the sinks to write to (default "stdout"):
+//
+//	stdout  JSON lines on standard output
+//	file    AUDIT_FILE, rotated at AUDIT_FILE_MAX_MB (default 100) keeping
+//	        AUDIT_FILE_BACKUPS old files (default 5)
+//	http    AUDIT_HTTP_URL, sent in batches of AUDIT_HTTP_BATCH (default
+//	        100) at least every AUDIT_HTTP_INTERVAL (default 5s)
+//	chain   hash-chained AUDIT_CHAIN_FILE (default ./data/audit-chain.jsonl)
+//	        with a checkpoint signed by AUDIT_SIGNING_KEY every
+//	        AUDIT_CHECKPOINT_EVERY entries (default 1000)
+func LoadAuditSink() (AuditSink, error) {
+	names := os.Getenv("AUDIT_SINKS")
+	if names == "" {
+		names = "stdout"
+	}
+
+	var sinks multiSink
+	fail := func(err error) (AuditSink, error) {
+		sinks.Close()
+		return nil, err
+	}
+	seeded := false
+	seed := func(path string) {
+		if seeded {
+			return
+		}
+		seeded = true
+		if err := GetAuditStore().LoadFile(path); err != nil {
+			slog.Error("Failed to load audit history", "path", path, "error", err)
+		}
+	}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "stdout":
+			sinks = append(sinks, NewStdoutSink())
+		case "file":
+			path := os.Getenv("AUDIT_FILE")
+			if path == "" {
+				return fail(errors.New("AUDIT_FILE is required for the file audit sink"))
+			}
+			maxMB, err := envInt("AUDIT_FILE_MAX_MB", 100, 1)
+			if err != nil {
+				return fail(err)
+			}
+			backups, err := envInt("AUDIT_FILE_BACKUPS", 5, 0)
+			if err != nil {
+				return fail(err)
+			}
+			seed(path)
+			sink, err := NewRotatingFileSink(path, int64(maxMB)<<20, backups)
+			if err != nil {
+				return fail(err)
+			}
+			sinks = append(sinks, sink)
+		case "http":
+			url := os.Getenv("AUDIT_HTTP_URL")
+			if url == "" {
+				return fail(errors.New("AUDIT_HTTP_URL is required for the http audit sink"))
+			}
+			batch, err := envInt("AUDIT_HTTP_BATCH", 100, 1)
+			if err != nil {
+				return fail(err)
+			}
+			interval := 5 * time.Second
+			if value := os.Getenv("AUDIT_HTTP_INTERVAL"); value != "" {
+				if interval, err = time.ParseDuration(value); err != nil || interval <= 0 {
+					return fail(fmt.Errorf("invalid AUDIT_HTTP_INTERVAL: %q", value))
+				}
+			}
+			sinks = append(sinks, NewHTTPBatchSink(url, batch, interval))
+		case "chain":
+			seed(AuditChainFile())
+			sink, err := newChainSinkFromEnv()
+			if err != nil {
+				return fail(err)
+			}
+			sinks = append(sinks, sink)
+		default:
+			return fail(fmt.Errorf("unknown audit sink %q", name))
+		}
+	}
+
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return sinks, nil
+}
+
+// envInt reads an integer of at least minimum from the environment
+func envInt(name string, fallback, minimum int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < minimum {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return n, nil
+}
@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrInvalidCursor is returned for a cursor not produced by AuditStore
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// AuditFilter selects audit entries; zero fields match everything
+type AuditFilter struct {
+	UserID  int
+	Action  string
+	Actor   string
+	Outcome string
+	// RequestID selects the entries of one request
+	RequestID string
+	// Since and Until bound the entry time, inclusive and exclusive
+	Since time.Time
+	Until time.Time
+}
+
+// Match reports whether entry passes the filter
+func (f AuditFilter) Match(entry LogEntry) bool {
+	switch {
+	case f.UserID != 0 && entry.UserID != f.UserID:
+		return false
+	case f.Action != "" && entry.Action != f.Action:
+		return false
+	case f.Actor != "" && entry.Actor != f.Actor:
+		return false
+	case f.Outcome != "" && entry.Outcome != f.Outcome:
+		return false
+	case f.RequestID != "" && entry.RequestID != f.RequestID:
+		return false
+	case !f.Since.IsZero() && entry.Timestamp.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !entry.Timestamp.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+// AuditStore keeps recent audit entries in memory for queries. It is fed
+// by AuditLogger alongside its sink and keeps at most maxEntries entries,
+// none older than maxAge when that is set.
+type AuditStore struct {
+	mu      sync.RWMutex
+	entries []LogEntry
+	// first is the position of entries[0]; positions only grow, so they
+	// stay valid as cursors while older entries are trimmed
+	first      uint64
+	maxEntries int
+	maxAge     time.Duration
+}
+
+var (
+	auditStore     *AuditStore
+	auditStoreOnce sync.Once
+)
+
+// GetAuditStore returns a singleton instance of AuditStore, sized by
+// AUDIT_RETENTION (entries, default 100000) and AUDIT_RETENTION_AGE
+// (duration, default unlimited)
+func GetAuditStore() *AuditStore {
+	auditStoreOnce.Do(func() {
+		maxEntries, err := envInt("AUDIT_RETENTION", 100000, 1)
+		if err != nil {
+			slog.Warn("Ignoring invalid setting", "error", err)
+			maxEntries = 100000
+		}
+		var maxAge time.Duration
+		if value := os.Getenv("AUDIT_RETENTION_AGE"); value != "" {
+			if maxAge, err = time.ParseDuration(value); err != nil || maxAge < 0 {
+				slog.Warn("Ignoring invalid setting", "name", "AUDIT_RETENTION_AGE", "value", value)
+				maxAge = 0
+			}
+		}
+		auditStore = NewAuditStore(maxEntries, maxAge)
+	})
+	return auditStore
+}
+
+// NewAuditStore creates an empty AuditStore
+func NewAuditStore(maxEntries int, maxAge time.Duration) *AuditStore {
+	return &AuditStore{maxEntries: maxEntries, maxAge: maxAge}
+}
+
+// Add appends entries and trims those past retention
+func (s *AuditStore) Add(entries []LogEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = append(s.entries, entries...)
+
+	drop := max(len(s.entries)-s.maxEntries, 0)
+	if s.maxAge > 0 {
+		cutoff := time.Now().Add(-s.maxAge)
+		for drop < len(s.entries) && s.entries[drop].Timestamp.Before(cutoff) {
+			drop++
+		}
+	}
+	if drop > 0 {
+		// Reslicing keeps this cheap; append copies the live entries to a
+		// new array once the old one is used up
+		s.entries = s.entries[drop:]
+		s.first += uint64(drop)
+	}
+}
+
+// LoadFile adds the entries in a JSON lines audit file, such as one
+// written by RotatingFileSink or ChainSink, so history survives restarts.
+// A missing file and unparseable lines are skipped.
+func (s *AuditStore) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer file.Close()
+
+	batch := make([]LogEntry, 0, auditBatchSize)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		var entry LogEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || entry.Action == "" {
+			continue
+		}
+		batch = append(batch, entry)
+		if len(batch) == cap(batch) {
+			s.Add(batch)
+			batch = batch[:0]
+		}
+	}
+	s.Add(batch)
+	return scanner.Err()
+}
+
+// Len returns the number of retained entries
+func (s *AuditStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.entries)
+}
+
+// Query returns up to limit entries matching filter, newest first,
+// starting after cursor (empty for the newest). nextCursor is empty once
+// no older entries remain.
+func (s *AuditStore) Query(filter AuditFilter, cursor string, limit int) (entries []LogEntry, nextCursor string, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	end := s.first + uint64(len(s.entries))
+	if cursor != "" {
+		if end, err = decodeCursor(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+	if end <= s.first {
+		return nil, "", nil
+	}
+	end = min(end, s.first+uint64(len(s.entries)))
+
+	for i := int(end-s.first) - 1; i >= 0; i-- {
+		if !filter.Match(s.entries[i]) {
+			continue
+		}
+		if len(entries) == limit {
+			return entries, encodeCursor(s.first + uint64(i) + 1), nil
+		}
+		entries = append(entries, s.entries[i])
+	}
+	return entries, "", nil
+}
+
+// encodeCursor turns a position into an opaque cursor
+func encodeCursor(position uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(position, 10)))
+}
+
+// decodeCursor reverses encodeCursor
+func decodeCursor(cursor string) (uint64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	position, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return position, nil
+}
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var storeEpoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// storeEntry returns an entry for user at storeEpoch plus minute minutes
+func storeEntry(minute, user int, action, outcome string) LogEntry {
+	return LogEntry{
+		Timestamp: storeEpoch.Add(time.Duration(minute) * time.Minute),
+		Action:    action,
+		Actor:     ActorAdmin,
+		UserID:    user,
+		Outcome:   outcome,
+	}
+}
+
+// queryAll follows cursors until the store reports no older entries,
+// returning the user IDs of the entries seen
+func queryAll(t *testing.T, s *AuditStore, filter AuditFilter, limit int) []int {
+	t.Helper()
+	var ids []int
+	cursor := ""
+	for {
+		entries, next, err := s.Query(filter, cursor, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			ids = append(ids, entry.UserID)
+		}
+		if next == "" {
+			return ids
+		}
+		cursor = next
+	}
+}
+
+func equalIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAuditStoreQueryFilters(t *testing.T) {
+	s := NewAuditStore(100, 0)
+	s.Add([]LogEntry{
+		storeEntry(0, 1, "CREATE", OutcomeSuccess),
+		storeEntry(1, 2, "CREATE", OutcomeSuccess),
+		storeEntry(2, 1, "UPDATE", OutcomeFailure),
+		storeEntry(3, 3, "DELETE", OutcomeSuccess),
+	})
+	s.Add([]LogEntry{{Timestamp: storeEpoch.Add(4 * time.Minute), Action: "LIST_USERS", Actor: ActorAnonymous, Outcome: OutcomeSuccess}})
+
+	for _, tc := range []struct {
+		name   string
+		filter AuditFilter
+		want   []int
+	}{
+		{"everything newest first", AuditFilter{}, []int{0, 3, 1, 2, 1}},
+		{"user", AuditFilter{UserID: 1}, []int{1, 1}},
+		{"action", AuditFilter{Action: "CREATE"}, []int{2, 1}},
+		{"actor", AuditFilter{Actor: ActorAnonymous}, []int{0}},
+		{"outcome", AuditFilter{Outcome: OutcomeFailure}, []int{1}},
+		{"since is inclusive", AuditFilter{Since: storeEpoch.Add(3 * time.Minute)}, []int{0, 3}},
+		{"until is exclusive", AuditFilter{Until: storeEpoch.Add(2 * time.Minute)}, []int{2, 1}},
+		{"combined", AuditFilter{UserID: 1, Action: "UPDATE"}, []int{1}},
+		{"no match", AuditFilter{UserID: 9}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := queryAll(t, s, tc.filter, 2); !equalIDs(got, tc.want) {
+				t.Fatalf("users = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuditStoreCursorSurvivesTrimming(t *testing.T) {
+	s := NewAuditStore(5, 0)
+	for i := 1; i <= 5; i++ {
+		s.Add([]LogEntry{storeEntry(i, i, "UPDATE", OutcomeSuccess)})
+	}
+
+	entries, cursor, err := s.Query(AuditFilter{}, "", 2)
+	if err != nil || len(entries) != 2 || entries[0].UserID != 5 || cursor == "" {
+		t.Fatalf("first page = %v, %q, %v", entries, cursor, err)
+	}
+
+	// Two newer entries push users 1 and 2 out of retention; the cursor
+	// still continues after user 4
+	s.Add([]LogEntry{storeEntry(6, 6, "UPDATE", OutcomeSuccess), storeEntry(7, 7, "UPDATE", OutcomeSuccess)})
+	if s.Len() != 5 {
+		t.Fatalf("Len = %d, want 5", s.Len())
+	}
+	entries, next, err := s.Query(AuditFilter{}, cursor, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].UserID != 3 || next != "" {
+		t.Fatalf("second page = %v, next %q, want only user 3", entries, next)
+	}
+
+	// A cursor from before everything retained returns nothing
+	s.Add([]LogEntry{
+		storeEntry(8, 8, "UPDATE", OutcomeSuccess),
+		storeEntry(9, 9, "UPDATE", OutcomeSuccess),
+		storeEntry(10, 10, "UPDATE", OutcomeSuccess),
+	})
+	if entries, next, err := s.Query(AuditFilter{}, cursor, 10); err != nil || len(entries) != 0 || next != "" {
+		t.Fatalf("trimmed page = %v, %q, %v", entries, next, err)
+	}
+}
+
+func TestAuditStoreTrimsByAge(t *testing.T) {
+	s := NewAuditStore(100, time.Hour)
+	now := time.Now()
+	s.Add([]LogEntry{
+		{Timestamp: now.Add(-2 * time.Hour), Action: "OLD", UserID: 1},
+		{Timestamp: now.Add(-time.Minute), Action: "NEW", UserID: 2},
+	})
+	if got := queryAll(t, s, AuditFilter{}, 10); !equalIDs(got, []int{2}) {
+		t.Fatalf("users = %v, want [2]", got)
+	}
+}
+
+func TestAuditStoreInvalidCursor(t *testing.T) {
+	s := NewAuditStore(10, 0)
+	for _, cursor := range []string{"!!!", encodeCursor(1)[:1], "YWJj"} {
+		if _, _, err := s.Query(AuditFilter{}, cursor, 10); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Query(%q) = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func TestAuditStoreLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	data, err := encodeEntries([]LogEntry{
+		storeEntry(0, 1, "CREATE", OutcomeSuccess),
+		storeEntry(1, 2, "CREATE", OutcomeSuccess),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, "not json\n{}\n"...)
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewAuditStore(10, 0)
+	if err := s.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryAll(t, s, AuditFilter{}, 10); !equalIDs(got, []int{2, 1}) {
+		t.Fatalf("users = %v, want [2 1]", got)
+	}
+	if err := s.LoadFile(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil {
+		t.Fatalf("missing file: %v", err)
+	}
+}
+
+// failingSink rejects every write
+type failingSink struct{}
+
+func (failingSink) Write([]LogEntry) error { return errors.New("sink unavailable") }
+func (failingSink) Close() error           { return nil }
+
+func TestAuditLoggerStoresOnlyWrittenEntries(t *testing.T) {
+	a := &AuditLogger{sink: failingSink{}, store: NewAuditStore(10, 0)}
+	a.write([]LogEntry{storeEntry(0, 1, "CREATE", OutcomeSuccess)})
+	if a.store.Len() != 0 {
+		t.Fatalf("store kept %d rejected entries", a.store.Len())
+	}
+
+	a.sink = NewWriterSink(io.Discard)
+	a.write([]LogEntry{storeEntry(1, 2, "CREATE", OutcomeSuccess)})
+	if a.store.Len() != 1 {
+		t.Fatalf("store has %d entries, want 1", a.store.Len())
+	}
+}
@@ -0,0 +1,261 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"go-microservice/metrics"
+)
+
+// Limits on what an ErrorAggregator keeps per group
+const (
+	maxErrorSamples = 5
+	maxErrorGroups  = 1000
+)
+
+// ErrorSample is one occurrence of an aggregated error
+type ErrorSample struct {
+	Time      time.Time `json:"time"`
+	Context   string    `json:"context,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// ErrorBucket counts occurrences in one aggregation window
+type ErrorBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// ErrorGroup aggregates errors sharing a fingerprint
+type ErrorGroup struct {
+	Fingerprint string `json:"fingerprint"`
+	Operation   string `json:"operation"`
+	ErrorType   string `json:"error_type"`
+	// Message is the error text with numbers, IDs and quoted values masked
+	Message   string    `json:"message"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Samples are the most recent occurrences, newest last
+	Samples []ErrorSample `json:"samples"`
+	// Buckets count occurrences per window, oldest first
+	Buckets []ErrorBucket `json:"buckets"`
+	// Recent is the count within the period the group was queried for
+	Recent int `json:"recent_count"`
+}
+
+// ErrorAggregator groups errors by fingerprint and counts them over time
+// windows, keeping groups seen within the retention period
+type ErrorAggregator struct {
+	mu        sync.Mutex
+	window    time.Duration
+	retention time.Duration
+	groups    map[string]*ErrorGroup
+}
+
+// NewErrorAggregator creates an ErrorAggregator counting errors per window
+func NewErrorAggregator(window, retention time.Duration) *ErrorAggregator {
+	return &ErrorAggregator{
+		window:    window,
+		retention: max(retention, window),
+		groups:    make(map[string]*ErrorGroup),
+	}
+}
+
+// Patterns masked out of error messages before fingerprinting, most
+// specific first
+var errorMessageMasks = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`"[^"]*"`), `"?"`},
+	{regexp.MustCompile(`'[^']*'`), `'?'`},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{16,}\b`), "<hex>"},
+	{regexp.MustCompile(`\d+(\.\d+)*`), "<n>"},
+}
+
+// normalizeErrorMessage masks the parts of an error message that vary
+// between occurrences of the same failure
+func normalizeErrorMessage(message string) string {
+	for _, mask := range errorMessageMasks {
+		message = mask.pattern.ReplaceAllString(message, mask.replacement)
+	}
+	return message
+}
+
+// genericErrorTypes are the types of errors.New and fmt.Errorf, which say
+// nothing about what failed
+var genericErrorTypes = map[string]bool{
+	"*errors.errorString": true,
+	"*fmt.wrapError":      true,
+	"*fmt.wrapErrors":     true,
+	"*errors.joinError":   true,
+}
+
+// ErrorType names the type of the innermost error err wraps that is not a
+// plain errors.New or fmt.Errorf error, "none" for nil. An error replayed
+// from a spill file reports the type it had before.
+func ErrorType(err error) string {
+	if err == nil {
+		return "none"
+	}
+	if replayed, ok := err.(*replayedError); ok {
+		return replayed.errorType
+	}
+	errorType := fmt.Sprintf("%T", err)
+	for next := errors.Unwrap(err); next != nil; next = errors.Unwrap(next) {
+		if name := fmt.Sprintf("%T", next); !genericErrorTypes[name] || genericErrorTypes[errorType] {
+			errorType = name
+		}
+	}
+	return errorType
+}
+
+// ErrorFingerprint identifies an error by operation and error type. Errors
+// made with errors.New or fmt.Errorf all share a type, so the normalized
+// message is part of the fingerprint too: distinct failures of one
+// operation stay apart while repeats of one failure, differing only in
+// IDs and numbers, are grouped.
+func ErrorFingerprint(operation string, err error) string {
+	message := ""
+	if err != nil {
+		message = normalizeErrorMessage(err.Error())
+	}
+	sum := sha256.Sum256([]byte(operation + "|" + ErrorType(err) + "|" + message))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Record adds entry to its group and reports whether it is the first of
+// its group in the current window, along with the count of the previous
+// window when this one has just started
+func (a *ErrorAggregator) Record(entry ErrorEntry) (first bool, previous int) {
+	errorType := ErrorType(entry.Error)
+	metrics.IncrementErrors(entry.Operation, errorType)
+
+	fingerprint := ErrorFingerprint(entry.Operation, entry.Error)
+	start := entry.Timestamp.Truncate(a.window)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	group, ok := a.groups[fingerprint]
+	if !ok {
+		if len(a.groups) >= maxErrorGroups {
+			a.evictLocked(entry.Timestamp)
+		}
+		group = &ErrorGroup{
+			Fingerprint: fingerprint,
+			Operation:   entry.Operation,
+			ErrorType:   errorType,
+			FirstSeen:   entry.Timestamp,
+		}
+		if entry.Error != nil {
+			group.Message = normalizeErrorMessage(entry.Error.Error())
+		}
+		a.groups[fingerprint] = group
+	}
+
+	group.Count++
+	if entry.Timestamp.After(group.LastSeen) {
+		group.LastSeen = entry.Timestamp
+	}
+
+	sample := ErrorSample{Time: entry.Timestamp, Context: entry.Context, RequestID: entry.RequestID}
+	if entry.Error != nil {
+		sample.Error = entry.Error.Error()
+	}
+	if len(group.Samples) == maxErrorSamples {
+		group.Samples = append(group.Samples[:0], group.Samples[1:]...)
+	}
+	group.Samples = append(group.Samples, sample)
+
+	last := len(group.Buckets) - 1
+	if last >= 0 && !group.Buckets[last].Start.Before(start) {
+		// Entries reach the aggregator slightly out of order across
+		// workers; count a late one in the latest window
+		group.Buckets[last].Count++
+		return false, 0
+	}
+	if last >= 0 && group.Buckets[last].Start.Equal(start.Add(-a.window)) {
+		previous = group.Buckets[last].Count
+	}
+	group.Buckets = append(group.Buckets, ErrorBucket{Start: start, Count: 1})
+
+	cutoff := entry.Timestamp.Add(-a.retention)
+	drop := 0
+	for drop < len(group.Buckets) && group.Buckets[drop].Start.Before(cutoff) {
+		drop++
+	}
+	group.Buckets = group.Buckets[drop:]
+	return true, previous
+}
+
+// evictLocked removes groups past retention, or the least recently seen
+// one if none are. Callers hold mu.
+func (a *ErrorAggregator) evictLocked(now time.Time) {
+	var oldest *ErrorGroup
+	for fingerprint, group := range a.groups {
+		if now.Sub(group.LastSeen) > a.retention {
+			delete(a.groups, fingerprint)
+			continue
+		}
+		if oldest == nil || group.LastSeen.Before(oldest.LastSeen) {
+			oldest = group
+		}
+	}
+	if len(a.groups) >= maxErrorGroups && oldest != nil {
+		delete(a.groups, oldest.Fingerprint)
+	}
+}
+
+// Groups returns copies of the groups seen since since, with their buckets
+// limited to that period, ordered by their count in it, highest first.
+// An empty operation matches every group.
+func (a *ErrorAggregator) Groups(since time.Time, operation string) []ErrorGroup {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	start := since.Truncate(a.window)
+	groups := make([]ErrorGroup, 0, len(a.groups))
+	for _, group := range a.groups {
+		if group.LastSeen.Before(since) || (operation != "" && group.Operation != operation) {
+			continue
+		}
+		snapshot := *group
+		snapshot.Samples = append([]ErrorSample(nil), group.Samples...)
+		snapshot.Buckets = nil
+		for _, bucket := range group.Buckets {
+			if !bucket.Start.Before(start) {
+				snapshot.Buckets = append(snapshot.Buckets, bucket)
+				snapshot.Recent += bucket.Count
+			}
+		}
+		groups = append(groups, snapshot)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Recent != groups[j].Recent {
+			return groups[i].Recent > groups[j].Recent
+		}
+		return groups[i].LastSeen.After(groups[j].LastSeen)
+	})
+	return groups
+}
+
+// Window returns the aggregation window
+func (a *ErrorAggregator) Window() time.Duration {
+	return a.window
+}
+
+// Retention returns how long groups and their buckets are kept
+func (a *ErrorAggregator) Retention() time.Duration {
+	return a.retention
+}
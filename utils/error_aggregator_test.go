@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+var aggregateEpoch = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// errorAt returns an entry for operation failing with err at aggregateEpoch
+// plus offset
+func errorAt(operation string, err error, offset time.Duration) ErrorEntry {
+	return ErrorEntry{Operation: operation, Error: err, Context: "context", Timestamp: aggregateEpoch.Add(offset)}
+}
+
+func TestNormalizeErrorMessage(t *testing.T) {
+	for message, want := range map[string]string{
+		"user 42 not found":                                   "user <n> not found",
+		`open "users/7.json": no such file`:                   `open "?": no such file`,
+		"bucket 'backups' missing":                            "bucket '?' missing",
+		"request 123e4567-e89b-12d3-a456-426614174000 failed": "request <uuid> failed",
+		"etag 9f86d081884c7d659a2feaa0c55ad015 mismatch":      "etag <hex> mismatch",
+		"dial tcp 10.0.0.1:9000: connection refused":          "dial tcp <n>:<n>: connection refused",
+	} {
+		if got := normalizeErrorMessage(message); got != want {
+			t.Errorf("normalizeErrorMessage(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
+
+func TestErrorFingerprint(t *testing.T) {
+	_, pathErr := os.Open("/nonexistent/users/1.json")
+	_, otherPathErr := os.Open("/nonexistent/users/2.json")
+
+	// The innermost specific type names the failure
+	if got := ErrorType(fmt.Errorf("restore: %w", pathErr)); got != "syscall.Errno" {
+		t.Errorf("ErrorType of a wrapped path error = %s, want syscall.Errno", got)
+	}
+	if got := ErrorType(fmt.Errorf("restore: %w", errors.New("x"))); got != "*errors.errorString" {
+		t.Errorf("ErrorType of a wrapped plain error = %s", got)
+	}
+	if ErrorType(nil) != "none" || ErrorType(errors.New("x")) != "*errors.errorString" {
+		t.Errorf("ErrorType(nil) = %s, ErrorType(errors.New) = %s", ErrorType(nil), ErrorType(errors.New("x")))
+	}
+
+	same := [][2]error{
+		{errors.New("user 1 not found"), errors.New("user 2 not found")},
+		{pathErr, otherPathErr},
+	}
+	for _, pair := range same {
+		if ErrorFingerprint("Op", pair[0]) != ErrorFingerprint("Op", pair[1]) {
+			t.Errorf("%q and %q fingerprinted apart", pair[0], pair[1])
+		}
+	}
+
+	different := [][2]error{
+		{errors.New("user 1 not found"), errors.New("connection refused")},
+		{errors.New("open x: y"), &os.PathError{Op: "open", Path: "x", Err: errors.New("y")}},
+	}
+	for _, pair := range different {
+		if ErrorFingerprint("Op", pair[0]) == ErrorFingerprint("Op", pair[1]) {
+			t.Errorf("%q and %q share a fingerprint", pair[0], pair[1])
+		}
+	}
+	if ErrorFingerprint("A", pathErr) == ErrorFingerprint("B", pathErr) {
+		t.Error("operations share a fingerprint")
+	}
+}
+
+func TestErrorAggregatorWindows(t *testing.T) {
+	a := NewErrorAggregator(time.Minute, 5*time.Minute)
+	err := errors.New("user 1 not found")
+
+	first, _ := a.Record(errorAt("Get", err, 0))
+	again, _ := a.Record(errorAt("Get", errors.New("user 2 not found"), 10*time.Second))
+	if !first || again {
+		t.Fatalf("first = %v, again = %v; want only the first logged", first, again)
+	}
+
+	// The next window reports the count of the one before
+	first, previous := a.Record(errorAt("Get", err, time.Minute+time.Second))
+	if !first || previous != 2 {
+		t.Fatalf("next window: first = %v, previous = %d; want true, 2", first, previous)
+	}
+	// A late entry from an earlier window counts in the latest
+	if first, _ := a.Record(errorAt("Get", err, 30*time.Second)); first {
+		t.Fatal("late entry logged as first of a window")
+	}
+
+	groups := a.Groups(aggregateEpoch.Add(-time.Hour), "")
+	if len(groups) != 1 {
+		t.Fatalf("groups = %+v", groups)
+	}
+	group := groups[0]
+	if group.Count != 4 || group.Recent != 4 || group.Message != "user <n> not found" ||
+		!group.FirstSeen.Equal(aggregateEpoch) || !group.LastSeen.Equal(aggregateEpoch.Add(time.Minute+time.Second)) {
+		t.Fatalf("group = %+v", group)
+	}
+	if len(group.Buckets) != 2 || group.Buckets[0].Count != 2 || group.Buckets[1].Count != 2 {
+		t.Fatalf("buckets = %+v", group.Buckets)
+	}
+
+	// After a gap, a window does not report a count for the one before,
+	// and buckets past retention are pruned
+	first, previous = a.Record(errorAt("Get", err, 10*time.Minute))
+	if !first || previous != 0 {
+		t.Fatalf("after a gap: first = %v, previous = %d", first, previous)
+	}
+	group = a.Groups(aggregateEpoch.Add(-time.Hour), "")[0]
+	if len(group.Buckets) != 1 || !group.Buckets[0].Start.Equal(aggregateEpoch.Add(10*time.Minute)) {
+		t.Fatalf("buckets after pruning = %+v", group.Buckets)
+	}
+	if group.Count != 5 {
+		t.Fatalf("Count = %d, want the total of 5", group.Count)
+	}
+}
+
+func TestErrorAggregatorSamples(t *testing.T) {
+	a := NewErrorAggregator(time.Minute, time.Hour)
+	for i := 0; i < maxErrorSamples+2; i++ {
+		entry := errorAt("Get", fmt.Errorf("user %d not found", i), time.Duration(i)*time.Second)
+		entry.RequestID = fmt.Sprintf("req-%d", i)
+		a.Record(entry)
+	}
+
+	samples := a.Groups(aggregateEpoch, "")[0].Samples
+	if len(samples) != maxErrorSamples {
+		t.Fatalf("kept %d samples, want %d", len(samples), maxErrorSamples)
+	}
+	last := samples[len(samples)-1]
+	if last.Error != "user 6 not found" || last.RequestID != "req-6" || last.Context != "context" ||
+		samples[0].Error != "user 2 not found" {
+		t.Fatalf("samples = %+v", samples)
+	}
+}
+
+func TestErrorAggregatorCapsGroups(t *testing.T) {
+	a := NewErrorAggregator(time.Minute, time.Hour)
+	for i := 0; i < maxErrorGroups; i++ {
+		a.Record(errorAt(fmt.Sprintf("Op%d", i), errors.New("failed"), time.Duration(i)*time.Millisecond))
+	}
+	// Seen again, so Op0 is no longer the least recently seen
+	a.Record(errorAt("Op0", errors.New("failed"), time.Second))
+
+	a.Record(errorAt("New", errors.New("failed"), 2*time.Second))
+	groups := a.Groups(aggregateEpoch.Add(-time.Hour), "")
+	if len(groups) != maxErrorGroups {
+		t.Fatalf("%d groups, want %d", len(groups), maxErrorGroups)
+	}
+	for _, operation := range []string{"Op0", "New"} {
+		if len(a.Groups(aggregateEpoch.Add(-time.Hour), operation)) != 1 {
+			t.Errorf("group %s evicted", operation)
+		}
+	}
+	if len(a.Groups(aggregateEpoch.Add(-time.Hour), "Op1")) != 0 {
+		t.Error("least recently seen group Op1 kept")
+	}
+
+	// Groups past retention go first, all at once
+	a.Record(errorAt("Later", errors.New("failed"), 2*time.Hour))
+	if groups := a.Groups(aggregateEpoch.Add(-time.Hour), ""); len(groups) != 1 || groups[0].Operation != "Later" {
+		t.Fatalf("%d groups after retention, want only Later", len(groups))
+	}
+}
+
+func TestErrorAggregatorGroupsFilters(t *testing.T) {
+	a := NewErrorAggregator(time.Minute, time.Hour)
+	a.Record(errorAt("Get", errors.New("not found"), 0))
+	for i := 0; i < 3; i++ {
+		a.Record(errorAt("Put", errors.New("timeout"), 10*time.Minute))
+	}
+	a.Record(errorAt("Get", errors.New("not found"), 20*time.Minute))
+	a.Record(errorAt("Get", errors.New("refused"), 5*time.Minute))
+
+	all := a.Groups(aggregateEpoch, "")
+	if len(all) != 3 || all[0].Operation != "Put" || all[0].Recent != 3 {
+		t.Fatalf("groups = %+v, want Put first with 3", all)
+	}
+
+	// Since drops groups not seen in the period and buckets before it
+	recent := a.Groups(aggregateEpoch.Add(10*time.Minute), "")
+	if len(recent) != 2 {
+		t.Fatalf("%d groups seen since 10m, want 2", len(recent))
+	}
+	for _, group := range recent {
+		if group.Message == "not found" && (group.Recent != 1 || group.Count != 2 || len(group.Buckets) != 1) {
+			t.Fatalf("not found group = %+v, want 1 recent of 2", group)
+		}
+	}
+
+	gets := a.Groups(aggregateEpoch, "Get")
+	if len(gets) != 2 {
+		t.Fatalf("%d Get groups, want 2", len(gets))
+	}
+	for _, group := range gets {
+		if group.Operation != "Get" {
+			t.Fatalf("operation filter returned %s", group.Operation)
+		}
+	}
+
+	// Returned groups are copies
+	gets[0].Samples[0].Context = "changed"
+	if a.Groups(aggregateEpoch, "Get")[0].Samples[0].Context == "changed" {
+		t.Fatal("Groups returned the aggregator's own samples")
+	}
+}
@@ -1,81 +1,158 @@
 package utils
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// AuditLogger handles asynchronous logging of user operations
+// AuditLogger handles asynchronous logging of user operations. Entries
+// are written as JSON through an AuditSink, stdout unless configured, and
+// retained in an AuditStore for queries.
 type AuditLogger struct {
-	logChan chan LogEntry
-	wg      sync.WaitGroup
-	logger  *log.Logger
+	pipeline *Pipeline[LogEntry]
+	mu       sync.Mutex
+	sink     AuditSink
+	store    *AuditStore
 }
 
 // LogEntry represents a single audit log entry
 type LogEntry struct {
-	Action    string
-	UserID    int
-	Details   string
-	Timestamp time.Time
+	// ID identifies entries relayed from the outbox so duplicates can be
+	// recognised
+	ID        string    `json:"id,omitempty"`
+	Timestamp time.Time `json:"time"`
+	Action    string    `json:"action"`
+	// Actor is who made the request: ActorAdmin, ActorAnonymous or
+	// ActorSystem for background work
+	Actor string `json:"actor"`
+	// UserID is the target user, 0 for actions on no single user
+	UserID    int    `json:"target_user_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	ClientIP  string `json:"client_ip,omitempty"`
+	Outcome   string `json:"outcome"`
+	Details   string `json:"details,omitempty"`
+	// Changes holds the fields of the target user that changed
+	Changes map[string]FieldChange `json:"changes,omitempty"`
+	// Seq, PrevHash and Hash link the entry into the tamper-evident chain
+	// written by ChainSink; other sinks leave them empty
+	Seq      uint64 `json:"seq,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
 }
 
+// auditBatchSize caps how many queued entries are written to the sink at once
+const auditBatchSize = 100
+
 var (
 	auditLogger *AuditLogger
 	once        sync.Once
 )
 
-// GetAuditLogger returns a singleton instance of AuditLogger
+// GetAuditLogger returns a singleton instance of AuditLogger. Entries are
+// written in batches of up to AUDIT_BATCH_SIZE, waiting up to
+// AUDIT_FLUSH_INTERVAL for a batch to fill, by AUDIT_WORKERS workers that
+// keep the entries of each user in order. The queue is sized by
+// AUDIT_QUEUE_SIZE and, when full, waits up to AUDIT_BLOCK_TIMEOUT for room
+// unless AUDIT_OVERFLOW says otherwise.
 func GetAuditLogger() *AuditLogger {
 	once.Do(func() {
 		auditLogger = &AuditLogger{
-			logChan: make(chan LogEntry, 10000), // Buffered channel for high throughput
-			logger:  log.New(os.Stdout, "[AUDIT] ", log.LstdFlags),
+			sink:  NewStdoutSink(),
+			store: GetAuditStore(),
 		}
-		auditLogger.start()
+		auditLogger.pipeline = NewShardedPipeline(LoadPipelineConfig("AUDIT", PipelineConfig{
+			Name:          "audit",
+			Capacity:      10000,
+			BatchSize:     auditBatchSize,
+			FlushInterval: 10 * time.Millisecond,
+			Workers:       4,
+			Policy:        OverflowBlock,
+			BlockTimeout:  time.Second,
+		}), auditKey, auditLogger.write)
 	})
 	return auditLogger
 }
 
-// start begins processing log entries asynchronously
-func (a *AuditLogger) start() {
-	go func() {
-		for entry := range a.logChan {
-			a.logger.Printf("Action: %s | UserID: %d | Details: %s | Time: %s",
-				entry.Action,
-				entry.UserID,
-				entry.Details,
-				entry.Timestamp.Format(time.RFC3339))
-			a.wg.Done()
-		}
-	}()
+// auditKey shards audit entries by target user
+func auditKey(entry LogEntry) string {
+	return strconv.Itoa(entry.UserID)
+}
+
+// write passes entries to the sink. If the sink fails they are written to
+// stderr instead so they are not lost, but are not queryable.
+func (a *AuditLogger) write(entries []LogEntry) {
+	if err := a.writeSink(entries); err != nil {
+		slog.Error("Failed to write audit entries, writing them to stderr", "count", len(entries), "error", err)
+		NewWriterSink(os.Stderr).Write(entries)
+	}
+}
+
+// writeSink passes entries to the sink and, once it has accepted them,
+// retains them in the store. Batches from different workers reach the
+// sink one at a time.
+func (a *AuditLogger) writeSink(entries []LogEntry) error {
+	a.mu.Lock()
+	err := a.sink.Write(entries)
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	a.store.Add(entries)
+	return nil
+}
+
+// Store returns the store entries are retained in for queries
+func (a *AuditLogger) Store() *AuditStore {
+	return a.store
+}
+
+// Configure replaces the sink, closing the previous one
+func (a *AuditLogger) Configure(sink AuditSink) {
+	a.mu.Lock()
+	previous := a.sink
+	a.sink = sink
+	a.mu.Unlock()
+
+	if err := previous.Close(); err != nil {
+		slog.Error("Failed to close audit sink", "error", err)
+	}
 }
 
 // LogUserAction logs a user action asynchronously
 func (a *AuditLogger) LogUserAction(action string, userID int, details string) {
-	a.wg.Add(1)
-	select {
-	case a.logChan <- LogEntry{
-		Action:    action,
-		UserID:    userID,
-		Details:   details,
-		Timestamp: time.Now(),
-	}:
-	default:
-		// Channel full, log synchronously as fallback
-		a.wg.Done()
-		a.logger.Printf("[OVERFLOW] Action: %s | UserID: %d | Details: %s",
-			action, userID, details)
-	}
+	entry := NewAuditEntry(context.Background(), action, userID)
+	entry.Details = details
+	a.Log(entry)
+}
+
+// Log queues entry as given. What happens when the queue is full depends
+// on the overflow policy; set AUDIT_OVERFLOW=spill and ASYNC_SPILL_DIR so
+// that nothing is lost.
+func (a *AuditLogger) Log(entry LogEntry) {
+	a.pipeline.Submit(entry)
+}
+
+// LogSync writes entry straight to the sink, bypassing the queue, and
+// returns the sink's error so the caller can retry. A failed entry is not
+// written anywhere else.
+func (a *AuditLogger) LogSync(entry LogEntry) error {
+	return a.writeSink([]LogEntry{entry})
 }
 
-// Close gracefully shuts down the logger
-func (a *AuditLogger) Close() {
-	a.wg.Wait()
-	close(a.logChan)
+// Close waits for queued and spilled entries to be written, or ctx to be
+// done, and closes the sink. Entries logged afterwards go to stdout.
+func (a *AuditLogger) Close(ctx context.Context) error {
+	err := a.pipeline.Close(ctx)
+	a.Configure(NewStdoutSink())
+	return err
 }
 
 // LogUserAction is a convenience function for logging user actions
@@ -88,17 +165,37 @@ func LogUserActionWithDetails(action string, userID int, details string) {
 	GetAuditLogger().LogUserAction(action, userID, details)
 }
 
-// NotificationService handles async notifications
+// NotificationService handles async notifications, delivering each one
+// to the channels its type is routed to
 type NotificationService struct {
-	notifyChan chan Notification
-	wg         sync.WaitGroup
+	pipeline *Pipeline[Notification]
+	mu       sync.RWMutex
+	config   *NotificationConfig
+
+	// sent records idempotency key and channel pairs already delivered,
+	// so a retried notification skips channels that accepted it
+	sentMu sync.Mutex
+	sent   map[string]time.Time
 }
 
+// sentRetention is how long delivered idempotency keys are remembered
+const sentRetention = time.Hour
+
 // Notification represents a notification to be sent
 type Notification struct {
 	UserID  int
 	Type    string
 	Message string
+	// Recipient details used by templates and the email channel
+	Email  string
+	Name   string
+	Locale string
+	// IdempotencyKey is passed on to every channel; it is the same each
+	// time a notification is retried
+	IdempotencyKey string
+	// RequestID is the ID of the request that caused the notification,
+	// passed on to channels that can carry it
+	RequestID string
 }
 
 var (
@@ -106,42 +203,142 @@ var (
 	notifyOnce          sync.Once
 )
 
-// GetNotificationService returns a singleton instance of NotificationService
+// GetNotificationService returns a singleton instance of
+// NotificationService. Its queue is sized by NOTIFY_QUEUE_SIZE and drops
+// new notifications when full unless NOTIFY_OVERFLOW says otherwise.
 func GetNotificationService() *NotificationService {
 	notifyOnce.Do(func() {
 		notificationService = &NotificationService{
-			notifyChan: make(chan Notification, 10000),
+			config: DefaultNotificationConfig(),
+			sent:   make(map[string]time.Time),
 		}
-		notificationService.start()
+		notificationService.pipeline = NewPipeline(LoadPipelineConfig("NOTIFY", PipelineConfig{
+			Name:         "notify",
+			Capacity:     10000,
+			Policy:       OverflowDropNewest,
+			BlockTimeout: time.Second,
+		}), func(batch []Notification) {
+			for _, notif := range batch {
+				notificationService.deliver(notif)
+			}
+		})
 	})
 	return notificationService
 }
 
-// start begins processing notifications asynchronously
-func (n *NotificationService) start() {
-	go func() {
-		for notif := range n.notifyChan {
-			// Simulate sending notification (in production, this would send email/push/etc.)
-			log.Printf("[NOTIFICATION] Type: %s | UserID: %d | Message: %s",
-				notif.Type, notif.UserID, notif.Message)
-			n.wg.Done()
+// Configure replaces the channels, routes and templates
+func (n *NotificationService) Configure(config *NotificationConfig) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.config = config
+}
+
+// deliver renders a notification and sends it to every routed channel.
+// A failing channel does not stop delivery to the others.
+func (n *NotificationService) deliver(notif Notification) {
+	n.Deliver(context.Background(), notif)
+}
+
+// Deliver renders notif and sends it to every routed channel synchronously,
+// returning the combined errors of the channels that failed. Each failure
+// is also reported to the ErrorHandler. With an IdempotencyKey, channels
+// that already accepted the notification are skipped, so callers can retry
+// Deliver until it succeeds. Logs and errors carry notif's RequestID.
+func (n *NotificationService) Deliver(ctx context.Context, notif Notification) error {
+	if notif.RequestID != "" {
+		ctx = WithRequestID(ctx, notif.RequestID)
+	}
+
+	n.mu.RLock()
+	config := n.config
+	n.mu.RUnlock()
+
+	msg := NotificationMessage{
+		ID:        notif.IdempotencyKey,
+		UserID:    notif.UserID,
+		Type:      notif.Type,
+		Email:     notif.Email,
+		Name:      notif.Name,
+		Locale:    notif.Locale,
+		RequestID: notif.RequestID,
+		Timestamp: time.Now().UTC(),
+	}
+	if msg.Locale == "" {
+		msg.Locale = config.DefaultLocale
+	}
+	if err := config.Templates.Render(&msg, notif.Message); err != nil {
+		GetErrorHandler().HandleErrorContext(ctx, "Notify", err, "failed to render "+notif.Type+" template")
+		return err
+	}
+
+	var errs []error
+	for _, notifier := range config.channels(notif.Type) {
+		if n.alreadySent(notif.IdempotencyKey, notifier.Name()) {
+			continue
+		}
+
+		channelCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		err := notifier.Notify(channelCtx, msg)
+		cancel()
+		if err != nil {
+			GetErrorHandler().HandleErrorContext(ctx, "Notify", err,
+				fmt.Sprintf("%s notification for user %d via %s", notif.Type, notif.UserID, notifier.Name()))
+			errs = append(errs, fmt.Errorf("%s: %w", notifier.Name(), err))
+			continue
+		}
+		n.markSent(notif.IdempotencyKey, notifier.Name())
+	}
+	return errors.Join(errs...)
+}
+
+// alreadySent reports whether channel accepted the notification with key
+func (n *NotificationService) alreadySent(key, channel string) bool {
+	if key == "" {
+		return false
+	}
+	n.sentMu.Lock()
+	defer n.sentMu.Unlock()
+	_, ok := n.sent[key+"/"+channel]
+	return ok
+}
+
+// markSent records that channel accepted the notification with key,
+// forgetting keys older than sentRetention as the set grows
+func (n *NotificationService) markSent(key, channel string) {
+	if key == "" {
+		return
+	}
+	n.sentMu.Lock()
+	defer n.sentMu.Unlock()
+
+	now := time.Now()
+	if len(n.sent) >= 10000 {
+		for k, at := range n.sent {
+			if now.Sub(at) > sentRetention {
+				delete(n.sent, k)
+			}
 		}
-	}()
+	}
+	n.sent[key+"/"+channel] = now
 }
 
 // SendNotification sends a notification asynchronously
 func (n *NotificationService) SendNotification(userID int, notifType, message string) {
-	n.wg.Add(1)
-	select {
-	case n.notifyChan <- Notification{
+	n.Send(Notification{
 		UserID:  userID,
 		Type:    notifType,
 		Message: message,
-	}:
-	default:
-		n.wg.Done()
-		log.Printf("[NOTIFICATION OVERFLOW] Type: %s | UserID: %d", notifType, userID)
-	}
+	})
+}
+
+// Send queues a notification asynchronously
+func (n *NotificationService) Send(notif Notification) {
+	n.pipeline.Submit(notif)
+}
+
+// Close waits for queued notifications to be delivered, or ctx to be done
+func (n *NotificationService) Close(ctx context.Context) error {
+	return n.pipeline.Close(ctx)
 }
 
 // SendUserNotification is a convenience function for sending user notifications
@@ -149,11 +346,13 @@ func SendUserNotification(userID int, notifType, message string) {
 	GetNotificationService().SendNotification(userID, notifType, message)
 }
 
-// ErrorHandler handles async error processing
+// ErrorHandler handles async error processing, logging each error at
+// error level through the application logger
 type ErrorHandler struct {
-	errorChan chan ErrorEntry
-	wg        sync.WaitGroup
-	logger    *log.Logger
+	pipeline   *Pipeline[ErrorEntry]
+	aggregator *ErrorAggregator
+	// logger overrides slog.Default when set
+	logger atomic.Pointer[slog.Logger]
 }
 
 // ErrorEntry represents an error to be logged
@@ -162,53 +361,189 @@ type ErrorEntry struct {
 	Error     error
 	Context   string
 	Timestamp time.Time
+	// RequestID is the ID of the failed request, if any
+	RequestID string
+	// Attrs are the request-scoped log attributes of the failed request
+	Attrs []slog.Attr
+}
+
+// errorEntryJSON is how an ErrorEntry is spilled; the error keeps its text
+// and type name, attribute values only their text
+type errorEntryJSON struct {
+	Operation string            `json:"operation"`
+	Error     string            `json:"error,omitempty"`
+	ErrorType string            `json:"error_type,omitempty"`
+	Context   string            `json:"context,omitempty"`
+	Timestamp time.Time         `json:"time"`
+	RequestID string            `json:"request_id,omitempty"`
+	Attrs     map[string]string `json:"attrs,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (e ErrorEntry) MarshalJSON() ([]byte, error) {
+	raw := errorEntryJSON{Operation: e.Operation, Context: e.Context, Timestamp: e.Timestamp, RequestID: e.RequestID}
+	if e.Error != nil {
+		raw.Error = e.Error.Error()
+		raw.ErrorType = ErrorType(e.Error)
+	}
+	if len(e.Attrs) > 0 {
+		raw.Attrs = make(map[string]string, len(e.Attrs))
+		for _, attr := range e.Attrs {
+			raw.Attrs[attr.Key] = attr.Value.String()
+		}
+	}
+	return json.Marshal(raw)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The error is restored with
+// its original type name.
+func (e *ErrorEntry) UnmarshalJSON(data []byte) error {
+	var raw errorEntryJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*e = ErrorEntry{Operation: raw.Operation, Context: raw.Context, Timestamp: raw.Timestamp, RequestID: raw.RequestID}
+	if raw.Error != "" {
+		e.Error = &replayedError{message: raw.Error, errorType: raw.ErrorType}
+	}
+	for key, value := range raw.Attrs {
+		e.Attrs = append(e.Attrs, slog.String(key, value))
+	}
+	return nil
+}
+
+// replayedError is an error read back from a spill file. ErrorType reports
+// the type of the error it was spilled from.
+type replayedError struct {
+	message   string
+	errorType string
 }
 
+func (e *replayedError) Error() string { return e.message }
+
 var (
 	errorHandler *ErrorHandler
 	errorOnce    sync.Once
 )
 
-// GetErrorHandler returns a singleton instance of ErrorHandler
+// GetErrorHandler returns a singleton instance of ErrorHandler. Errors are
+// batched and sharded like audit entries, configured by the ERRORS_
+// variables. When the queue is full the oldest errors are dropped
+// unless ERRORS_OVERFLOW says otherwise. Errors are aggregated by
+// fingerprint over ERRORS_AGGREGATE_WINDOW (default 1m), keeping groups
+// for ERRORS_AGGREGATE_RETENTION (default 1h).
 func GetErrorHandler() *ErrorHandler {
 	errorOnce.Do(func() {
-		errorHandler = &ErrorHandler{
-			errorChan: make(chan ErrorEntry, 10000),
-			logger:    log.New(os.Stderr, "[ERROR] ", log.LstdFlags),
+		window, retention := time.Minute, time.Hour
+		for name, target := range map[string]*time.Duration{
+			"ERRORS_AGGREGATE_WINDOW":    &window,
+			"ERRORS_AGGREGATE_RETENTION": &retention,
+		} {
+			if value := os.Getenv(name); value != "" {
+				if d, err := time.ParseDuration(value); err == nil && d > 0 {
+					*target = d
+				} else {
+					slog.Warn("Ignoring invalid setting", "name", name, "value", value)
+				}
+			}
 		}
-		errorHandler.start()
+
+		errorHandler = &ErrorHandler{aggregator: NewErrorAggregator(window, retention)}
+		errorHandler.pipeline = NewShardedPipeline(LoadPipelineConfig("ERRORS", PipelineConfig{
+			Name:          "errors",
+			Capacity:      10000,
+			BatchSize:     100,
+			FlushInterval: 10 * time.Millisecond,
+			Workers:       2,
+			Policy:        OverflowDropOldest,
+			BlockTimeout:  time.Second,
+		}), errorKey, errorHandler.write)
 	})
 	return errorHandler
 }
 
-// start begins processing errors asynchronously
-func (e *ErrorHandler) start() {
-	go func() {
-		for entry := range e.errorChan {
-			e.logger.Printf("Operation: %s | Error: %v | Context: %s | Time: %s",
-				entry.Operation,
-				entry.Error,
-				entry.Context,
-				entry.Timestamp.Format(time.RFC3339))
-			e.wg.Done()
+// errorKey shards errors by request, so the errors of one request, and so
+// of the user it acts for, are logged in order. Errors carry no user ID;
+// those raised outside a request are sharded by operation.
+func errorKey(entry ErrorEntry) string {
+	if entry.RequestID != "" {
+		return entry.RequestID
+	}
+	return entry.Operation
+}
+
+// write aggregates a batch of errors and logs the first of each
+// fingerprint per window; the rest are only counted. The message is the
+// error's context.
+func (e *ErrorHandler) write(batch []ErrorEntry) {
+	logger := e.logger.Load()
+	if logger == nil {
+		logger = slog.Default()
+	}
+	handler := logger.Handler()
+	enabled := handler.Enabled(context.Background(), slog.LevelError)
+
+	for _, entry := range batch {
+		first, previous := e.aggregator.Record(entry)
+		if !first || !enabled {
+			continue
+		}
+
+		message := entry.Context
+		if message == "" {
+			message = entry.Operation + " failed"
 		}
-	}()
+		record := slog.NewRecord(entry.Timestamp, slog.LevelError, message, 0)
+		record.AddAttrs(
+			slog.String("operation", entry.Operation),
+			slog.String("fingerprint", ErrorFingerprint(entry.Operation, entry.Error)))
+		if entry.Error != nil {
+			record.AddAttrs(slog.String("error", entry.Error.Error()))
+		}
+		if previous > 1 {
+			record.AddAttrs(slog.Int("suppressed_last_window", previous-1))
+		}
+		record.AddAttrs(entry.Attrs...)
+		handler.Handle(context.Background(), record)
+	}
+}
+
+// Aggregator returns the aggregated error groups
+func (e *ErrorHandler) Aggregator() *ErrorAggregator {
+	return e.aggregator
+}
+
+// SetLogger logs errors through logger instead of the default logger
+func (e *ErrorHandler) SetLogger(logger *slog.Logger) {
+	e.logger.Store(logger)
 }
 
 // HandleError logs an error asynchronously
 func (e *ErrorHandler) HandleError(operation string, err error, context string) {
-	e.wg.Add(1)
-	select {
-	case e.errorChan <- ErrorEntry{
+	e.pipeline.Submit(ErrorEntry{
 		Operation: operation,
 		Error:     err,
 		Context:   context,
 		Timestamp: time.Now(),
-	}:
-	default:
-		e.wg.Done()
-		e.logger.Printf("[OVERFLOW] Operation: %s | Error: %v", operation, err)
-	}
+	})
+}
+
+// HandleErrorContext logs an error asynchronously with the request ID and
+// request-scoped log attributes of ctx
+func (e *ErrorHandler) HandleErrorContext(ctx context.Context, operation string, err error, context string) {
+	e.pipeline.Submit(ErrorEntry{
+		Operation: operation,
+		Error:     err,
+		Context:   context,
+		Timestamp: time.Now(),
+		RequestID: RequestIDFromContext(ctx),
+		Attrs:     LogAttrsFromContext(ctx),
+	})
+}
+
+// Close waits for queued errors to be logged, or ctx to be done
+func (e *ErrorHandler) Close(ctx context.Context) error {
+	return e.pipeline.Close(ctx)
 }
 
 // LogError is a convenience function for logging errors asynchronously
@@ -220,3 +555,8 @@ func LogError(operation string, err error, context string) {
 func LogErrorf(operation string, err error, format string, args ...interface{}) {
 	GetErrorHandler().HandleError(operation, err, fmt.Sprintf(format, args...))
 }
+
+// LogErrorContext logs an error of the request in ctx asynchronously
+func LogErrorContext(ctx context.Context, operation string, err error, context string) {
+	GetErrorHandler().HandleErrorContext(ctx, operation, err, context)
+}
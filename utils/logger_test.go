@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkAuditLogger measures audit logging from concurrent producers
+// into a discarding sink, until every entry has left the queue. The sink
+// stays in place, as batches may still be in flight when it returns.
+func BenchmarkAuditLogger(b *testing.B) {
+	logger := GetAuditLogger()
+	logger.Configure(NewWriterSink(io.Discard))
+
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			logger.LogUserAction("BENCHMARK", int(next.Add(1)%1000), "")
+		}
+	})
+	waitDrained(b, logger.pipeline)
+}
+
+// BenchmarkErrorHandler measures error handling from concurrent producers
+// with a discarding logger, until every error has left the queue
+func BenchmarkErrorHandler(b *testing.B) {
+	handler := GetErrorHandler()
+	handler.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	benchErr := errors.New("benchmark error")
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			handler.HandleError("Benchmark", benchErr, "benchmark")
+		}
+	})
+	waitDrained(b, handler.pipeline)
+}
+
+func TestErrorKeyShardsByRequest(t *testing.T) {
+	first := ErrorEntry{Operation: "CreateUser", RequestID: "req-1"}
+	second := ErrorEntry{Operation: "Notify", RequestID: "req-1"}
+	if errorKey(first) != errorKey(second) {
+		t.Errorf("errors of one request sharded apart: %q, %q", errorKey(first), errorKey(second))
+	}
+	if got := errorKey(ErrorEntry{Operation: "Notify"}); got != "Notify" {
+		t.Errorf("errorKey without request = %q, want operation", got)
+	}
+}
+
+func TestErrorEntryReplayKeepsFingerprint(t *testing.T) {
+	_, pathErr := os.Open(filepath.Join(t.TempDir(), "missing"))
+	for _, err := range []error{
+		pathErr,
+		fmt.Errorf("restore user 7: %w", pathErr),
+		errors.New("plain failure 42"),
+	} {
+		raw, marshalErr := ErrorEntry{Operation: "Restore", Error: err, Timestamp: time.Now()}.MarshalJSON()
+		if marshalErr != nil {
+			t.Fatal(marshalErr)
+		}
+		var replayed ErrorEntry
+		if err := replayed.UnmarshalJSON(raw); err != nil {
+			t.Fatal(err)
+		}
+
+		if replayed.Error.Error() != err.Error() {
+			t.Errorf("replayed message = %q, want %q", replayed.Error, err)
+		}
+		if got, want := ErrorType(replayed.Error), ErrorType(err); got != want {
+			t.Errorf("replayed %q has type %s, want %s", err, got, want)
+		}
+		if got, want := ErrorFingerprint("Restore", replayed.Error), ErrorFingerprint("Restore", err); got != want {
+			t.Errorf("replayed %q has fingerprint %s, want %s", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// notifyTimeout bounds a single delivery on one channel
+const notifyTimeout = 15 * time.Second
+
+// NotificationConfig decides how NotificationService renders and delivers
+// each notification type
+type NotificationConfig struct {
+	Notifiers map[string]Notifier
+	// Routes maps a notification type to channel names; "*" matches
+	// types without their own rule
+	Routes        map[string][]string
+	Templates     *TemplateSet
+	DefaultLocale string
+}
+
+// DefaultNotificationConfig logs every notification, rendered with the
+// built-in English templates
+func DefaultNotificationConfig() *NotificationConfig {
+	templates, _ := NewTemplateSet(defaultTemplates, "en")
+	return &NotificationConfig{
+		Notifiers:     map[string]Notifier{ChannelLog: LogNotifier{}},
+		Routes:        map[string][]string{"*": {ChannelLog}},
+		Templates:     templates,
+		DefaultLocale: "en",
+	}
+}
+
+// LoadNotificationConfig builds the config from the environment.
+// Channels are enabled by their settings:
+//
+//	email    SMTP_ADDR (host:port), SMTP_FROM, SMTP_USERNAME, SMTP_PASSWORD
+//	webhook  NOTIFY_WEBHOOK_URL, NOTIFY_WEBHOOK_SECRET
+//	file     NOTIFY_FILE
+//	log      always available
+//
+// NOTIFY_ROUTES assigns channels per type, for example
+// "WELCOME=email,file;ACCOUNT_DELETED=email,webhook;*=log" (default "*=log").
+// NOTIFY_LOCALE sets the fallback locale (default "en").
+func LoadNotificationConfig() (*NotificationConfig, error) {
+	config := &NotificationConfig{
+		Notifiers:     map[string]Notifier{ChannelLog: LogNotifier{}},
+		DefaultLocale: "en",
+	}
+	if locale := os.Getenv("NOTIFY_LOCALE"); locale != "" {
+		config.DefaultLocale = strings.ToLower(locale)
+	}
+
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			return nil, fmt.Errorf("SMTP_FROM is required with SMTP_ADDR")
+		}
+		notifier, err := NewSMTPNotifier(addr, from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+		if err != nil {
+			return nil, err
+		}
+		config.Notifiers[ChannelEmail] = notifier
+	}
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		config.Notifiers[ChannelWebhook] = NewWebhookNotifier(url, os.Getenv("NOTIFY_WEBHOOK_SECRET"))
+	}
+	if path := os.Getenv("NOTIFY_FILE"); path != "" {
+		config.Notifiers[ChannelFile] = NewFileNotifier(path)
+	}
+
+	routes := os.Getenv("NOTIFY_ROUTES")
+	if routes == "" {
+		routes = "*=" + ChannelLog
+	}
+	var err error
+	if config.Routes, err = parseNotificationRoutes(routes, config.Notifiers); err != nil {
+		return nil, err
+	}
+
+	if config.Templates, err = LoadTemplates(config.DefaultLocale); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// parseNotificationRoutes parses "TYPE=ch1,ch2;..." and checks that every
+// channel is configured
+func parseNotificationRoutes(value string, notifiers map[string]Notifier) (map[string][]string, error) {
+	routes := make(map[string][]string)
+	for _, rule := range strings.Split(value, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		notifType, channels, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid notification route %q", rule)
+		}
+		notifType = strings.TrimSpace(notifType)
+		for _, channel := range strings.Split(channels, ",") {
+			channel = strings.TrimSpace(channel)
+			if channel == "" {
+				continue
+			}
+			if _, ok := notifiers[channel]; !ok {
+				return nil, fmt.Errorf("notification route %s uses unconfigured channel %q", notifType, channel)
+			}
+			routes[notifType] = append(routes[notifType], channel)
+		}
+	}
+	return routes, nil
+}
+
+// channels returns the notifiers a notification type is routed to
+func (c *NotificationConfig) channels(notifType string) []Notifier {
+	names, ok := c.Routes[notifType]
+	if !ok {
+		names = c.Routes["*"]
+	}
+
+	notifiers := make([]Notifier, 0, len(names))
+	for _, name := range names {
+		notifiers = append(notifiers, c.Notifiers[name])
+	}
+	return notifiers
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Notification types sent by the user handlers
+const (
+	NotifyWelcome        = "WELCOME"
+	NotifyProfileUpdated = "PROFILE_UPDATED"
+	NotifyAccountDeleted = "ACCOUNT_DELETED"
+)
+
+// NotificationTemplate is the text of one notification type in one locale.
+// Both fields are text/template sources executed with TemplateData.
+type NotificationTemplate struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// TemplateData is passed to notification templates
+type TemplateData struct {
+	UserID  int
+	Name    string
+	Email   string
+	Message string
+}
+
+// defaultTemplates are used unless NOTIFY_TEMPLATES_FILE overrides them
+var defaultTemplates = map[string]map[string]NotificationTemplate{
+	NotifyWelcome: {
+		"en": {
+			Subject: "Welcome, {{.Name}}!",
+			Body:    "Hello {{.Name}},\n\nYour account has been created.\n",
+		},
+		"ru": {
+			Subject: "Добро пожаловать, {{.Name}}!",
+			Body:    "Здравствуйте, {{.Name}}!\n\nВаша учетная запись создана.\n",
+		},
+	},
+	NotifyProfileUpdated: {
+		"en": {
+			Subject: "Your profile has been updated",
+			Body:    "Hello {{.Name}},\n\nYour profile was updated. If this was not you, contact support.\n",
+		},
+		"ru": {
+			Subject: "Ваш профиль обновлен",
+			Body:    "Здравствуйте, {{.Name}}!\n\nВаш профиль был изменен. Если это были не вы, обратитесь в поддержку.\n",
+		},
+	},
+	NotifyAccountDeleted: {
+		"en": {
+			Subject: "Your account has been deleted",
+			Body:    "Hello {{.Name}},\n\nYour account has been deleted.\n",
+		},
+		"ru": {
+			Subject: "Ваша учетная запись удалена",
+			Body:    "Здравствуйте, {{.Name}}!\n\nВаша учетная запись удалена.\n",
+		},
+	},
+}
+
+// compiledTemplate is a parsed NotificationTemplate
+type compiledTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// TemplateSet renders notifications by type and locale
+type TemplateSet struct {
+	templates     map[string]map[string]compiledTemplate
+	defaultLocale string
+}
+
+// NewTemplateSet parses templates, keyed by notification type and then
+// locale. defaultLocale is used when the requested locale has no template.
+func NewTemplateSet(templates map[string]map[string]NotificationTemplate, defaultLocale string) (*TemplateSet, error) {
+	set := &TemplateSet{
+		templates:     make(map[string]map[string]compiledTemplate, len(templates)),
+		defaultLocale: defaultLocale,
+	}
+	for notifType, locales := range templates {
+		set.templates[notifType] = make(map[string]compiledTemplate, len(locales))
+		for locale, tmpl := range locales {
+			name := notifType + "." + locale
+			subject, err := template.New(name + ".subject").Parse(tmpl.Subject)
+			if err != nil {
+				return nil, fmt.Errorf("template %s: %w", name, err)
+			}
+			body, err := template.New(name + ".body").Parse(tmpl.Body)
+			if err != nil {
+				return nil, fmt.Errorf("template %s: %w", name, err)
+			}
+			set.templates[notifType][strings.ToLower(locale)] = compiledTemplate{subject, body}
+		}
+	}
+	return set, nil
+}
+
+// LoadTemplates returns the default templates merged with those in the
+// JSON file named by NOTIFY_TEMPLATES_FILE, which has the same shape:
+//
+//	{"WELCOME": {"de": {"subject": "Willkommen, {{.Name}}", "body": "..."}}}
+func LoadTemplates(defaultLocale string) (*TemplateSet, error) {
+	templates := make(map[string]map[string]NotificationTemplate, len(defaultTemplates))
+	for notifType, locales := range defaultTemplates {
+		templates[notifType] = make(map[string]NotificationTemplate, len(locales))
+		for locale, tmpl := range locales {
+			templates[notifType][locale] = tmpl
+		}
+	}
+
+	if path := os.Getenv("NOTIFY_TEMPLATES_FILE"); path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read notification templates: %w", err)
+		}
+		var custom map[string]map[string]NotificationTemplate
+		if err := json.Unmarshal(raw, &custom); err != nil {
+			return nil, fmt.Errorf("invalid notification templates %s: %w", path, err)
+		}
+		for notifType, locales := range custom {
+			if templates[notifType] == nil {
+				templates[notifType] = make(map[string]NotificationTemplate, len(locales))
+			}
+			for locale, tmpl := range locales {
+				templates[notifType][locale] = tmpl
+			}
+		}
+	}
+
+	return NewTemplateSet(templates, defaultLocale)
+}
+
+// Render fills in the subject and body of msg for its type and locale.
+// Types without a template keep the notification's own message.
+func (t *TemplateSet) Render(msg *NotificationMessage, message string) error {
+	msg.Subject = msg.Type
+	msg.Body = message
+
+	locales := t.templates[msg.Type]
+	tmpl, ok := locales[msg.Locale]
+	if !ok {
+		if tmpl, ok = locales[t.defaultLocale]; !ok {
+			return nil
+		}
+		msg.Locale = t.defaultLocale
+	}
+
+	data := TemplateData{UserID: msg.UserID, Name: msg.Name, Email: msg.Email, Message: message}
+
+	var subject, body strings.Builder
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return err
+	}
+	if err := tmpl.body.Execute(&body, data); err != nil {
+		return err
+	}
+	msg.Subject = subject.String()
+	msg.Body = body.String()
+	return nil
+}
+
+// PreferredLocale returns the primary language of the first entry in an
+// Accept-Language header, e.g. "ru" for "ru-RU,ru;q=0.9,en;q=0.8"
+func PreferredLocale(acceptLanguage string) string {
+	first, _, _ := strings.Cut(acceptLanguage, ",")
+	tag, _, _ := strings.Cut(strings.TrimSpace(first), ";")
+	lang, _, _ := strings.Cut(tag, "-")
+	if lang == "*" {
+		return ""
+	}
+	return strings.ToLower(lang)
+}
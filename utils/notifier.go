@@ -0,0 +1,298 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// NotificationMessage is a notification rendered for delivery
+type NotificationMessage struct {
+	// ID is the idempotency key, present when the notification may be
+	// delivered more than once
+	ID      string `json:"id,omitempty"`
+	UserID  int    `json:"user_id"`
+	Type    string `json:"type"`
+	Email   string `json:"email,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Locale  string `json:"locale"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	// RequestID is the ID of the request that caused the notification
+	RequestID string    `json:"request_id,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Notifier delivers notifications over one channel
+type Notifier interface {
+	// Name identifies the channel in routing rules, e.g. "email"
+	Name() string
+	// Notify delivers msg, returning an error if it was not accepted
+	Notify(ctx context.Context, msg NotificationMessage) error
+}
+
+// Notification channels selectable in NOTIFY_ROUTES
+const (
+	ChannelLog     = "log"
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelFile    = "file"
+)
+
+// LogNotifier writes notifications to the standard logger
+type LogNotifier struct{}
+
+// Name returns the channel name
+func (LogNotifier) Name() string { return ChannelLog }
+
+// Notify logs msg
+func (LogNotifier) Notify(ctx context.Context, msg NotificationMessage) error {
+	slog.InfoContext(ctx, "Notification", "type", msg.Type, "user_id", msg.UserID, "subject", msg.Subject)
+	return nil
+}
+
+// FileNotifier appends notifications to a file as JSON lines
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileNotifier creates a FileNotifier writing to path
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+// Name returns the channel name
+func (f *FileNotifier) Name() string { return ChannelFile }
+
+// Notify appends msg to the file
+func (f *FileNotifier) Notify(ctx context.Context, msg NotificationMessage) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// WebhookNotifier posts notifications as JSON to a URL. With a secret the
+// body is signed like user event webhooks: X-Notification-Signature is
+// "sha256=" + hex HMAC-SHA256 of "<X-Notification-Timestamp>.<body>".
+type WebhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a WebhookNotifier posting to url
+func NewWebhookNotifier(url, secret string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Name returns the channel name
+func (wn *WebhookNotifier) Name() string { return ChannelWebhook }
+
+// Notify posts msg and expects a 2xx response
+func (wn *WebhookNotifier) Notify(ctx context.Context, msg NotificationMessage) (err error) {
+	ctx, span := StartSpan(ctx, "notify webhook", attribute.String("notification.type", msg.Type))
+	defer func() { EndSpan(span, err) }()
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wn.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if msg.ID != "" {
+		req.Header.Set("Idempotency-Key", msg.ID)
+	}
+	if msg.RequestID != "" {
+		req.Header.Set(RequestIDHeader, msg.RequestID)
+	}
+	if wn.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(wn.secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		req.Header.Set("X-Notification-Timestamp", timestamp)
+		req.Header.Set("X-Notification-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	InjectTraceContext(ctx, req.Header)
+
+	resp, err := wn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SMTPNotifier emails notifications to the user's address
+type SMTPNotifier struct {
+	addr string
+	host string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPNotifier creates an SMTPNotifier for the server at addr
+// (host:port). Credentials are optional; net/smtp only sends them over
+// TLS or to localhost.
+func NewSMTPNotifier(addr, from, username, password string) (*SMTPNotifier, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %w", addr, err)
+	}
+
+	notifier := &SMTPNotifier{addr: addr, host: host, from: from}
+	if username != "" {
+		notifier.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return notifier, nil
+}
+
+// Name returns the channel name
+func (s *SMTPNotifier) Name() string { return ChannelEmail }
+
+// Notify sends msg as a plain-text email
+func (s *SMTPNotifier) Notify(ctx context.Context, msg NotificationMessage) error {
+	if msg.Email == "" {
+		return fmt.Errorf("no email address for user %d", msg.UserID)
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", headerSafe(s.from))
+	fmt.Fprintf(&body, "To: %s\r\n", headerSafe(msg.Email))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerSafe(msg.Subject)))
+	fmt.Fprintf(&body, "Date: %s\r\n", msg.Timestamp.Format(time.RFC1123Z))
+	if msg.ID != "" {
+		// A stable Message-ID lets mail systems discard redelivered copies
+		fmt.Fprintf(&body, "Message-ID: <%s@%s>\r\n", headerSafe(msg.ID), s.domain())
+	}
+	if msg.RequestID != "" {
+		fmt.Fprintf(&body, "%s: %s\r\n", RequestIDHeader, headerSafe(msg.RequestID))
+	}
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	body.WriteString("\r\n")
+	body.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	body.WriteString("\r\n")
+
+	return s.send(ctx, msg.Email, []byte(body.String()))
+}
+
+// send is smtp.SendMail bounded by ctx: the connection is dialed with
+// ctx and times out when ctx ends, so a stalled server cannot hold the
+// call past it
+func (s *SMTPNotifier) send(ctx context.Context, to string, message []byte) error {
+	dialer := net.Dialer{Timeout: notifyTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		conn.SetDeadline(time.Now().Add(notifyTimeout))
+	}
+	// ctx ending, by its deadline or cancellation, unblocks any pending
+	// read or write
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return contextError(ctx, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return contextError(ctx, err)
+		}
+	}
+	if s.auth != nil {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(s.auth); err != nil {
+				return contextError(ctx, err)
+			}
+		}
+	}
+	if err := client.Mail(s.from); err != nil {
+		return contextError(ctx, err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return contextError(ctx, err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	if _, err := w.Write(message); err != nil {
+		return contextError(ctx, err)
+	}
+	if err := w.Close(); err != nil {
+		return contextError(ctx, err)
+	}
+	return contextError(ctx, client.Quit())
+}
+
+// contextError reports ctx's error for a failure caused by ctx ending
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// domain returns the domain of the sender address, used in Message-IDs
+func (s *SMTPNotifier) domain() string {
+	if _, domain, ok := strings.Cut(s.from, "@"); ok {
+		return strings.Trim(domain, "> ")
+	}
+	return "localhost"
+}
+
+// headerSafe strips line breaks so a value cannot inject mail headers
+func headerSafe(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accepts one message per connection and records the DATA
+type fakeSMTPServer struct {
+	listener net.Listener
+	messages chan string
+	// stalled receives the connections of a silent server
+	stalled chan net.Conn
+}
+
+// newFakeSMTPServer starts a server; a silent one accepts connections but
+// never greets, like a stalled relay
+func newFakeSMTPServer(t *testing.T, silent bool) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTPServer{listener: listener, messages: make(chan string, 10), stalled: make(chan net.Conn, 10)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if silent {
+				t.Cleanup(func() { conn.Close() })
+				s.stalled <- conn
+				continue
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		switch verb := strings.ToUpper(strings.Fields(line + " x")[0]); verb {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 localhost")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.messages <- string(data)
+			text.PrintfLine("250 queued")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+func TestSMTPNotifierHeaders(t *testing.T) {
+	server := newFakeSMTPServer(t, false)
+	notifier, err := NewSMTPNotifier(server.listener.Addr().String(), "Service <noreply@example.com>", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = notifier.Notify(ctx, NotificationMessage{
+		ID:        "0123abcd",
+		UserID:    1,
+		Type:      NotifyWelcome,
+		Email:     "ada@example.com",
+		Subject:   "Willkommen, Ädä\r\nBcc: victim@example.com",
+		Body:      "Hello\nBcc: not-a-header@example.com",
+		RequestID: "req-1\r\nX-Injected: yes",
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	raw := <-server.messages
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("parse message: %v\n%s", err, raw)
+	}
+
+	if got := msg.Header.Get("Message-ID"); got != "<0123abcd@example.com>" {
+		t.Errorf("Message-ID = %q", got)
+	}
+	encoded := msg.Header.Get("Subject")
+	if !strings.HasPrefix(encoded, "=?utf-8?q?") {
+		t.Errorf("Subject not Q-encoded: %q", encoded)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(encoded)
+	if err != nil || !strings.HasPrefix(subject, "Willkommen, Ädä") || strings.ContainsAny(subject, "\r\n") {
+		t.Errorf("Subject decodes to %q, %v", subject, err)
+	}
+	for _, name := range []string{"Bcc", "X-Injected"} {
+		if values, ok := msg.Header[name]; ok {
+			t.Errorf("injected header %s: %q", name, values)
+		}
+	}
+	if got := msg.Header.Get(RequestIDHeader); strings.ContainsAny(got, "\r\n") || !strings.HasPrefix(got, "req-1") {
+		t.Errorf("%s = %q", RequestIDHeader, got)
+	}
+	if got := msg.Header.Get("To"); got != "ada@example.com" {
+		t.Errorf("To = %q", got)
+	}
+}
+
+func TestSMTPNotifierRejectsAddressInjection(t *testing.T) {
+	server := newFakeSMTPServer(t, false)
+	notifier, err := NewSMTPNotifier(server.listener.Addr().String(), "noreply@example.com", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = notifier.Notify(context.Background(), NotificationMessage{
+		UserID: 1,
+		Email:  "ada@example.com\r\nRCPT TO:<victim@example.com>",
+	})
+	if err == nil {
+		t.Fatal("Notify accepted an address with a line break")
+	}
+}
+
+func TestSMTPNotifierStalledServer(t *testing.T) {
+	server := newFakeSMTPServer(t, true)
+	notifier, err := NewSMTPNotifier(server.listener.Addr().String(), "noreply@example.com", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = notifier.Notify(ctx, NotificationMessage{UserID: 1, Email: "ada@example.com"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Notify = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Notify returned after %s", elapsed)
+	}
+
+	// Nothing is left waiting on the connection: the client closed it
+	conn := <-server.stalled
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("server read = %v, want EOF from a closed client", err)
+	}
+}
+
+// recordingChannel is a Notifier recording the types it was sent
+type recordingChannel struct {
+	name  string
+	mu    sync.Mutex
+	types []string
+}
+
+func (r *recordingChannel) Name() string { return r.name }
+
+func (r *recordingChannel) Notify(ctx context.Context, msg NotificationMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types = append(r.types, msg.Type)
+	return nil
+}
+
+func (r *recordingChannel) sent() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.types)
+}
+
+func TestNotificationRouting(t *testing.T) {
+	channels := map[string]*recordingChannel{}
+	notifiers := map[string]Notifier{}
+	for _, name := range []string{ChannelLog, ChannelEmail, ChannelWebhook, ChannelFile} {
+		channels[name] = &recordingChannel{name: name}
+		notifiers[name] = channels[name]
+	}
+	routes, err := parseNotificationRoutes("WELCOME=email,file; ACCOUNT_DELETED=email,webhook; *=log", notifiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates, err := NewTemplateSet(defaultTemplates, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &NotificationService{
+		config: &NotificationConfig{Notifiers: notifiers, Routes: routes, Templates: templates, DefaultLocale: "en"},
+	}
+
+	for _, notifType := range []string{NotifyWelcome, NotifyProfileUpdated, NotifyAccountDeleted} {
+		service.deliver(Notification{UserID: 1, Type: notifType, Email: "ada@example.com", Name: "Ada"})
+	}
+
+	want := map[string][]string{
+		ChannelEmail:   {NotifyWelcome, NotifyAccountDeleted},
+		ChannelFile:    {NotifyWelcome},
+		ChannelWebhook: {NotifyAccountDeleted},
+		ChannelLog:     {NotifyProfileUpdated},
+	}
+	for name, types := range want {
+		if got := channels[name].sent(); !slices.Equal(got, types) {
+			t.Errorf("%s channel got %v, want %v", name, got, types)
+		}
+	}
+}
+
+func TestNotificationRoutesRejectUnknownChannel(t *testing.T) {
+	_, err := parseNotificationRoutes("WELCOME=email", map[string]Notifier{ChannelLog: LogNotifier{}})
+	if err == nil {
+		t.Fatal("route to an unconfigured channel accepted")
+	}
+}
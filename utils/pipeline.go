@@ -0,0 +1,515 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-microservice/metrics"
+)
+
+// OverflowPolicy decides what Submit does when a pipeline's queue is full
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits up to BlockTimeout for room, then drops the item
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropOldest discards the oldest queued item to make room
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowDropNewest discards the submitted item
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+	// OverflowSpill appends items to a file in SpillDir until the queue has
+	// drained; spilled items are processed in order and survive a restart.
+	// Without a SpillDir it behaves as OverflowBlock.
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// PipelineConfig sizes a pipeline and sets its overflow policy
+type PipelineConfig struct {
+	// Name labels the pipeline's metrics and names its spill files
+	Name string
+	// Capacity is the queue size, shared out between the workers
+	Capacity int
+	// BatchSize caps how many items are handled at once
+	BatchSize int
+	// FlushInterval is how long a worker waits for a batch to fill before
+	// handling it anyway; zero handles whatever is queued straight away
+	FlushInterval time.Duration
+	// Workers is the number of worker goroutines of a sharded pipeline
+	Workers      int
+	Policy       OverflowPolicy
+	BlockTimeout time.Duration
+	SpillDir     string
+}
+
+// LoadPipelineConfig overrides defaults from the environment:
+// <prefix>_QUEUE_SIZE, <prefix>_BATCH_SIZE, <prefix>_FLUSH_INTERVAL,
+// <prefix>_WORKERS, <prefix>_OVERFLOW (block, drop-oldest, drop-newest or
+// spill), <prefix>_BLOCK_TIMEOUT and ASYNC_SPILL_DIR, which spilling
+// requires. Invalid values are logged and ignored.
+func LoadPipelineConfig(prefix string, defaults PipelineConfig) PipelineConfig {
+	config := defaults
+	if dir := os.Getenv("ASYNC_SPILL_DIR"); dir != "" {
+		config.SpillDir = dir
+	}
+
+	for suffix, target := range map[string]*int{
+		"_QUEUE_SIZE": &config.Capacity,
+		"_BATCH_SIZE": &config.BatchSize,
+		"_WORKERS":    &config.Workers,
+	} {
+		n, err := envInt(prefix+suffix, *target, 1)
+		if err != nil {
+			slog.Warn("Ignoring invalid setting", "error", err)
+			continue
+		}
+		*target = n
+	}
+	if value := os.Getenv(prefix + "_OVERFLOW"); value != "" {
+		switch policy := OverflowPolicy(strings.ToLower(value)); policy {
+		case OverflowBlock, OverflowDropOldest, OverflowDropNewest, OverflowSpill:
+			config.Policy = policy
+		default:
+			slog.Warn("Ignoring invalid setting", "name", prefix+"_OVERFLOW", "value", value)
+		}
+	}
+	for suffix, target := range map[string]*time.Duration{
+		"_BLOCK_TIMEOUT":  &config.BlockTimeout,
+		"_FLUSH_INTERVAL": &config.FlushInterval,
+	} {
+		if value := os.Getenv(prefix + suffix); value != "" {
+			if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+				*target = d
+			} else {
+				slog.Warn("Ignoring invalid setting", "name", prefix+suffix, "value", value)
+			}
+		}
+	}
+	return config
+}
+
+// Pipeline queues items for worker goroutines that hand them to handle in
+// batches. Each item goes to the worker its key hashes to, so items with
+// the same key are handled in submission order. Submit never blocks longer
+// than the overflow policy allows. After Close, items are handled
+// synchronously.
+type Pipeline[T any] struct {
+	config PipelineConfig
+	handle func(batch []T)
+	key    func(item T) string
+	lanes  []*pipelineLane[T]
+
+	// mu guards closed; Submit holds it for reading while queueing so
+	// Close can close the queues safely
+	mu     sync.RWMutex
+	closed bool
+}
+
+// pipelineLane is one worker with its own queue and spill file
+type pipelineLane[T any] struct {
+	p         *Pipeline[T]
+	queue     chan T
+	spillPath string
+
+	// handleMu serializes handle between the worker and callers after Close
+	handleMu sync.Mutex
+
+	// spillMu guards the spill state. While spilling, new items go to the
+	// spill file rather than the queue so their order is kept.
+	spillMu   sync.Mutex
+	spilling  bool
+	spillFile *os.File
+	spillWake chan struct{}
+
+	done chan struct{}
+}
+
+// NewPipeline starts a pipeline with a single worker calling handle for
+// each batch, so all items are handled in submission order. Items spilled
+// to SpillDir by a previous run are handled first.
+func NewPipeline[T any](config PipelineConfig, handle func(batch []T)) *Pipeline[T] {
+	return NewShardedPipeline(config, nil, handle)
+}
+
+// NewShardedPipeline starts a pipeline with config.Workers workers, each
+// item going to the worker key hashes to. handle is called concurrently
+// by different workers. Without a key there is a single worker.
+func NewShardedPipeline[T any](config PipelineConfig, key func(item T) string, handle func(batch []T)) *Pipeline[T] {
+	config.Workers = max(config.Workers, 1)
+	if key == nil {
+		config.Workers = 1
+	}
+	config.Capacity = max(config.Capacity, config.Workers)
+	config.BatchSize = max(config.BatchSize, 1)
+	if config.Policy == "" {
+		config.Policy = OverflowDropNewest
+	}
+
+	p := &Pipeline[T]{config: config, handle: handle, key: key}
+	if config.Policy == OverflowSpill {
+		if config.SpillDir == "" {
+			slog.Warn("Pipeline has no ASYNC_SPILL_DIR, blocking on overflow instead", "pipeline", config.Name)
+			p.config.Policy = OverflowBlock
+		} else if err := os.MkdirAll(config.SpillDir, 0o750); err != nil {
+			slog.Error("Pipeline cannot spill, blocking on overflow instead", "pipeline", config.Name, "error", err)
+			p.config.Policy = OverflowBlock
+		}
+	}
+
+	perLane := (config.Capacity + config.Workers - 1) / config.Workers
+	for i := 0; i < config.Workers; i++ {
+		name := config.Name
+		if i > 0 {
+			name += "." + strconv.Itoa(i)
+		}
+		p.lanes = append(p.lanes, &pipelineLane[T]{
+			p:         p,
+			queue:     make(chan T, perLane),
+			spillPath: filepath.Join(config.SpillDir, name+".spill"),
+			spillWake: make(chan struct{}, 1),
+			done:      make(chan struct{}),
+		})
+	}
+
+	// Spill files of workers that no longer exist, after the worker count
+	// was lowered, are replayed by the first worker before anything else
+	var orphans []string
+	if config.SpillDir != "" {
+		orphans = p.orphanedSpills()
+	}
+	for i, lane := range p.lanes {
+		// Items spilled by a previous run are older than anything queued now
+		recovered := false
+		if config.SpillDir != "" {
+			_, spillErr := os.Stat(lane.spillPath)
+			_, drainErr := os.Stat(lane.spillPath + ".draining")
+			recovered = spillErr == nil || drainErr == nil
+		}
+		lane.spilling = recovered
+
+		if i == 0 {
+			go lane.run(recovered, orphans)
+		} else {
+			go lane.run(recovered, nil)
+		}
+	}
+	return p
+}
+
+// orphanedSpills lists spill files left by workers beyond the current count
+func (p *Pipeline[T]) orphanedSpills() []string {
+	matches, _ := filepath.Glob(filepath.Join(p.config.SpillDir, p.config.Name+".*.spill*"))
+	var orphans []string
+	for _, path := range matches {
+		index := strings.TrimPrefix(filepath.Base(path), p.config.Name+".")
+		index, _, _ = strings.Cut(index, ".")
+		if i, err := strconv.Atoi(index); err == nil && i >= len(p.lanes) {
+			orphans = append(orphans, path)
+		}
+	}
+	return orphans
+}
+
+// lane returns the worker item is handled by
+func (p *Pipeline[T]) lane(item T) *pipelineLane[T] {
+	if len(p.lanes) == 1 {
+		return p.lanes[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(p.key(item)))
+	return p.lanes[h.Sum32()%uint32(len(p.lanes))]
+}
+
+// Submit queues item, applying the overflow policy if the queue is full.
+// It reports whether the item was accepted.
+func (p *Pipeline[T]) Submit(item T) bool {
+	lane := p.lane(item)
+
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		lane.process([]T{item})
+		return true
+	}
+	defer p.mu.RUnlock()
+	defer p.updateDepth()
+
+	if p.config.Policy == OverflowSpill {
+		return lane.submitOrSpill(item)
+	}
+
+	select {
+	case lane.queue <- item:
+		return true
+	default:
+	}
+
+	switch p.config.Policy {
+	case OverflowBlock:
+		timer := time.NewTimer(p.config.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case lane.queue <- item:
+			return true
+		case <-timer.C:
+			metrics.IncrementAsyncDropped(p.config.Name, "timeout")
+			return false
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case <-lane.queue:
+				metrics.IncrementAsyncDropped(p.config.Name, "oldest")
+			default:
+			}
+			select {
+			case lane.queue <- item:
+				return true
+			default:
+			}
+		}
+	default:
+		metrics.IncrementAsyncDropped(p.config.Name, "full")
+		return false
+	}
+}
+
+// updateDepth reports the number of queued items
+func (p *Pipeline[T]) updateDepth() {
+	metrics.SetAsyncQueueDepth(p.config.Name, p.Len())
+}
+
+// submitOrSpill queues item, or appends it to the spill file when the
+// queue is full or earlier items are still spilled
+func (l *pipelineLane[T]) submitOrSpill(item T) bool {
+	l.spillMu.Lock()
+	defer l.spillMu.Unlock()
+
+	if !l.spilling {
+		select {
+		case l.queue <- item:
+			return true
+		default:
+			l.spilling = true
+		}
+	}
+
+	if err := l.spill(item); err != nil {
+		slog.Error("Pipeline failed to spill", "pipeline", l.p.config.Name, "error", err)
+		metrics.IncrementAsyncDropped(l.p.config.Name, "spill_error")
+		return false
+	}
+	metrics.IncrementAsyncSpilled(l.p.config.Name)
+
+	select {
+	case l.spillWake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// spill appends item to the spill file. Callers hold spillMu.
+func (l *pipelineLane[T]) spill(item T) error {
+	line, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if l.spillFile == nil {
+		if l.spillFile, err = os.OpenFile(l.spillPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640); err != nil {
+			return err
+		}
+	}
+	_, err = l.spillFile.Write(append(line, '\n'))
+	return err
+}
+
+// run is the worker: it handles queued items in batches and, once the
+// queue is empty, any spilled ones. Items recovered from a previous run,
+// orphans first, are handled before anything else.
+func (l *pipelineLane[T]) run(recovered bool, orphans []string) {
+	defer close(l.done)
+
+	for _, path := range orphans {
+		l.replay(path)
+	}
+	if recovered {
+		// A file left by a crash mid-drain holds the oldest items
+		if _, err := os.Stat(l.spillPath + ".draining"); err == nil {
+			l.replay(l.spillPath + ".draining")
+		}
+		l.drainSpill()
+	}
+
+	batch := make([]T, 0, l.p.config.BatchSize)
+	for {
+		select {
+		case item, ok := <-l.queue:
+			if !ok {
+				l.drainSpill()
+				return
+			}
+			batch = l.fill(append(batch[:0], item))
+			l.process(batch)
+			l.p.updateDepth()
+		case <-l.spillWake:
+		}
+
+		if len(l.queue) == 0 {
+			l.drainSpill()
+		}
+	}
+}
+
+// fill adds queued items to batch until it is full. With a FlushInterval
+// it waits up to that long for more items; otherwise it takes only those
+// already queued.
+func (l *pipelineLane[T]) fill(batch []T) []T {
+	var timeout <-chan time.Time
+	if interval := l.p.config.FlushInterval; interval > 0 {
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for len(batch) < l.p.config.BatchSize {
+		if timeout == nil {
+			select {
+			case next, ok := <-l.queue:
+				if !ok {
+					return batch
+				}
+				batch = append(batch, next)
+			default:
+				return batch
+			}
+			continue
+		}
+
+		select {
+		case next, ok := <-l.queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, next)
+		case <-timeout:
+			return batch
+		}
+	}
+	return batch
+}
+
+// process hands a batch to handle
+func (l *pipelineLane[T]) process(batch []T) {
+	l.handleMu.Lock()
+	defer l.handleMu.Unlock()
+	l.p.handle(batch)
+}
+
+// drainSpill handles spilled items in order until none are left. The spill
+// file is moved aside while it is read, so Submit can keep spilling.
+func (l *pipelineLane[T]) drainSpill() {
+	draining := l.spillPath + ".draining"
+	for {
+		l.spillMu.Lock()
+		if !l.spilling {
+			l.spillMu.Unlock()
+			return
+		}
+		if l.spillFile != nil {
+			l.spillFile.Close()
+			l.spillFile = nil
+		}
+		info, err := os.Stat(l.spillPath)
+		if err != nil || info.Size() == 0 {
+			os.Remove(l.spillPath)
+			l.spilling = false
+			l.spillMu.Unlock()
+			return
+		}
+		err = os.Rename(l.spillPath, draining)
+		l.spillMu.Unlock()
+		if err != nil {
+			slog.Error("Pipeline failed to drain spill file", "pipeline", l.p.config.Name, "error", err)
+			return
+		}
+
+		l.replay(draining)
+	}
+}
+
+// replay handles the items in a spill file and removes it
+func (l *pipelineLane[T]) replay(path string) {
+	name := l.p.config.Name
+	file, err := os.Open(path)
+	if err != nil {
+		slog.Error("Pipeline failed to read spill file", "pipeline", name, "path", path, "error", err)
+		return
+	}
+
+	batchSize := l.p.config.BatchSize
+	batch := make([]T, 0, batchSize)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		var item T
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			metrics.IncrementAsyncDropped(name, "spill_error")
+			continue
+		}
+		batch = append(batch, item)
+		if len(batch) == batchSize {
+			l.process(batch)
+			batch = make([]T, 0, batchSize)
+		}
+	}
+	if len(batch) > 0 {
+		l.process(batch)
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Error("Pipeline failed to read spill file", "pipeline", name, "path", path, "error", err)
+	}
+
+	file.Close()
+	os.Remove(path)
+}
+
+// Close stops accepting queued items and waits until everything queued or
+// spilled has been handled, or ctx is done. Items submitted afterwards
+// are handled synchronously.
+func (p *Pipeline[T]) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, lane := range p.lanes {
+			close(lane.queue)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, lane := range p.lanes {
+		select {
+		case <-lane.done:
+		case <-ctx.Done():
+			return fmt.Errorf("pipeline %s: %w with %d items queued", p.config.Name, ctx.Err(), p.Len())
+		}
+	}
+	return nil
+}
+
+// Len returns the number of queued items, not counting spilled ones
+func (p *Pipeline[T]) Len() int {
+	n := 0
+	for _, lane := range p.lanes {
+		n += len(lane.queue)
+	}
+	return n
+}
@@ -0,0 +1,384 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// asyncCount returns the value of a pipeline counter, selecting the
+// series by its reason label when reason is set
+func asyncCount(t *testing.T, name, pipeline, reason string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	series:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if (label.GetName() == "pipeline" && label.GetValue() != pipeline) ||
+					(label.GetName() == "reason" && label.GetValue() != reason) {
+					continue series
+				}
+			}
+			return metric.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+// recorder collects handled items and lets a test hold the worker
+type recorder struct {
+	mu    sync.Mutex
+	items []int
+	// gate, while open, blocks handle until it is closed
+	gate chan struct{}
+}
+
+func newRecorder() *recorder {
+	return &recorder{gate: make(chan struct{})}
+}
+
+func (r *recorder) handle(batch []int) {
+	<-r.gate
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.items = append(r.items, batch...)
+}
+
+func (r *recorder) release() { close(r.gate) }
+
+func (r *recorder) handled() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.items...)
+}
+
+// fillPipeline submits items until the queue is full behind a worker held
+// on its first item, returning the submitted items
+func fillPipeline(t *testing.T, p *Pipeline[int]) []int {
+	t.Helper()
+	// The worker takes item 0 and blocks in handle
+	p.Submit(0)
+	deadline := time.Now().Add(time.Second)
+	for p.Len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("worker did not take the first item")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	items := []int{0}
+	for i := 1; i <= p.config.Capacity; i++ {
+		if !p.Submit(i) {
+			t.Fatalf("Submit(%d) rejected before the queue was full", i)
+		}
+		items = append(items, i)
+	}
+	return items
+}
+
+func TestPipelineOverflowPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy   OverflowPolicy
+		accepted bool
+		reason   string
+		want     []int
+	}{
+		{OverflowBlock, false, "timeout", []int{0, 1, 2, 3}},
+		{OverflowDropNewest, false, "full", []int{0, 1, 2, 3}},
+		{OverflowDropOldest, true, "oldest", []int{0, 2, 3, 4}},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			name := "test-" + string(tc.policy)
+			rec := newRecorder()
+			p := NewPipeline(PipelineConfig{
+				Name:         name,
+				Capacity:     3,
+				BatchSize:    10,
+				Policy:       tc.policy,
+				BlockTimeout: 10 * time.Millisecond,
+			}, rec.handle)
+
+			fillPipeline(t, p)
+			dropped := asyncCount(t, "async_dropped_total", name, tc.reason)
+			if accepted := p.Submit(4); accepted != tc.accepted {
+				t.Fatalf("Submit on a full queue = %v, want %v", accepted, tc.accepted)
+			}
+			if got := asyncCount(t, "async_dropped_total", name, tc.reason) - dropped; got != 1 {
+				t.Fatalf("dropped %s = %v, want 1", tc.reason, got)
+			}
+
+			rec.release()
+			if err := p.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got := rec.handled(); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Fatalf("handled %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPipelineBlockWaitsForRoom(t *testing.T) {
+	rec := newRecorder()
+	p := NewPipeline(PipelineConfig{
+		Name:         "test-block-wait",
+		Capacity:     2,
+		Policy:       OverflowBlock,
+		BlockTimeout: time.Minute,
+	}, rec.handle)
+	fillPipeline(t, p)
+
+	accepted := make(chan bool)
+	go func() { accepted <- p.Submit(3) }()
+	select {
+	case <-accepted:
+		t.Fatal("Submit returned while the queue was full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	rec.release()
+	if !<-accepted {
+		t.Fatal("Submit gave up although room was made")
+	}
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.handled(); fmt.Sprint(got) != "[0 1 2 3]" {
+		t.Fatalf("handled %v", got)
+	}
+}
+
+func TestPipelineSpillKeepsOrder(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("ASYNC_SPILL_DIR", dir)
+	rec := newRecorder()
+	p := NewPipeline(LoadPipelineConfig("TEST_SPILL", PipelineConfig{
+		Name:      "test-spill",
+		Capacity:  2,
+		BatchSize: 2,
+		Policy:    OverflowSpill,
+	}), rec.handle)
+
+	items := fillPipeline(t, p)
+	spilled := asyncCount(t, "async_spilled_total", "test-spill", "")
+	// Overflowing items are spilled, and so is everything after them
+	// until the spill file has been drained
+	for i := len(items); i < 10; i++ {
+		if !p.Submit(i) {
+			t.Fatalf("Submit(%d) rejected", i)
+		}
+		items = append(items, i)
+	}
+	if got := asyncCount(t, "async_spilled_total", "test-spill", "") - spilled; got != 7 {
+		t.Fatalf("spilled %v items, want 7", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "test-spill.spill")); err != nil {
+		t.Fatalf("spill file: %v", err)
+	}
+
+	rec.release()
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.handled(); fmt.Sprint(got) != fmt.Sprint(items) {
+		t.Fatalf("handled %v, want %v", got, items)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("spill dir not cleaned up: %v", entries)
+	}
+}
+
+func TestPipelineReplaysSpillOnRestart(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("ASYNC_SPILL_DIR", dir)
+	// A crash left one file mid-drain and a newer spill file
+	if err := os.WriteFile(filepath.Join(dir, "test-restart.spill.draining"), []byte("1\n2\nnot json\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "test-restart.spill"), []byte("3\n4\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := newRecorder()
+	rec.release()
+	p := NewPipeline(LoadPipelineConfig("TEST_RESTART", PipelineConfig{
+		Name:     "test-restart",
+		Capacity: 10,
+		Policy:   OverflowSpill,
+	}), rec.handle)
+	// Items submitted while the old spill is replayed are spilled behind it
+	p.Submit(5)
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rec.handled(); fmt.Sprint(got) != "[1 2 3 4 5]" {
+		t.Fatalf("handled %v, want [1 2 3 4 5]", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("spill dir not cleaned up: %v", entries)
+	}
+}
+
+func TestPipelineSpillRequiresDir(t *testing.T) {
+	t.Setenv("ASYNC_SPILL_DIR", "")
+	config := LoadPipelineConfig("TEST_NODIR", PipelineConfig{Name: "test-nodir", Policy: OverflowSpill})
+	p := NewPipeline(config, func([]int) {})
+	defer p.Close(context.Background())
+
+	if config.SpillDir != "" || p.config.Policy != OverflowBlock {
+		t.Fatalf("spill dir %q, policy %s; want no dir and blocking", config.SpillDir, p.config.Policy)
+	}
+}
+
+func TestPipelineCloseTimesOut(t *testing.T) {
+	rec := newRecorder()
+	p := NewPipeline(PipelineConfig{Name: "test-close-timeout", Capacity: 4}, rec.handle)
+	fillPipeline(t, p)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close = %v, want DeadlineExceeded", err)
+	}
+
+	// The worker still finishes once it can
+	rec.release()
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.handled(); len(got) != 5 {
+		t.Fatalf("handled %v, want 5 items", got)
+	}
+}
+
+func TestPipelineSubmitAfterClose(t *testing.T) {
+	rec := newRecorder()
+	rec.release()
+	p := NewPipeline(PipelineConfig{Name: "test-after-close", Capacity: 4}, rec.handle)
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Handled synchronously rather than lost
+	if !p.Submit(7) {
+		t.Fatal("Submit after Close rejected the item")
+	}
+	if got := rec.handled(); fmt.Sprint(got) != "[7]" {
+		t.Fatalf("handled %v, want [7]", got)
+	}
+}
+
+func TestShardedPipelineKeepsKeyOrder(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[int][]int)
+	p := NewShardedPipeline(PipelineConfig{
+		Name:          "test-sharded",
+		Capacity:      1000,
+		BatchSize:     7,
+		FlushInterval: time.Millisecond,
+		Workers:       4,
+		Policy:        OverflowBlock,
+		BlockTimeout:  time.Minute,
+	}, func(item int) string {
+		return strconv.Itoa(item % 10)
+	}, func(batch []int) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, item := range batch {
+			seen[item%10] = append(seen[item%10], item)
+		}
+	})
+
+	for i := 0; i < 1000; i++ {
+		p.Submit(i)
+	}
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, items := range seen {
+		if len(items) != 100 {
+			t.Fatalf("key %d: handled %d items, want 100", key, len(items))
+		}
+		for i := 1; i < len(items); i++ {
+			if items[i] < items[i-1] {
+				t.Fatalf("key %d handled out of order: %v", key, items)
+			}
+		}
+	}
+}
+
+// BenchmarkPipeline measures Submit throughput of a blocking sharded
+// pipeline, including the flush at Close, with and without batching
+func BenchmarkPipeline(b *testing.B) {
+	for _, bc := range []struct {
+		name    string
+		workers int
+		batch   int
+	}{
+		{"unbatched", 1, 1},
+		{"batched", 1, 100},
+		{"sharded", 4, 100},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var handled atomic.Int64
+			p := NewShardedPipeline(PipelineConfig{
+				Name:          "bench",
+				Capacity:      10000,
+				BatchSize:     bc.batch,
+				FlushInterval: time.Millisecond,
+				Workers:       bc.workers,
+				Policy:        OverflowBlock,
+				BlockTimeout:  time.Minute,
+			}, func(item int) string {
+				return strconv.Itoa(item % 1000)
+			}, func(batch []int) {
+				handled.Add(int64(len(batch)))
+			})
+
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					p.Submit(int(next.Add(1)))
+				}
+			})
+			if err := p.Close(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+
+			if n := handled.Load(); n != int64(b.N) {
+				b.Fatalf("handled %d of %d items", n, b.N)
+			}
+		})
+	}
+}
+
+// waitDrained waits until p has no queued items
+func waitDrained[T any](tb testing.TB, p *Pipeline[T]) {
+	tb.Helper()
+	deadline := time.Now().Add(time.Minute)
+	for p.Len() > 0 {
+		if time.Now().After(deadline) {
+			tb.Fatalf("pipeline %s not drained: %d items queued", p.config.Name, p.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
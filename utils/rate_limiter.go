@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"log/slog"
 	"net/http"
 
 	"golang.org/x/time/rate"
@@ -33,8 +34,8 @@ func RateLimitMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !globalLimiter.Allow() {
 			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			// Log rate limit hit asynchronously
-			go LogError("rate_limit", nil, "Rate limit exceeded for request: "+r.URL.Path)
+			// Repeats are sampled by the application logger
+			slog.WarnContext(r.Context(), "Rate limit exceeded")
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		clientID string
+		keep     bool
+	}{
+		{"client ID", "req-1.a_b:2", true},
+		{"no ID", "", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"unsafe characters", "req 1\r\nX-Injected: yes", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var seen, audited string
+			handler := RequestIDMiddleware(AuditContextMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = RequestIDFromContext(r.Context())
+				audited = NewAuditEntry(r.Context(), "TEST", 0).RequestID
+			})))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.clientID != "" {
+				req.Header.Set(RequestIDHeader, tc.clientID)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			echoed := rec.Header().Get(RequestIDHeader)
+			if tc.keep && echoed != tc.clientID {
+				t.Fatalf("echoed %q, want the client's %q", echoed, tc.clientID)
+			}
+			if !tc.keep && (echoed == tc.clientID || len(echoed) != 32) {
+				t.Fatalf("echoed %q, want a generated ID", echoed)
+			}
+			if seen != echoed || audited != echoed {
+				t.Fatalf("context ID %q, audit ID %q, want %q", seen, audited, echoed)
+			}
+		})
+	}
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	if id := RequestIDFromContext(context.Background()); id != "" {
+		t.Fatalf("ID outside a request = %q", id)
+	}
+	ctx := WithRequestID(context.Background(), "req-1")
+	if id := RequestIDFromContext(ctx); id != "req-1" {
+		t.Fatalf("ID = %q, want req-1", id)
+	}
+	if a, b := NewRequestID(), NewRequestID(); a == b {
+		t.Fatalf("generated the same ID twice: %s", a)
+	}
+}
+
+func TestWebhookNotifierSendsRequestID(t *testing.T) {
+	got := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get(RequestIDHeader)
+	}))
+	defer server.Close()
+
+	err := NewWebhookNotifier(server.URL, "").Notify(context.Background(), NotificationMessage{UserID: 1, RequestID: "req-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := <-got; id != "req-1" {
+		t.Fatalf("%s = %q, want req-1", RequestIDHeader, id)
+	}
+}
@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace exporters selectable with OTEL_TRACES_EXPORTER
+const (
+	TraceExporterNone = "none"
+	TraceExporterOTLP = "otlp"
+)
+
+// tracerName is the instrumentation scope of the service's spans
+const tracerName = "go-microservice"
+
+// TracingConfig configures tracing
+type TracingConfig struct {
+	// Exporter is TraceExporterNone or TraceExporterOTLP
+	Exporter    string
+	ServiceName string
+	// SampleRatio is the fraction of new traces recorded. Traces started
+	// by a caller follow the caller's sampling decision.
+	SampleRatio float64
+}
+
+// LoadTracingConfig reads the tracing settings: OTEL_TRACES_EXPORTER (none
+// or otlp; default none), OTEL_SERVICE_NAME (default go-microservice) and
+// OTEL_TRACES_SAMPLER_ARG (0 to 1; default 1). The OTLP exporter reads its
+// endpoint, headers and timeout from the standard OTEL_EXPORTER_OTLP_
+// variables.
+func LoadTracingConfig() (TracingConfig, error) {
+	config := TracingConfig{
+		Exporter:    TraceExporterNone,
+		ServiceName: tracerName,
+		SampleRatio: 1,
+	}
+
+	switch exporter := strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER")); exporter {
+	case "", TraceExporterNone:
+	case TraceExporterOTLP:
+		config.Exporter = TraceExporterOTLP
+	default:
+		return config, fmt.Errorf("invalid OTEL_TRACES_EXPORTER: %q", exporter)
+	}
+	if value := os.Getenv("OTEL_SERVICE_NAME"); value != "" {
+		config.ServiceName = value
+	}
+	if value := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); value != "" {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return config, fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG: %q", value)
+		}
+		config.SampleRatio = ratio
+	}
+	return config, nil
+}
+
+// ConfigureTracing installs the W3C trace context propagator and, unless
+// the exporter is none, a tracer provider exporting to the configured
+// exporter. The returned function flushes and stops the provider.
+func ConfigureTracing(ctx context.Context, config TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	if config.Exporter != TraceExporterOTLP {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+	provider := NewTracerProvider(config, exporter)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// NewTracerProvider creates a tracer provider batching spans to exporter,
+// such as a tracetest.InMemoryExporter in tests. Install it with
+// otel.SetTracerProvider.
+func NewTracerProvider(config TracingConfig, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(config.ServiceName))),
+	)
+}
+
+// StartSpan starts a span named name as a child of the span in ctx
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan ends span, marking it failed with err unless err is nil
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectTraceContext adds the traceparent of the span in ctx to header,
+// for outgoing requests
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// TracingMiddleware starts a server span for each request, continuing the
+// trace of an incoming traceparent header. Spans are named after the
+// method and route template. It goes after RequestIDMiddleware and before
+// the metrics middleware, so request durations get trace exemplars.
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := RouteTemplate(r)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := otel.Tracer(tracerName).Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(ClientIP(r)),
+				semconv.UserAgentOriginal(r.UserAgent()),
+				attribute.String("request.id", RequestIDFromContext(r.Context())),
+			))
+		defer span.End()
+
+		recorder := newStatusRecorder(w)
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.Status()))
+		if recorder.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.Status()))
+		}
+	})
+}
+
+// RouteTemplate returns the template of the route matching r, or its path
+// outside the router
+func RouteTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return r.URL.Path
+}
+
+// statusRecorder wraps a ResponseWriter to capture the status code and
+// the number of body bytes written
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// newStatusRecorder wraps w
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w}
+}
+
+// WriteHeader captures the status code
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Write counts body bytes, implying a 200 status if none was written
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController, so
+// handlers can flush streamed responses
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Status returns the response status, 200 if the handler wrote nothing
+func (s *statusRecorder) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+// BytesWritten returns the number of body bytes written
+func (s *statusRecorder) BytesWritten() int {
+	return s.bytes
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// useInMemoryTracing installs a tracer provider recording to an in-memory
+// exporter for the duration of the test. Call flush before reading spans.
+func useInMemoryTracing(t *testing.T) (exporter *tracetest.InMemoryExporter, flush func()) {
+	t.Helper()
+	if _, err := ConfigureTracing(context.Background(), TracingConfig{Exporter: TraceExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+	exporter = tracetest.NewInMemoryExporter()
+	provider := NewTracerProvider(TracingConfig{ServiceName: "test", SampleRatio: 1}, exporter)
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return exporter, func() { provider.ForceFlush(context.Background()) }
+}
+
+func TestTracingMiddlewareExtractsTraceparent(t *testing.T) {
+	exporter, flush := useInMemoryTracing(t)
+
+	var handlerSpan trace.SpanContext
+	handler := TracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	flush()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "GET /api/users" || span.SpanKind != trace.SpanKindServer {
+		t.Errorf("span = %s (%v)", span.Name, span.SpanKind)
+	}
+	if span.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || span.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("span does not continue the incoming trace: %s parent %s", span.SpanContext.TraceID(), span.Parent.SpanID())
+	}
+	if handlerSpan.SpanID() != span.SpanContext.SpanID() {
+		t.Errorf("handler context carries span %s, want %s", handlerSpan.SpanID(), span.SpanContext.SpanID())
+	}
+	if span.Status.Code != codes.Error {
+		t.Errorf("5xx span status = %v", span.Status)
+	}
+}
+
+func TestWebhookNotifierInjectsTraceparent(t *testing.T) {
+	exporter, flush := useInMemoryTracing(t)
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	ctx, parent := StartSpan(context.Background(), "parent")
+	err := NewWebhookNotifier(server.URL, "").Notify(ctx, NotificationMessage{Type: NotifyWelcome})
+	parent.End()
+	if err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	flush()
+
+	var notify tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "notify webhook" {
+			notify = span
+		}
+	}
+	if notify.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("notify span parent = %s, want %s", notify.Parent.SpanID(), parent.SpanContext().SpanID())
+	}
+
+	// The receiver continues the trace from the notify span
+	remote := trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(received)))
+	if !remote.IsValid() || remote.TraceID() != notify.SpanContext.TraceID() || remote.SpanID() != notify.SpanContext.SpanID() {
+		t.Fatalf("traceparent %q does not name the notify span %s", received.Get("traceparent"), notify.SpanContext.SpanID())
+	}
+}